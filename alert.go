@@ -18,75 +18,729 @@
 package main
 
 import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
 	"sync"
 	"time"
 )
 
+const (
+	ALERT_DURATION = 5000 // milliseconds
+
+	// How long to wait for the overlays to report that they have finished
+	// showing an alert, after that the next alert is shown anyway.
+	ALERT_GRACE = 5 * time.Second
+
+	// A subscriber which has not read this many messages is dropped.
+	ALERT_SUBSCRIBER_BUFFER = 16
+
+	// The alerts which have been shown are appended to this file in the
+	// config directory, only the most recent ones are kept in memory. The
+	// file is cut down to them when it is loaded and whenever it grows to
+	// ALERT_HISTORY_COMPACT_AT lines.
+	ALERT_HISTORY_FILE       = "alerts.jsonl"
+	ALERT_HISTORY_SIZE       = 1000
+	ALERT_HISTORY_COMPACT_AT = 2 * ALERT_HISTORY_SIZE
+)
+
+// Alerts without a channel are shown on the /alerts page without ?channel=.
+const DEFAULT_ALERT_CHANNEL = "default"
+
+var alertChannelName = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+const (
+	ALERT_SHOW = "show"
+	ALERT_HIDE = "hide"
+)
+
+// Where the sounds of the alerts are played: on the default audio device of
+// the zdrct host or by the /alerts pages, so OBS can capture them as a
+// separate audio source.
+const (
+	ALERT_AUDIO_LOCAL   = "local"
+	ALERT_AUDIO_OVERLAY = "overlay"
+)
+
+var ALERT_AUDIO_OUTPUTS = []string{ALERT_AUDIO_LOCAL, ALERT_AUDIO_OVERLAY}
+
+type AlertEvent struct {
+	ID       uint64 `json:"id"`
+	Text     string `json:"text"`
+	Image    string `json:"image,omitempty"`
+	Sound    string `json:"sound,omitempty"`
+	Duration int    `json:"duration"` // milliseconds
+	Priority int    `json:"priority,omitempty"`
+	Channel  string `json:"channel,omitempty"`
+
+	// The look of the alert, see AlertStyles.
+	Style     string                 `json:"style,omitempty"`
+	Position  string                 `json:"position,omitempty"`
+	Animation string                 `json:"animation,omitempty"`
+	Vars      map[string]interface{} `json:"vars,omitempty"`
+
+	// The viewer and the chat command which have triggered the alert.
+	From    string `json:"from,omitempty"`
+	Command string `json:"command,omitempty"`
+
+	// When the alert has been shown.
+	Time time.Time `json:"time"`
+	// The ID of the original alert if this one is a replay.
+	Replay uint64 `json:"replay,omitempty"`
+
+	volume int
+	html   string
+	css    string
+}
+
+// AlertMessage is sent to the overlays, Type is either ALERT_SHOW or
+// ALERT_HIDE (when the alert has been skipped).
+type AlertMessage struct {
+	Type string `json:"type"`
+	AlertEvent
+
+	// The rendered style of the alert and the URL of its stylesheet.
+	HTML string `json:"html,omitempty"`
+	CSS  string `json:"css,omitempty"`
+
+	// The sound to be played by the overlay and its volume in percents.
+	SoundURL string `json:"sound_url,omitempty"`
+	Volume   int    `json:"volume"`
+}
+
+type AlertSubscriber struct {
+	C <-chan AlertMessage
+
+	ch      chan AlertMessage
+	channel *alertChannel
+}
+
+// AlertQueue is a snapshot of the alert queue of a channel.
+type AlertQueue struct {
+	Channel  string       `json:"channel"`
+	Overlays int          `json:"overlays"`
+	Current  *AlertEvent  `json:"current"`
+	Queue    []AlertEvent `json:"queue"`
+}
+
+type alertChannel struct {
+	name        string
+	queue       []*AlertEvent
+	current     *AlertEvent
+	pending     map[*AlertSubscriber]bool
+	subscribers map[*AlertSubscriber]bool
+	timer       *time.Timer
+}
+
+// Alerter shows alerts one after another, every overlay channel has its own
+// queue. An alert is finished when all the overlays which have been showing
+// it report that they are done, or when its duration (plus ALERT_GRACE if
+// there are overlays) has passed.
 type Alerter struct {
-	lastEvent *AlertEvent
-	mu        *sync.Mutex
-	cv        *sync.Cond
-	Sound     *Sound
+	Sound  *Sound
+	Styles *AlertStyles
+
+	audioOutput string
+	audioVolume int
+
+	channels     map[string]*alertChannel
+	lastID       uint64
+	history      []AlertEvent
+	historyFile  *os.File
+	historyPath  string
+	historyLines int
+	mu           sync.Mutex
 }
 
-type AlertEvent struct {
-	Text  string `json:"text"`
-	Image string `json:"image,omitempty"`
-	Sound string `json:"sound,omitempty"`
+// NewAlertEvent builds an alert from the arguments of the alert builtin: an
+// optional image and sound followed by an optional map of options (duration
+// in seconds, priority, style, position, animation and vars for the style
+// template).
+func NewAlertEvent(text string, args []interface{}) (AlertEvent, error) {
+	alert := AlertEvent{Text: text}
+
+	if len(args) > 0 {
+		if opts, ok := args[len(args)-1].(map[interface{}]interface{}); ok {
+			args = args[:len(args)-1]
+			for key, value := range opts {
+				switch key {
+				case "duration":
+					var seconds float64
+					switch v := value.(type) {
+					case int64:
+						seconds = float64(v)
+					case float64:
+						seconds = v
+					}
+					if seconds <= 0 {
+						return alert, fmt.Errorf("duration must be a positive number, got %v", value)
+					}
+					alert.Duration = int(seconds * 1000)
+				case "priority":
+					n, err := gameIntArg("priority", value)
+					if err != nil {
+						return alert, err
+					}
+					alert.Priority = int(n)
+				case "style", "position", "animation":
+					v, ok := value.(string)
+					if !ok {
+						return alert, fmt.Errorf("%s must be a string, got %T", key, value)
+					}
+					var err error
+					switch key {
+					case "style":
+						alert.Style, err = v, CheckAlertStyle(v)
+					case "position":
+						alert.Position, err = v, checkAlertOption("position", v, ALERT_POSITIONS)
+					case "animation":
+						alert.Animation, err = v, checkAlertOption("animation", v, ALERT_ANIMATIONS)
+					}
+					if err != nil {
+						return alert, err
+					}
+				case "vars":
+					vars, ok := value.(map[interface{}]interface{})
+					if !ok {
+						return alert, fmt.Errorf("vars must be a map, got %T", value)
+					}
+					alert.Vars = make(map[string]interface{}, len(vars))
+					for k, v := range vars {
+						alert.Vars[fmt.Sprint(k)] = v
+					}
+				default:
+					return alert, fmt.Errorf("unknown option: %v", key)
+				}
+			}
+		}
+	}
+
+	if len(args) > 2 {
+		return alert, fmt.Errorf("too many arguments")
+	}
+	for i, arg := range args {
+		s, ok := arg.(string)
+		if !ok {
+			return alert, fmt.Errorf("image and sound must be strings, got %T", arg)
+		}
+		if i == 0 {
+			alert.Image = s
+		} else {
+			alert.Sound = s
+		}
+	}
+
+	return alert, nil
 }
 
 func NewAlerter() *Alerter {
-	a := &Alerter{}
-	a.mu = new(sync.Mutex)
-	a.cv = sync.NewCond(a.mu)
-	return a
+	return &Alerter{
+		audioOutput: ALERT_AUDIO_LOCAL,
+		audioVolume: SOUND_VOLUME,
+		channels:    make(map[string]*alertChannel),
+	}
 }
 
-func (a *Alerter) Broadcast(event AlertEvent, volume int) {
+// SetAudio chooses where the sounds of the alerts are played, the volume
+// (in percents) is used by the overlays, local playback goes through the
+// mixer which has its own volumes.
+func (a *Alerter) SetAudio(output string, volume int) error {
+	if err := checkAlertOption("alert audio output", output, ALERT_AUDIO_OUTPUTS); err != nil {
+		return err
+	}
+
 	a.mu.Lock()
-	a.lastEvent = &event
-	a.mu.Unlock()
+	defer a.mu.Unlock()
+
+	a.audioOutput = output
+	a.audioVolume = volume
+	return nil
+}
+
+// soundURL returns the URL the overlays can fetch the sound from, only the
+// files from the assets directories are served.
+func soundURL(filename string) (string, bool) {
+	if _, ok := LookupAsset(filename); !ok || filepath.Base(filename) != filename {
+		return "", false
+	}
+
+	return "/" + url.PathEscape(filename), true
+}
+
+func CheckAlertChannel(name string) error {
+	if !alertChannelName.MatchString(name) {
+		return fmt.Errorf("invalid alert channel name: %q", name)
+	}
+	return nil
+}
+
+// channel returns the channel with the given name, creating it if needed.
+// Must be called with a.mu held.
+func (a *Alerter) channel(name string) *alertChannel {
+	if name == "" {
+		name = DEFAULT_ALERT_CHANNEL
+	}
+
+	ch := a.channels[name]
+	if ch == nil {
+		ch = &alertChannel{
+			name:        name,
+			subscribers: make(map[*AlertSubscriber]bool),
+		}
+		a.channels[name] = ch
+	}
+
+	return ch
+}
+
+// Broadcast puts the alert into the queue of its channel and returns its ID.
+// Alerts with a higher priority are shown first, alerts of the same priority
+// are shown in the order they were added.
+func (a *Alerter) Broadcast(event AlertEvent, volume int) uint64 {
+	if event.Style != "" && a.Styles != nil {
+		a.render(&event)
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
 
-	a.cv.Broadcast()
+	ch := a.channel(event.Channel)
 
-	if event.Sound != "" {
-		a.Sound.Play(event.Sound, volume)
+	a.lastID++
+	event.ID = a.lastID
+	event.Channel = ch.name
+	event.volume = volume
+	if event.Duration <= 0 {
+		event.Duration = ALERT_DURATION
+	}
+
+	ch.queue = append(ch.queue, &event)
+	ch.sortQueue()
+	a.next(ch)
+
+	return event.ID
+}
+
+func (ch *alertChannel) sortQueue() {
+	sort.SliceStable(ch.queue, func(i, j int) bool {
+		return ch.queue[i].Priority > ch.queue[j].Priority
+	})
+}
+
+// render falls back to the default look if the style is broken.
+func (a *Alerter) render(event *AlertEvent) {
+	html, err := a.Styles.Render(*event)
+	if err != nil {
+		log.Printf("cannot render the alert style: %s", err)
+		return
+	}
+
+	event.html = html
+	if _, err := a.Styles.File(event.Style + ".css"); err == nil {
+		event.css = "/alerts/styles/" + event.Style + ".css"
 	}
 }
 
-func (a *Alerter) Subscribe() <-chan AlertEvent {
-	ch := make(chan AlertEvent)
-	go func(ch chan AlertEvent) {
+// next shows the next alert of the channel if nothing is being shown, must
+// be called with a.mu held.
+func (a *Alerter) next(ch *alertChannel) {
+	if ch.current != nil || len(ch.queue) == 0 {
+		return
+	}
+
+	event := ch.queue[0]
+	ch.queue = ch.queue[1:]
+	ch.current = event
+	event.Time = time.Now()
+	a.remember(*event)
+
+	var sound string
+	if event.Sound != "" && a.audioOutput == ALERT_AUDIO_OVERLAY {
+		var ok bool
+		if sound, ok = soundURL(event.Sound); !ok {
+			log.Printf("alert %d: %q is not an asset, playing it locally", event.ID, event.Sound)
+		}
+	}
+
+	ch.pending = make(map[*AlertSubscriber]bool)
+	for sub := range ch.subscribers {
+		msg := AlertMessage{
+			Type:       ALERT_SHOW,
+			AlertEvent: *event,
+			HTML:       event.html,
+			CSS:        event.css,
+			SoundURL:   sound,
+			Volume:     event.volume * a.audioVolume / 100,
+		}
+		if a.send(sub, msg) {
+			ch.pending[sub] = true
+		}
+	}
+
+	timeout := time.Duration(event.Duration) * time.Millisecond
+	if len(ch.pending) > 0 {
+		timeout += ALERT_GRACE
+	}
+	id := event.ID
+	ch.timer = time.AfterFunc(timeout, func() {
 		a.mu.Lock()
-		last_event := a.lastEvent
-		a.mu.Unlock()
+		defer a.mu.Unlock()
 
-		running := true
-		for running {
-			a.mu.Lock()
-			var event *AlertEvent
-			for {
-				event = a.lastEvent
-				if event != nil && event != last_event {
-					break
-				}
-				a.cv.Wait()
+		if ch.current != nil && ch.current.ID == id {
+			if len(ch.pending) > 0 {
+				log.Printf("alert %d: %d overlay(s) have not reported back", id, len(ch.pending))
+			}
+			a.finish(ch)
+		}
+	})
+
+	// nobody would hear the sound if no overlay is connected
+	if sound != "" && len(ch.pending) == 0 {
+		log.Printf("alert %d: no overlays on channel %q, playing %q locally", event.ID, ch.name, event.Sound)
+		sound = ""
+	}
+
+	if event.Sound != "" && sound == "" && a.Sound != nil {
+		go func(filename string, volume int) {
+			if err := a.Sound.Play(SOUND_ALERTS, filename, volume); err != nil {
+				log.Printf("cannot play %q: %s", filename, err)
+			}
+		}(event.Sound, event.volume)
+	}
+}
+
+// finish ends the current alert of the channel, must be called with a.mu
+// held.
+func (a *Alerter) finish(ch *alertChannel) {
+	if ch.timer != nil {
+		ch.timer.Stop()
+		ch.timer = nil
+	}
+	ch.current = nil
+	ch.pending = nil
+	a.next(ch)
+}
+
+// send must be called with a.mu held, a subscriber which does not keep up is
+// dropped.
+func (a *Alerter) send(sub *AlertSubscriber, msg AlertMessage) bool {
+	select {
+	case sub.ch <- msg:
+		return true
+	default:
+		log.Printf("dropping a slow alert subscriber of %q", sub.channel.name)
+		a.unsubscribe(sub)
+		return false
+	}
+}
+
+// Subscribe returns a subscriber which gets the alerts of the channel.
+func (a *Alerter) Subscribe(channel string) *AlertSubscriber {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	ch := make(chan AlertMessage, ALERT_SUBSCRIBER_BUFFER)
+	sub := &AlertSubscriber{C: ch, ch: ch, channel: a.channel(channel)}
+	sub.channel.subscribers[sub] = true
+
+	return sub
+}
+
+// Unsubscribe closes sub.C, it is safe to call it more than once.
+func (a *Alerter) Unsubscribe(sub *AlertSubscriber) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.unsubscribe(sub)
+}
+
+func (a *Alerter) unsubscribe(sub *AlertSubscriber) {
+	ch := sub.channel
+	if !ch.subscribers[sub] {
+		return
+	}
+
+	delete(ch.subscribers, sub)
+	// the timer takes care of the current alert if nobody else is left
+	delete(ch.pending, sub)
+	close(sub.ch)
+}
+
+// Done is called when the overlay has finished showing the alert.
+func (a *Alerter) Done(sub *AlertSubscriber, id uint64) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	ch := sub.channel
+	if ch.current == nil || ch.current.ID != id || !ch.pending[sub] {
+		return
+	}
+
+	delete(ch.pending, sub)
+	if len(ch.pending) == 0 {
+		a.finish(ch)
+	}
+}
+
+// Skip hides the alert if it is being shown, or removes it from the queue.
+func (a *Alerter) Skip(id uint64) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	for _, ch := range a.channels {
+		if ch.current != nil && ch.current.ID == id {
+			a.hide(ch)
+			return true
+		}
+
+		for i, event := range ch.queue {
+			if event.ID == id {
+				ch.queue = append(ch.queue[:i], ch.queue[i+1:]...)
+				return true
 			}
-			a.mu.Unlock()
-
-			last_event = event
-			t := time.NewTimer(time.Second)
-			select {
-			case <-t.C:
-				// timed out
-				running = false
-			case ch <- *event:
-				// done
+		}
+	}
+
+	return false
+}
+
+// SkipCurrent hides the alert which is being shown in the channel, unknown
+// channels are not created.
+func (a *Alerter) SkipCurrent(channel string) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if channel == "" {
+		channel = DEFAULT_ALERT_CHANNEL
+	}
+	ch := a.channels[channel]
+	if ch == nil || ch.current == nil {
+		return false
+	}
+
+	a.hide(ch)
+	return true
+}
+
+// hide must be called with a.mu held.
+func (a *Alerter) hide(ch *alertChannel) {
+	for sub := range ch.subscribers {
+		a.send(sub, AlertMessage{Type: ALERT_HIDE, AlertEvent: *ch.current})
+	}
+	a.finish(ch)
+}
+
+// SetPriority changes the priority of a queued alert.
+func (a *Alerter) SetPriority(id uint64, priority int) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	for _, ch := range a.channels {
+		for _, event := range ch.queue {
+			if event.ID == id {
+				event.Priority = priority
+				ch.sortQueue()
+				return true
 			}
-			t.Stop()
 		}
-	}(ch)
-	return ch
+	}
+
+	return false
+}
+
+// Queues returns the queues of all channels sorted by name.
+func (a *Alerter) Queues() []AlertQueue {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	result := make([]AlertQueue, 0, len(a.channels))
+	for _, ch := range a.channels {
+		q := AlertQueue{
+			Channel:  ch.name,
+			Overlays: len(ch.subscribers),
+			Queue:    make([]AlertEvent, len(ch.queue)),
+		}
+		if ch.current != nil {
+			current := *ch.current
+			q.Current = &current
+		}
+		for i, event := range ch.queue {
+			q.Queue[i] = *event
+		}
+		result = append(result, q)
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Channel < result[j].Channel
+	})
+
+	return result
+}
+
+// LoadHistory reads the alerts which have been shown before and appends the
+// new ones to the same file.
+func (a *Alerter) LoadHistory(filename string) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	lines := 0
+	f, err := os.Open(filename)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	if err == nil {
+		defer f.Close()
+
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			lines++
+
+			var event AlertEvent
+			if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+				log.Printf("%s: %s", filename, err)
+				continue
+			}
+			a.history = append(a.history, event)
+			if event.ID > a.lastID {
+				a.lastID = event.ID
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			return err
+		}
+
+		if len(a.history) > ALERT_HISTORY_SIZE {
+			a.history = append([]AlertEvent(nil), a.history[len(a.history)-ALERT_HISTORY_SIZE:]...)
+		}
+	}
+
+	a.historyPath = filename
+	if lines > len(a.history) {
+		return a.compactHistory()
+	}
+
+	a.historyLines = lines
+	a.historyFile, err = os.OpenFile(filename, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
+	return err
+}
+
+// compactHistory rewrites the history file with the alerts kept in memory,
+// must be called with a.mu held. The old file is kept if it cannot be
+// replaced.
+func (a *Alerter) compactHistory() error {
+	var buf bytes.Buffer
+	for _, event := range a.history {
+		data, err := json.Marshal(event)
+		if err != nil {
+			return err
+		}
+		buf.Write(append(data, '\n'))
+	}
+
+	tmp := a.historyPath + ".tmp"
+	if err := os.WriteFile(tmp, buf.Bytes(), 0666); err != nil {
+		return err
+	}
+
+	// an open file cannot be replaced on Windows
+	if a.historyFile != nil {
+		a.historyFile.Close()
+		a.historyFile = nil
+	}
+
+	err := os.Rename(tmp, a.historyPath)
+	if err != nil {
+		os.Remove(tmp)
+	} else {
+		a.historyLines = len(a.history)
+	}
+
+	f, ferr := os.OpenFile(a.historyPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
+	if ferr != nil {
+		return ferr
+	}
+	a.historyFile = f
+
+	return err
+}
+
+// remember must be called with a.mu held.
+func (a *Alerter) remember(event AlertEvent) {
+	a.history = append(a.history, event)
+	if len(a.history) > ALERT_HISTORY_SIZE {
+		a.history = a.history[len(a.history)-ALERT_HISTORY_SIZE:]
+	}
+
+	if a.historyFile == nil {
+		return
+	}
+
+	data, err := json.Marshal(event)
+	if err == nil {
+		_, err = a.historyFile.Write(append(data, '\n'))
+	}
+	if err != nil {
+		log.Printf("cannot save the alert history: %s", err)
+		return
+	}
+
+	a.historyLines++
+	if a.historyLines >= ALERT_HISTORY_COMPACT_AT {
+		if err := a.compactHistory(); err != nil {
+			log.Printf("cannot compact the alert history: %s", err)
+		}
+	}
+}
+
+// History returns up to n alerts which have been shown, the newest one first.
+func (a *Alerter) History(n int) []AlertEvent {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if n <= 0 || n > len(a.history) {
+		n = len(a.history)
+	}
+
+	result := make([]AlertEvent, n)
+	for i := range result {
+		result[i] = a.history[len(a.history)-1-i]
+	}
+
+	return result
+}
+
+// Replay puts an alert from the history into the queue once again.
+func (a *Alerter) Replay(id uint64, volume int) (uint64, error) {
+	a.mu.Lock()
+	var event *AlertEvent
+	for i := len(a.history) - 1; i >= 0; i-- {
+		if a.history[i].ID == id {
+			event = &a.history[i]
+			break
+		}
+	}
+	if event == nil {
+		a.mu.Unlock()
+		return 0, fmt.Errorf("no such alert in the history: %d", id)
+	}
+
+	replay := *event
+	if replay.Replay == 0 {
+		replay.Replay = replay.ID
+	}
+	replay.Time = time.Time{}
+	a.mu.Unlock()
+
+	return a.Broadcast(replay, volume), nil
 }
 
 // vim: ai:ts=8:sw=8:noet:syntax=go
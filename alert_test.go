@@ -0,0 +1,398 @@
+/**
+ * Copyright 2025 kmeaw
+ *
+ * Licensed under the GNU Affero General Public License (AGPL).
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU Affero General Public License as published by the
+ * Free Software Foundation, version 3 of the License.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or
+ * FITNESS FOR A PARTICULAR PURPOSE.  See the GNU Affero General Public License
+ * for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func receiveAlert(t *testing.T, sub *AlertSubscriber) AlertMessage {
+	t.Helper()
+
+	select {
+	case msg, ok := <-sub.C:
+		if !ok {
+			t.Fatal("the subscriber has been dropped")
+		}
+		return msg
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for an alert")
+	}
+	return AlertMessage{}
+}
+
+func TestAlerterQueue(t *testing.T) {
+	a := NewAlerter()
+	sub := a.Subscribe(DEFAULT_ALERT_CHANNEL)
+	defer a.Unsubscribe(sub)
+
+	first := a.Broadcast(AlertEvent{Text: "first"}, 100)
+	a.Broadcast(AlertEvent{Text: "second"}, 100)
+	urgent := a.Broadcast(AlertEvent{Text: "urgent", Priority: 10}, 100)
+	last := a.Broadcast(AlertEvent{Text: "last"}, 100)
+
+	msg := receiveAlert(t, sub)
+	if msg.Type != ALERT_SHOW || msg.ID != first || msg.Duration != ALERT_DURATION {
+		t.Fatalf("got %+v, want the first alert", msg)
+	}
+
+	q := a.Queues()[0]
+	if q.Current == nil || q.Current.ID != first || len(q.Queue) != 3 || q.Queue[0].ID != urgent {
+		t.Errorf("queue is %+v", q)
+	}
+
+	// nothing else is shown until the overlay is done
+	select {
+	case msg := <-sub.C:
+		t.Fatalf("got %+v while the first alert is shown", msg)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	a.Done(sub, first)
+	if msg := receiveAlert(t, sub); msg.ID != urgent {
+		t.Fatalf("got %+v, want the urgent alert", msg)
+	}
+
+	if !a.SetPriority(last, 5) {
+		t.Error("SetPriority has failed")
+	}
+	if a.SkipCurrent("nowhere") {
+		t.Error("Skip of an unknown channel has succeeded")
+	}
+	a.mu.Lock()
+	_, created := a.channels["nowhere"]
+	a.mu.Unlock()
+	if created {
+		t.Error("Skip has created an unknown channel")
+	}
+	if !a.SkipCurrent("") {
+		t.Error("Skip has failed")
+	}
+	if msg := receiveAlert(t, sub); msg.Type != ALERT_HIDE || msg.ID != urgent {
+		t.Fatalf("got %+v, want the urgent alert to be hidden", msg)
+	}
+	if msg := receiveAlert(t, sub); msg.ID != last {
+		t.Fatalf("got %+v, want the last alert", msg)
+	}
+
+	a.Done(sub, last)
+	if msg := receiveAlert(t, sub); msg.Text != "second" {
+		t.Fatalf("got %+v, want the second alert", msg)
+	}
+}
+
+func TestAlerterWithoutOverlays(t *testing.T) {
+	a := NewAlerter()
+	a.Broadcast(AlertEvent{Text: "first", Duration: 10}, 100)
+	a.Broadcast(AlertEvent{Text: "second", Duration: 10}, 100)
+
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(5 * time.Millisecond) {
+		q := a.Queues()[0]
+		if q.Current == nil && len(q.Queue) == 0 {
+			return
+		}
+	}
+	t.Fatalf("the queue has not been drained: %+v", a.Queues())
+}
+
+func TestAlerterChannels(t *testing.T) {
+	a := NewAlerter()
+	spawns := a.Subscribe("spawns")
+	defer a.Unsubscribe(spawns)
+	tts := a.Subscribe("tts")
+	defer a.Unsubscribe(tts)
+
+	first := a.Broadcast(AlertEvent{Text: "imp", Channel: "spawns"}, 100)
+	a.Broadcast(AlertEvent{Text: "baron", Channel: "spawns"}, 100)
+	a.Broadcast(AlertEvent{Text: "hello", Channel: "tts"}, 100)
+	a.Broadcast(AlertEvent{Text: "nobody is watching"}, 100)
+
+	// the channels do not wait for each other
+	if msg := receiveAlert(t, spawns); msg.Text != "imp" || msg.Channel != "spawns" {
+		t.Errorf("spawns got %+v", msg)
+	}
+	if msg := receiveAlert(t, tts); msg.Text != "hello" {
+		t.Errorf("tts got %+v", msg)
+	}
+
+	a.Done(tts, first) // a wrong channel
+	select {
+	case msg := <-spawns.C:
+		t.Fatalf("spawns got %+v before the imp is done", msg)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	a.Done(spawns, first)
+	if msg := receiveAlert(t, spawns); msg.Text != "baron" {
+		t.Errorf("spawns got %+v", msg)
+	}
+
+	var names []string
+	for _, q := range a.Queues() {
+		names = append(names, q.Channel)
+	}
+	if strings.Join(names, " ") != "default spawns tts" {
+		t.Errorf("channels are %q", names)
+	}
+
+	if err := CheckAlertChannel("../spawns"); err == nil {
+		t.Error("CheckAlertChannel has accepted an invalid name")
+	}
+}
+
+func TestAlerterHistory(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), ALERT_HISTORY_FILE)
+
+	a := NewAlerter()
+	if err := a.LoadHistory(filename); err != nil {
+		t.Fatal(err)
+	}
+	// without overlays an alert is shown as soon as the previous one ends
+	first := a.Broadcast(AlertEvent{Text: "first", From: "viewer", Command: "imp", Duration: 1}, 100)
+	a.Broadcast(AlertEvent{Text: "second", Duration: 1}, 100)
+	for deadline := time.Now().Add(5 * time.Second); len(a.History(0)) < 2 && time.Now().Before(deadline); {
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	// the history survives a restart
+	a = NewAlerter()
+	if err := a.LoadHistory(filename); err != nil {
+		t.Fatal(err)
+	}
+	history := a.History(0)
+	if len(history) != 2 || history[0].Text != "second" || history[1].ID != first || history[1].From != "viewer" || history[1].Command != "imp" || history[1].Time.IsZero() {
+		t.Fatalf("history is %+v", history)
+	}
+	if len(a.History(1)) != 1 {
+		t.Errorf("History(1) = %+v", a.History(1))
+	}
+
+	id, err := a.Replay(first, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id <= history[0].ID {
+		t.Errorf("replay ID %d is not new", id)
+	}
+	if replay := a.History(1)[0]; replay.ID != id || replay.Replay != first || replay.Text != "first" {
+		t.Errorf("replay is %+v", replay)
+	}
+
+	if _, err := a.Replay(12345, 100); err == nil {
+		t.Error("replay of a missing alert has succeeded")
+	}
+}
+
+func TestAlertStyles(t *testing.T) {
+	local, shipped := t.TempDir(), t.TempDir()
+	for filename, data := range map[string]string{
+		filepath.Join(shipped, "raid.html"): `<b>shipped</b>`,
+		filepath.Join(shipped, "raid.css"):  `b { color: red }`,
+		filepath.Join(shipped, "sub.html"):  `<i>{{ .From }}</i>`,
+		filepath.Join(local, "raid.html"):   `<b>{{ .From }} raids with {{ .Vars.viewers }} viewers</b>`,
+		filepath.Join(local, "broken.html"): `{{ .From`,
+	} {
+		if err := os.WriteFile(filename, []byte(data), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	a := NewAlerter()
+	a.Styles = &AlertStyles{Dirs: []string{local, shipped}}
+	if names := a.Styles.List(); !reflect.DeepEqual(names, []string{"broken", "raid", "sub"}) {
+		t.Errorf("List() = %q", names)
+	}
+
+	sub := a.Subscribe(DEFAULT_ALERT_CHANNEL)
+	defer a.Unsubscribe(sub)
+
+	id := a.Broadcast(AlertEvent{Text: "raid", From: "<script>", Style: "raid", Vars: map[string]interface{}{"viewers": 42}}, 100)
+	msg := receiveAlert(t, sub)
+	if msg.HTML != "<b>&lt;script&gt; raids with 42 viewers</b>" || msg.CSS != "/alerts/styles/raid.css" {
+		t.Errorf("got %+v", msg)
+	}
+	a.Done(sub, id)
+
+	id = a.Broadcast(AlertEvent{Text: "broken", Style: "broken"}, 100)
+	if msg := receiveAlert(t, sub); msg.HTML != "" || msg.Text != "broken" {
+		t.Errorf("got %+v", msg)
+	}
+	a.Done(sub, id)
+}
+
+func TestNewAlertEvent(t *testing.T) {
+	alert, err := NewAlertEvent("hi", []interface{}{"imp.png", "imp.wav", map[interface{}]interface{}{
+		"duration":  1.5,
+		"priority":  int64(3),
+		"style":     "raid",
+		"position":  "bottom",
+		"animation": "zoom",
+		"vars":      map[interface{}]interface{}{"viewers": int64(42)},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := AlertEvent{
+		Text:      "hi",
+		Image:     "imp.png",
+		Sound:     "imp.wav",
+		Duration:  1500,
+		Priority:  3,
+		Style:     "raid",
+		Position:  "bottom",
+		Animation: "zoom",
+		Vars:      map[string]interface{}{"viewers": int64(42)},
+	}
+	if !reflect.DeepEqual(alert, want) {
+		t.Errorf("NewAlertEvent() = %+v, want %+v", alert, want)
+	}
+
+	for _, args := range [][]interface{}{
+		{"a", "b", "c"},
+		{int64(1)},
+		{map[interface{}]interface{}{"duration": int64(-1)}},
+		{map[interface{}]interface{}{"colour": "red"}},
+		{map[interface{}]interface{}{"style": "../raid"}},
+		{map[interface{}]interface{}{"position": "upside-down"}},
+		{map[interface{}]interface{}{"vars": "x"}},
+	} {
+		if _, err := NewAlertEvent("hi", args); err == nil {
+			t.Errorf("NewAlertEvent(%v) has succeeded", args)
+		}
+	}
+}
+
+func forgetAsset(basename string) {
+	assetsMu.Lock()
+	defer assetsMu.Unlock()
+
+	delete(Assets, basename)
+}
+
+func TestAlerterOverlayAudio(t *testing.T) {
+	AddAsset("assets/imp.wav")
+	defer forgetAsset("imp.wav")
+
+	a := NewAlerter()
+	if err := a.SetAudio("speakers", 100); err == nil {
+		t.Errorf("SetAudio() with an unknown output has succeeded")
+	}
+	if err := a.SetAudio(ALERT_AUDIO_OVERLAY, 50); err != nil {
+		t.Fatal(err)
+	}
+
+	sub := a.Subscribe(DEFAULT_ALERT_CHANNEL)
+	defer a.Unsubscribe(sub)
+
+	a.Broadcast(AlertEvent{Text: "imp", Sound: "imp.wav"}, 80)
+	msg := receiveAlert(t, sub)
+	if msg.SoundURL != "/imp.wav" || msg.Volume != 40 {
+		t.Errorf("got %+v, want the sound at 40%%", msg)
+	}
+	a.Done(sub, msg.ID)
+
+	// only the assets are served
+	a.Broadcast(AlertEvent{Text: "baron", Sound: "/tmp/baron.wav"}, 100)
+	msg = receiveAlert(t, sub)
+	if msg.Text != "baron" || msg.SoundURL != "" {
+		t.Errorf("got %+v, want no sound URL", msg)
+	}
+	a.Done(sub, msg.ID)
+
+	// an asset written after the start is served too
+	cfg := Config{zdrctConfigDir: t.TempDir()}
+	if err := cfg.WriteAsset("caco.wav", []byte("RIFF")); err != nil {
+		t.Fatal(err)
+	}
+	defer forgetAsset("caco.wav")
+
+	a.Broadcast(AlertEvent{Text: "caco", Sound: "caco.wav"}, 100)
+	if msg := receiveAlert(t, sub); msg.SoundURL != "/caco.wav" {
+		t.Errorf("got %+v, want the uploaded sound", msg)
+	}
+}
+
+func countLines(t *testing.T, filename string) int {
+	t.Helper()
+
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return bytes.Count(data, []byte("\n"))
+}
+
+func TestAlerterHistoryCompaction(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), ALERT_HISTORY_FILE)
+
+	var buf bytes.Buffer
+	for i := 1; i <= ALERT_HISTORY_SIZE+500; i++ {
+		fmt.Fprintf(&buf, "{\"id\": %d, \"text\": \"alert %d\"}\n", i, i)
+	}
+	if err := os.WriteFile(filename, buf.Bytes(), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	a := NewAlerter()
+	if err := a.LoadHistory(filename); err != nil {
+		t.Fatal(err)
+	}
+	if n := countLines(t, filename); n != ALERT_HISTORY_SIZE {
+		t.Fatalf("the history file has %d lines after loading, want %d", n, ALERT_HISTORY_SIZE)
+	}
+	if history := a.History(1); len(history) != 1 || history[0].ID != ALERT_HISTORY_SIZE+500 {
+		t.Fatalf("the last alert is %+v", history)
+	}
+
+	a.mu.Lock()
+	for i := 0; i < ALERT_HISTORY_COMPACT_AT-ALERT_HISTORY_SIZE-1; i++ {
+		a.remember(AlertEvent{Text: "new"})
+	}
+	a.mu.Unlock()
+	if n := countLines(t, filename); n != ALERT_HISTORY_COMPACT_AT-1 {
+		t.Fatalf("the history file has %d lines, want %d", n, ALERT_HISTORY_COMPACT_AT-1)
+	}
+
+	a.mu.Lock()
+	a.remember(AlertEvent{Text: "last"})
+	a.mu.Unlock()
+	if n := countLines(t, filename); n != ALERT_HISTORY_SIZE {
+		t.Fatalf("the history file has %d lines after compaction, want %d", n, ALERT_HISTORY_SIZE)
+	}
+
+	a.mu.Lock()
+	a.remember(AlertEvent{Text: "after"})
+	a.mu.Unlock()
+
+	a = NewAlerter()
+	if err := a.LoadHistory(filename); err != nil {
+		t.Fatal(err)
+	}
+	if history := a.History(2); len(history) != 2 || history[0].Text != "after" || history[1].Text != "last" {
+		t.Errorf("the history is %+v after a restart", history)
+	}
+}
+
+// vim: ai:ts=8:sw=8:noet:syntax=go
@@ -0,0 +1,129 @@
+/**
+ * Copyright 2025 kmeaw
+ *
+ * Licensed under the GNU Affero General Public License (AGPL).
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU Affero General Public License as published by the
+ * Free Software Foundation, version 3 of the License.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or
+ * FITNESS FOR A PARTICULAR PURPOSE.  See the GNU Affero General Public License
+ * for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Alert styles are <name>.html templates with an optional <name>.css, the
+// ones from the config directory take precedence over the shipped ones.
+const ALERT_STYLES_DIR = "alert_styles"
+
+var (
+	ALERT_POSITIONS = []string{
+		"top-left", "top", "top-right",
+		"left", "center", "right",
+		"bottom-left", "bottom", "bottom-right",
+	}
+	ALERT_ANIMATIONS = []string{"none", "fade", "slide", "zoom"}
+)
+
+type AlertStyles struct {
+	Dirs []string
+}
+
+func checkAlertOption(what, value string, values []string) error {
+	for _, v := range values {
+		if v == value {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("invalid %s: %q, expected one of %s", what, value, strings.Join(values, ", "))
+}
+
+func CheckAlertStyle(name string) error {
+	if !alertChannelName.MatchString(name) {
+		return fmt.Errorf("invalid alert style name: %q", name)
+	}
+	return nil
+}
+
+// File returns the path of a file of the style, e.g. "raid.css".
+func (s *AlertStyles) File(filename string) (string, error) {
+	for _, dir := range s.Dirs {
+		name := filepath.Join(dir, filename)
+		if _, err := os.Stat(name); err == nil {
+			return name, nil
+		}
+	}
+
+	return "", fmt.Errorf("alert style file %q: %w", filename, fs.ErrNotExist)
+}
+
+// Render executes the template of the alert style, the alert is passed to it
+// as is, so the template can use {{ .Text }}, {{ .From }}, {{ .Vars.name }}
+// and so on.
+func (s *AlertStyles) Render(event AlertEvent) (string, error) {
+	if err := CheckAlertStyle(event.Style); err != nil {
+		return "", err
+	}
+
+	filename, err := s.File(event.Style + ".html")
+	if err != nil {
+		return "", err
+	}
+
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return "", err
+	}
+
+	tmpl, err := template.New(event.Style).Funcs(template.FuncMap{
+		"join": strings.Join,
+	}).Parse(string(data))
+	if err != nil {
+		return "", err
+	}
+
+	buf := &bytes.Buffer{}
+	if err := tmpl.Execute(buf, event); err != nil {
+		return "", err
+	}
+
+	return buf.String(), nil
+}
+
+// List returns the names of the available styles.
+func (s *AlertStyles) List() []string {
+	seen := make(map[string]bool)
+	var result []string
+	for _, dir := range s.Dirs {
+		files, _ := filepath.Glob(filepath.Join(dir, "*.html"))
+		for _, filename := range files {
+			name := strings.TrimSuffix(filepath.Base(filename), ".html")
+			if !seen[name] && CheckAlertStyle(name) == nil {
+				seen[name] = true
+				result = append(result, name)
+			}
+		}
+	}
+
+	sort.Strings(result)
+	return result
+}
+
+// vim: ai:ts=8:sw=8:noet:syntax=go
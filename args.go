@@ -0,0 +1,253 @@
+/**
+ * Copyright 2025 kmeaw
+ *
+ * Licensed under the GNU Affero General Public License (AGPL).
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU Affero General Public License as published by the
+ * Free Software Foundation, version 3 of the License.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or
+ * FITNESS FOR A PARTICULAR PURPOSE.  See the GNU Affero General Public License
+ * for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const MAX_RESPONSE_FILE_DEPTH = 8
+
+// ParseDoomArgs splits the arguments field of the Doom exe tab into words.
+// Words are separated by spaces and line breaks, so an option may take
+// several values (-file a.wad b.wad). Single quotes keep everything
+// literally, double quotes allow \" and \\ escapes, backslashes outside of
+// quotes are kept as is for Windows paths. A # at the beginning of a word
+// starts a comment which lasts till the end of the line.
+func ParseDoomArgs(s string) ([]string, error) {
+	var args []string
+
+	word := &strings.Builder{}
+	inWord := false
+	flush := func() {
+		if inWord {
+			args = append(args, word.String())
+			word.Reset()
+			inWord = false
+		}
+	}
+
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '#' && !inWord:
+			for i < len(s) && s[i] != '\n' {
+				i++
+			}
+
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			flush()
+
+		case c == '\'':
+			end := strings.IndexByte(s[i+1:], '\'')
+			if end == -1 {
+				return nil, fmt.Errorf("unterminated single quote at %d", i)
+			}
+			word.WriteString(s[i+1 : i+1+end])
+			inWord = true
+			i += end + 1
+
+		case c == '"':
+			start := i
+			for i++; ; i++ {
+				if i == len(s) {
+					return nil, fmt.Errorf("unterminated double quote at %d", start)
+				}
+				if s[i] == '"' {
+					break
+				}
+				if s[i] == '\\' && i+1 < len(s) && (s[i+1] == '"' || s[i+1] == '\\') {
+					i++
+				}
+				word.WriteByte(s[i])
+			}
+			inWord = true
+
+		default:
+			word.WriteByte(c)
+			inWord = true
+		}
+	}
+	flush()
+
+	return args, nil
+}
+
+// ExpandResponseFiles replaces every @file argument with the arguments read
+// from that file. Relative names are looked up in dir, the engine's working
+// directory.
+func ExpandResponseFiles(args []string, dir string) ([]string, error) {
+	return expandResponseFiles(args, dir, 0)
+}
+
+func expandResponseFiles(args []string, dir string, depth int) ([]string, error) {
+	if depth > MAX_RESPONSE_FILE_DEPTH {
+		return nil, fmt.Errorf("response files are nested too deep")
+	}
+
+	var result []string
+	for _, arg := range args {
+		if len(arg) < 2 || arg[0] != '@' {
+			result = append(result, arg)
+			continue
+		}
+
+		name := arg[1:]
+		if !filepath.IsAbs(name) {
+			name = filepath.Join(dir, name)
+		}
+
+		data, err := os.ReadFile(name)
+		if err != nil {
+			return nil, fmt.Errorf("cannot read response file: %w", err)
+		}
+
+		nested, err := ParseDoomArgs(string(data))
+		if err != nil {
+			return nil, fmt.Errorf("response file %q: %w", name, err)
+		}
+
+		nested, err = expandResponseFiles(nested, dir, depth+1)
+		if err != nil {
+			return nil, err
+		}
+
+		result = append(result, nested...)
+	}
+
+	return result, nil
+}
+
+// Before quoting was supported, the rest of an "-option value" line was passed
+// as a single argument and a line without an option was a single argument
+// too. Such values with spaces (-file C:\My Wads\x.wad) are still taken whole
+// when they name an existing file, so old settings keep working. Other values
+// with words which look like neither numbers nor files (+set sv_hostname My
+// Server) are split, but a warning suggests quoting them.
+func migrateDoomArgs(dir, s string) (string, []string) {
+	var warnings []string
+
+	lines := strings.Split(s, "\n")
+	for i, line := range lines {
+		line = strings.TrimSpace(line)
+		if line == "" || line[0] == '#' || strings.ContainsAny(line, `'"`) {
+			continue
+		}
+
+		option, value := "", line
+		if line[0] == '-' || line[0] == '+' {
+			idx := strings.IndexByte(line, ' ')
+			if idx == -1 {
+				continue
+			}
+			option, value = line[0:idx+1], strings.TrimSpace(line[idx+1:])
+		}
+
+		if !strings.ContainsAny(value, " \t") {
+			continue
+		}
+
+		if !existingFile(dir, value) {
+			words := strings.Fields(value)
+			rest := words
+			if option != "" && option[0] == '+' {
+				// the console command's own argument, e.g. a cvar name
+				rest = words[1:]
+			}
+			for _, word := range rest {
+				if !plainDoomArg(dir, word) {
+					warnings = append(warnings, fmt.Sprintf("line %d: %q is passed as %d separate arguments, put it in quotes if it is a single value", i+1, value, len(words)))
+					break
+				}
+			}
+			continue
+		}
+
+		lines[i] = option + "'" + value + "'"
+		warnings = append(warnings, fmt.Sprintf("line %d: %q is taken as a single file name, put it in quotes", i+1, value))
+	}
+
+	return strings.Join(lines, "\n"), warnings
+}
+
+func existingFile(dir, name string) bool {
+	if !filepath.IsAbs(name) {
+		name = filepath.Join(dir, name)
+	}
+	_, err := os.Stat(name)
+
+	return err == nil
+}
+
+// plainDoomArg reports whether the word is a number or a file name, which are
+// usually meant as separate arguments (-warp 1 2, -file a.wad b.wad).
+func plainDoomArg(dir, word string) bool {
+	if _, err := strconv.ParseFloat(word, 64); err == nil {
+		return true
+	}
+
+	return filepath.Ext(word) != "" || strings.ContainsAny(word, `/\`) || existingFile(dir, word)
+}
+
+// DoomArgsWarnings explains how ResolveDoomArgs has treated the arguments
+// written in the old format.
+func DoomArgsWarnings(exePath, s string) []string {
+	dir, _ := filepath.Split(exePath)
+	_, warnings := migrateDoomArgs(dir, s)
+
+	return warnings
+}
+
+type CommandPreview struct {
+	Args        []string `json:"args"`
+	CommandLine string   `json:"command_line"`
+	Warnings    []string `json:"warnings,omitempty"`
+	Error       string   `json:"error,omitempty"`
+}
+
+// PreviewCommandLine shows what inject is going to execute.
+func PreviewCommandLine(exePath string, args []string, err error) CommandPreview {
+	if err != nil {
+		return CommandPreview{Error: err.Error()}
+	}
+
+	return CommandPreview{
+		Args:        args,
+		CommandLine: commandLine(append([]string{exePath}, args...)),
+	}
+}
+
+// ResolveDoomArgs parses the arguments field and expands response files
+// the same way the engine launched from exePath would see them.
+func ResolveDoomArgs(exePath, s string) ([]string, error) {
+	dir, _ := filepath.Split(exePath)
+	s, _ = migrateDoomArgs(dir, s)
+
+	args, err := ParseDoomArgs(s)
+	if err != nil {
+		return nil, err
+	}
+
+	return ExpandResponseFiles(args, dir)
+}
+
+// vim: ai:ts=8:sw=8:noet:syntax=go
@@ -0,0 +1,144 @@
+/**
+ * Copyright 2025 kmeaw
+ *
+ * Licensed under the GNU Affero General Public License (AGPL).
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU Affero General Public License as published by the
+ * Free Software Foundation, version 3 of the License.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or
+ * FITNESS FOR A PARTICULAR PURPOSE.  See the GNU Affero General Public License
+ * for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseDoomArgs(t *testing.T) {
+	tests := []struct {
+		args string
+		want []string
+	}{
+		{"+sv_cheats 1\n-skill 5\n-warp 1 2\n", []string{"+sv_cheats", "1", "-skill", "5", "-warp", "1", "2"}},
+		{"-file a.wad b.wad", []string{"-file", "a.wad", "b.wad"}},
+		{`-file "C:\Games\My Mods\a.wad" 'it''s.wad'`, []string{"-file", `C:\Games\My Mods\a.wad`, "its.wad"}},
+		{`+echo "say \"hi\"" ""`, []string{"+echo", `say "hi"`, ""}},
+		{"# a comment\n-nomonsters # another one\n-fast", []string{"-nomonsters", "-fast"}},
+		{"+set#not_a_comment 1", []string{"+set#not_a_comment", "1"}},
+		{"", nil},
+	}
+
+	for _, test := range tests {
+		got, err := ParseDoomArgs(test.args)
+		if err != nil {
+			t.Errorf("ParseDoomArgs(%q): %s", test.args, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("ParseDoomArgs(%q) = %q, want %q", test.args, got, test.want)
+		}
+	}
+
+	for _, bad := range []string{`"unterminated`, `'unterminated`} {
+		if _, err := ParseDoomArgs(bad); err == nil {
+			t.Errorf("ParseDoomArgs(%q) has succeeded", bad)
+		}
+	}
+}
+
+func TestResolveDoomArgs(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "args.txt"), []byte("-file a.wad\n@more.txt\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "more.txt"), []byte("-skill 4"), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := ResolveDoomArgs(filepath.Join(dir, "gzdoom"), "-iwad doom2.wad @args.txt -fast")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"-iwad", "doom2.wad", "-file", "a.wad", "-skill", "4", "-fast"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, "loop.txt"), []byte("@loop.txt"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ResolveDoomArgs(filepath.Join(dir, "gzdoom"), "@loop.txt"); err == nil {
+		t.Error("recursive response files have been accepted")
+	}
+	if _, err := ResolveDoomArgs(filepath.Join(dir, "gzdoom"), "@missing.txt"); err == nil {
+		t.Error("a missing response file has been accepted")
+	}
+}
+
+func TestResolveDoomArgsOldFormat(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "My Wads"), 0777); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"My Wads/x.wad", "My Wads/y.wad"} {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+	absolute := filepath.Join(dir, "My Wads", "y.wad")
+
+	// settings written before quoting was supported
+	old := "+sv_cheats 1\r\n-file My Wads/x.wad\n-deh " + absolute + "\n-warp 1 2\nMy Wads/x.wad\n"
+
+	got, err := ResolveDoomArgs(filepath.Join(dir, "gzdoom"), old)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"+sv_cheats", "1", "-file", "My Wads/x.wad", "-deh", absolute, "-warp", "1", "2", "My Wads/x.wad"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	if warnings := DoomArgsWarnings(filepath.Join(dir, "gzdoom"), old); len(warnings) != 3 {
+		t.Errorf("warnings are %q, want 3 of them", warnings)
+	}
+
+	// values which are not existing files are split as usual
+	got, err = ResolveDoomArgs(filepath.Join(dir, "gzdoom"), "-file a.wad b.wad")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"-file", "a.wad", "b.wad"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if warnings := DoomArgsWarnings(filepath.Join(dir, "gzdoom"), "-file a.wad b.wad\n+set sv_cheats 1"); len(warnings) != 0 {
+		t.Errorf("unexpected warnings: %q", warnings)
+	}
+
+	// other multi-word values are split too, but with a warning
+	other := "+set sv_hostname My Server\n-skill 4\n+map E1M1 Knee-Deep\n-iwad heretic.wad\nMy Server"
+	got, err = ResolveDoomArgs(filepath.Join(dir, "gzdoom"), other)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = []string{"+set", "sv_hostname", "My", "Server", "-skill", "4", "+map", "E1M1", "Knee-Deep", "-iwad", "heretic.wad", "My", "Server"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	warnings := DoomArgsWarnings(filepath.Join(dir, "gzdoom"), other)
+	if len(warnings) != 3 || !strings.HasPrefix(warnings[0], "line 1:") || !strings.HasPrefix(warnings[1], "line 3:") || !strings.HasPrefix(warnings[2], "line 5:") {
+		t.Errorf("warnings are %q, want lines 1, 3 and 5", warnings)
+	}
+}
+
+// vim: ai:ts=8:sw=8:noet:syntax=go
@@ -1,47 +1,105 @@
 'use strict';
 
 window.addEventListener('DOMContentLoaded', (event) => {
-	let conn = new WebSocket('ws://' + location.host + '/alerts/ws');
 	const $connecting = document.getElementById('connecting');
 	const $msg = document.getElementById('msg');
 	const $img = document.getElementById('img');
 	const $text = document.getElementById('text');
+	const $default = document.getElementById('default');
+	const $custom = document.getElementById('custom');
+	const styles = {};
+	let conn = null;
+	let current = null;
 	let fader = null;
+	let audio = null;
 
-	conn.addEventListener('open', (event) => {
-		$connecting.style.display = 'none';
-	});
-
-	// Listen for messages
-	conn.addEventListener('message', (event) => {
-		const data = JSON.parse(event.data);
-		$msg.classList.remove('fade');
-		$text.innerText = data.text;
-		if (data.image) {
-			$img.setAttribute('src', data.image);
-			$img.style.display = 'block';
-		} else {
-			$img.style.display = 'none';
-		}
+	const hide = () => {
+		$msg.classList.add('fade');
 		if (fader) {
 			clearTimeout(fader);
+			fader = null;
+		}
+		current = null;
+	};
+
+	const stop = () => {
+		if (audio) {
+			audio.pause();
+			audio = null;
 		}
+	};
+
+	const show = (data) => {
+		hide();
+		current = data.id;
+		if (data.sound_url) {
+			stop();
+			audio = new Audio(data.sound_url);
+			audio.volume = Math.min(Math.max(data.volume / 100, 0), 1);
+			audio.play().catch((err) => console.error(err));
+		}
+		if (data.css && !styles[data.css]) {
+			const $link = document.createElement('link');
+			$link.rel = 'stylesheet';
+			$link.href = data.css;
+			document.head.appendChild($link);
+			styles[data.css] = true;
+		}
+		if (data.html) {
+			$custom.innerHTML = data.html;
+			$custom.style.display = 'block';
+			$default.style.display = 'none';
+		} else {
+			$text.innerText = data.text;
+			if (data.image) {
+				$img.setAttribute('src', data.image);
+				$img.style.display = 'block';
+			} else {
+				$img.style.display = 'none';
+			}
+			$custom.style.display = 'none';
+			$default.style.display = 'block';
+		}
+		// the animation starts over when the hidden box is shown again
+		$msg.className = 'pos-' + (data.position || 'top-left') + ' anim-' + (data.animation || 'none');
 		fader = setTimeout(() => {
-			$msg.classList.add('fade');
-			fader = null;
-		}, 5000);
-	});	
-
-	conn.addEventListener('close', (event) => {
-		setTimeout(() => {
-			conn = new WebSocket('ws://' + location.host + '/alerts/ws');
-		}, 5000);
-	});	
-
-	conn.addEventListener('error', (event) => {
-		console.error(event);
-		setTimeout(() => {
-			conn = new WebSocket('ws://' + location.host + '/alerts/ws');
-		}, 5000);
-	});	
+			const id = current;
+			hide();
+			// let zdrct know that the next alert can be shown
+			if (conn && conn.readyState === WebSocket.OPEN) {
+				conn.send(JSON.stringify({done: id}));
+			}
+		}, data.duration || 5000);
+	};
+
+	const connect = () => {
+		conn = new WebSocket('ws://' + location.host + '/alerts/ws' + location.search);
+
+		conn.addEventListener('open', (event) => {
+			$connecting.style.display = 'none';
+		});
+
+		conn.addEventListener('message', (event) => {
+			const data = JSON.parse(event.data);
+			if (data.type === 'hide') {
+				if (data.id === current) {
+					hide();
+					stop();
+				}
+				return;
+			}
+			show(data);
+		});
+
+		conn.addEventListener('close', (event) => {
+			$connecting.style.display = 'block';
+			setTimeout(connect, 5000);
+		});
+
+		conn.addEventListener('error', (event) => {
+			console.error(event);
+		});
+	};
+
+	connect();
 });
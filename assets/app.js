@@ -129,6 +129,64 @@ window.addEventListener('DOMContentLoaded', (event) => {
 	});
 
 	let iwad;
+	// sprites and sounds come from the game data of the active profile
+	let server_assets = false;
+
+	const fill_select = ($select, names) => {
+		$select.innerHTML = '';
+		const $option_none = document.createElement('option');
+		$option_none.value = '';
+		$option_none.text = '(none)';
+		$select.add($option_none);
+		names.forEach((name) => {
+			const $option = document.createElement('option');
+			$option.value = name;
+			$option.text = name;
+			$select.add($option);
+		});
+		$select.disabled = false;
+	};
+
+	Promise.all([fetch('/wad/sprites'), fetch('/wad/sounds')])
+		.then((resps) => Promise.all(resps.map((resp) => resp.json())))
+		.then(([sprites, sounds]) => {
+			if (sprites.error || sounds.error || iwad) return;
+
+			server_assets = true;
+			fill_select($patch, sprites);
+			fill_select($sound, sounds);
+			$wizard_msg.innerText = 'Using the game data of the active profile. Choose an IWAD file to use it instead.';
+		});
+
+	const server_redraw = () => {
+		if (!server_assets) return;
+
+		const value = $patch.options[$patch.selectedIndex].value;
+		const ctx = $canvas.getContext('2d');
+		if (!value) {
+			ctx.clearRect(0, 0, $canvas.width, $canvas.height);
+			$canvas.width = 0;
+			$canvas.height = 0;
+			return;
+		}
+
+		const size = $wizard_rescale.options[$wizard_rescale.selectedIndex].value;
+		const img = new Image();
+		img.addEventListener('load', () => {
+			$canvas.width = img.width;
+			$canvas.height = img.height;
+			ctx.drawImage(img, 0, 0);
+		});
+		img.src = '/wad/sprites/' + encodeURIComponent(value) + '?size=' + size;
+	};
+	$patch.addEventListener('change', server_redraw);
+	$wizard_rescale.addEventListener('change', server_redraw);
+	$sound.addEventListener('change', (event) => {
+		if (!server_assets) return;
+
+		const value = $sound.options[$sound.selectedIndex].value;
+		$audio.src = value ? '/wad/sounds/' + encodeURIComponent(value) : null;
+	});
 
 	const load_wad = (arrayBuffer) => {
 		try {
@@ -137,6 +195,7 @@ window.addEventListener('DOMContentLoaded', (event) => {
 			$wizard_msg.innerText = 'ERROR: ' + err;
 			return false;
 		}
+		server_assets = false;
 
 		$patch.disabled = true;
 		$patch.innerHTML = '';
@@ -217,7 +276,30 @@ ${camel}.ID = ${JSON.stringify($wizard_id.value)}
 ${camel}.Name = ${JSON.stringify($wizard_name.value)}
 `;
 
-		if ($canvas.width > 0 && $canvas.height > 0) {
+		if (server_assets) {
+			const body = new URLSearchParams({
+				id: id,
+				sprite: $patch.options[$patch.selectedIndex].value,
+				sound: $sound.options[$sound.selectedIndex].value,
+				size: $wizard_rescale.options[$wizard_rescale.selectedIndex].value,
+			});
+			const promise = fetch('/wad/extract', {
+				method: 'POST',
+				mode: 'same-origin',
+				cache: 'no-cache',
+				credentials: 'omit',
+				redirect: 'error',
+				body: body
+			})
+				.then((resp) => resp.json())
+				.then((json) => {
+					if (json.error) throw json.description || json.error;
+					if (json.image) code = code + `${camel}.AlertImage = '${json.image}'\n`;
+					if (json.sound) code = code + `${camel}.AlertSound = '${json.sound}'\n`;
+				});
+
+			promises.push(promise);
+		} else if ($canvas.width > 0 && $canvas.height > 0) {
 			const blobp = new Promise((resolve, reject) => {
 				$canvas.toBlob((blob) => resolve(blob), 'image/png');
 			});
@@ -244,7 +326,7 @@ ${camel}.Name = ${JSON.stringify($wizard_name.value)}
 		}
 
 		const audio_value = $sound.options[$sound.selectedIndex].value;
-		if (audio_value) {
+		if (audio_value && !server_assets) {
 			const wav = iwad.sounds[audio_value].toWav();
 			const req = fetch('/upload/assets/' + encodeURIComponent(id) + '.wav', {
 				method: 'POST',
@@ -327,6 +409,102 @@ map_reward(reward_${$bwizard_id.value}, button_${$bwizard_id.value})
 		document.getElementById('nav-script-tab').click();
 	});
 
+	const $doomexe = document.getElementById('doomexe');
+	const $doomargs = document.getElementById('doomargs');
+	const $doom_preview = document.getElementById('doom_preview');
+	let preview_timer = null;
+	const update_doom_preview = () => {
+		clearTimeout(preview_timer);
+		preview_timer = setTimeout(() => {
+			const query = new URLSearchParams({path: $doomexe.value, args: $doomargs.value});
+			fetch('/doom/preview?' + query)
+				.then((resp) => resp.json())
+				.then((preview) => {
+					$doom_preview.innerHTML = '';
+					const $el = document.createElement(preview.error ? 'span' : 'code');
+					if (preview.error) $el.className = 'text-danger';
+					$el.innerText = preview.error || preview.command_line;
+					$doom_preview.appendChild($el);
+					for (const warning of preview.warnings || []) {
+						const $warning = document.createElement('div');
+						$warning.className = 'text-warning';
+						$warning.innerText = warning;
+						$doom_preview.appendChild($warning);
+					}
+				});
+		}, 300);
+	};
+	$doomexe.addEventListener('input', update_doom_preview);
+	$doomargs.addEventListener('input', update_doom_preview);
+
+	const $wizard_actors = document.getElementById('wizard_actors');
+	const $wizard_actors_msg = document.getElementById('wizard_actors_msg');
+	fetch('/wad/actors')
+		.then((resp) => resp.json())
+		.then((actors) => {
+			if (actors.error) {
+				$wizard_actors_msg.innerText = 'Actor names are not available: ' + (actors.description || actors.error);
+				return;
+			}
+
+			actors.forEach((actor) => {
+				const $option = document.createElement('option');
+				$option.value = actor.name;
+				if (actor.replaces) $option.label = actor.name + ' (replaces ' + actor.replaces + ')';
+				$wizard_actors.appendChild($option);
+			});
+			$wizard_actors_msg.innerText = actors.length + ' actors found in the game data of the active profile.';
+		});
+
+	const $profile_maps = document.getElementById('profile_maps');
+	if ($profile_maps) $profile_maps.addEventListener('toggle', () => {
+		if (!$profile_maps.open || $profile_maps.dataset.loaded) return;
+		$profile_maps.dataset.loaded = '1';
+
+		const $msg = document.getElementById('profile_maps_msg');
+		const $tbody = $profile_maps.querySelector('tbody');
+		fetch('/wad/maps')
+			.then((resp) => resp.json())
+			.then((maps) => {
+				if (maps.error) throw maps.description || maps.error;
+
+				let episode = null;
+				maps.forEach((m) => {
+					if (m.episode && m.episode !== episode) {
+						episode = m.episode;
+						const $tr = $tbody.insertRow();
+						const $th = document.createElement('th');
+						$th.colSpan = 3;
+						$th.innerText = episode;
+						$tr.appendChild($th);
+					}
+
+					const $tr = $tbody.insertRow();
+					[m.name, m.title || '', m.format].forEach((text) => $tr.insertCell().innerText = text);
+				});
+				$msg.innerText = maps.length + ' maps, use maps() and find_map(name) in the script.';
+			})
+			.catch((err) => $msg.innerText = 'ERROR: ' + err);
+	});
+
+	const $engine_stage = document.getElementById('engine_stage');
+	const engine_steps = ['launched', 'ready', 'connected'];
+	const show_engine_stage = (stage) => {
+		const reached = engine_steps.indexOf(stage);
+		$engine_stage.querySelectorAll('span[data-step]').forEach(($step) => {
+			const idx = engine_steps.indexOf($step.dataset.step);
+			$step.className = idx <= reached ? 'text-success' : 'text-muted';
+			$step.style.fontWeight = idx == reached ? 'bold' : '';
+		});
+	};
+	show_engine_stage($engine_stage.dataset.stage);
+
+	setInterval(() => {
+		fetch('/doom/status')
+			.then((resp) => resp.json())
+			.then((status) => show_engine_stage(status.stage));
+	}, 2000);
+
 	setInterval(() => {
 		fetch('/check_csrf?csrf=' + encodeURIComponent(csrf))
 			.then((resp) => resp.json())
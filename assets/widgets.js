@@ -0,0 +1,25 @@
+'use strict';
+
+window.addEventListener('DOMContentLoaded', (event) => {
+	const $widget = document.getElementById('widget');
+	const name = $widget.dataset.widget;
+
+	const connect = () => {
+		const conn = new WebSocket('ws://' + location.host + '/widgets/' + name + '/ws' + location.search);
+
+		conn.addEventListener('message', (event) => {
+			const update = JSON.parse(event.data);
+			$widget.innerHTML = update.html;
+		});
+
+		conn.addEventListener('close', (event) => {
+			setTimeout(connect, 5000);
+		});
+
+		conn.addEventListener('error', (event) => {
+			console.error(event);
+		});
+	};
+
+	connect();
+});
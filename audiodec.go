@@ -0,0 +1,279 @@
+/**
+ * Copyright 2025 kmeaw
+ *
+ * Licensed under the GNU Affero General Public License (AGPL).
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU Affero General Public License as published by the
+ * Free Software Foundation, version 3 of the License.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or
+ * FITNESS FOR A PARTICULAR PURPOSE.  See the GNU Affero General Public License
+ * for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"path/filepath"
+	"strings"
+
+	"github.com/hajimehoshi/go-mp3"
+	"github.com/jfreymuth/oggvorbis"
+)
+
+// ErrUnsupportedAudio is returned when none of the built-in decoders can
+// handle the file, such files are decoded with ffmpeg.
+var ErrUnsupportedAudio = errors.New("unsupported audio format")
+
+// PCM is decoded audio, the samples of all channels are interleaved and lie
+// in [-1, 1].
+type PCM struct {
+	Rate     int
+	Channels int
+	Samples  []float32
+}
+
+// AudioCodec is a built-in decoder, Detect looks at the beginning of the file
+// and its name.
+type AudioCodec struct {
+	Name   string
+	Detect func(header []byte, filename string) bool
+	Decode func(data []byte) (*PCM, error)
+}
+
+var AUDIO_CODECS = []AudioCodec{
+	{
+		Name: "WAV",
+		Detect: func(header []byte, filename string) bool {
+			return len(header) >= 12 && string(header[:4]) == "RIFF" && string(header[8:12]) == "WAVE"
+		},
+		Decode: decodeWAV,
+	},
+	{
+		Name: "Ogg Vorbis",
+		Detect: func(header []byte, filename string) bool {
+			return bytes.HasPrefix(header, []byte("OggS"))
+		},
+		Decode: decodeVorbis,
+	},
+	{
+		Name: "MP3",
+		Detect: func(header []byte, filename string) bool {
+			if bytes.HasPrefix(header, []byte("ID3")) {
+				return true
+			}
+			// frame sync and a non-zero layer, so that AAC is not taken
+			// for MP3
+			if len(header) >= 2 && header[0] == 0xFF && header[1]&0xE0 == 0xE0 && header[1]&0x06 != 0 {
+				return true
+			}
+			return strings.EqualFold(filepath.Ext(filename), ".mp3")
+		},
+		Decode: decodeMP3,
+	},
+}
+
+// DecodeAudio decodes the file with the first built-in decoder which
+// recognizes it.
+func DecodeAudio(data []byte, filename string) (*PCM, error) {
+	for _, codec := range AUDIO_CODECS {
+		if codec.Detect(data, filename) {
+			pcm, err := codec.Decode(data)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", codec.Name, err)
+			}
+			if pcm.Rate <= 0 || pcm.Channels <= 0 {
+				return nil, fmt.Errorf("%s: invalid format: %d Hz, %d channel(s)", codec.Name, pcm.Rate, pcm.Channels)
+			}
+			return pcm, nil
+		}
+	}
+
+	return nil, ErrUnsupportedAudio
+}
+
+const (
+	WAVE_FORMAT_PCM        = 1
+	WAVE_FORMAT_IEEE_FLOAT = 3
+	WAVE_FORMAT_EXTENSIBLE = 0xFFFE
+)
+
+func decodeWAV(data []byte) (*PCM, error) {
+	var (
+		format, channels, bits int
+		rate                   int
+		samples                []byte
+	)
+
+	for pos := 12; pos+8 <= len(data); {
+		id := string(data[pos : pos+4])
+		size := int(binary.LittleEndian.Uint32(data[pos+4:]))
+		pos += 8
+		if size > len(data)-pos {
+			// truncated files are common, play what is there
+			size = len(data) - pos
+		}
+		chunk := data[pos : pos+size]
+
+		switch id {
+		case "fmt ":
+			if len(chunk) < 16 {
+				return nil, fmt.Errorf("fmt chunk is too short")
+			}
+			format = int(binary.LittleEndian.Uint16(chunk))
+			channels = int(binary.LittleEndian.Uint16(chunk[2:]))
+			rate = int(binary.LittleEndian.Uint32(chunk[4:]))
+			bits = int(binary.LittleEndian.Uint16(chunk[14:]))
+			if format == WAVE_FORMAT_EXTENSIBLE && len(chunk) >= 26 {
+				format = int(binary.LittleEndian.Uint16(chunk[24:]))
+			}
+		case "data":
+			samples = chunk
+		}
+
+		pos += size + size%2
+	}
+
+	if format == 0 {
+		return nil, fmt.Errorf("no fmt chunk")
+	}
+	if channels == 0 {
+		return nil, fmt.Errorf("no channels")
+	}
+
+	var sample func([]byte) float32
+	switch {
+	case format == WAVE_FORMAT_PCM && bits == 8:
+		sample = func(b []byte) float32 { return float32(int(b[0])-128) / 128 }
+	case format == WAVE_FORMAT_PCM && bits == 16:
+		sample = func(b []byte) float32 { return float32(int16(binary.LittleEndian.Uint16(b))) / 32768 }
+	case format == WAVE_FORMAT_PCM && bits == 24:
+		sample = func(b []byte) float32 {
+			return float32(int32(uint32(b[0])<<8|uint32(b[1])<<16|uint32(b[2])<<24)) / (1 << 31)
+		}
+	case format == WAVE_FORMAT_PCM && bits == 32:
+		sample = func(b []byte) float32 { return float32(int32(binary.LittleEndian.Uint32(b))) / (1 << 31) }
+	case format == WAVE_FORMAT_IEEE_FLOAT && bits == 32:
+		sample = func(b []byte) float32 { return math.Float32frombits(binary.LittleEndian.Uint32(b)) }
+	default:
+		return nil, fmt.Errorf("%w: format %d, %d bits", ErrUnsupportedAudio, format, bits)
+	}
+
+	width := bits / 8
+	frames := len(samples) / (width * channels)
+	pcm := &PCM{
+		Rate:     rate,
+		Channels: channels,
+		Samples:  make([]float32, frames*channels),
+	}
+	for i := range pcm.Samples {
+		pcm.Samples[i] = sample(samples[i*width:])
+	}
+
+	return pcm, nil
+}
+
+func decodeVorbis(data []byte) (*PCM, error) {
+	samples, format, err := oggvorbis.ReadAll(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+
+	return &PCM{
+		Rate:     format.SampleRate,
+		Channels: format.Channels,
+		Samples:  samples[:len(samples)-len(samples)%format.Channels],
+	}, nil
+}
+
+func decodeMP3(data []byte) (*PCM, error) {
+	d, err := mp3.NewDecoder(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+
+	// go-mp3 always outputs signed 16-bit little endian stereo
+	b, err := io.ReadAll(d)
+	if err != nil {
+		return nil, err
+	}
+
+	pcm := &PCM{
+		Rate:     d.SampleRate(),
+		Channels: 2,
+		Samples:  make([]float32, len(b)/4*2),
+	}
+	for i := range pcm.Samples {
+		pcm.Samples[i] = float32(int16(binary.LittleEndian.Uint16(b[2*i:]))) / 32768
+	}
+
+	return pcm, nil
+}
+
+// Convert resamples the audio with linear interpolation and converts it to
+// the given number of channels: mono is copied to every channel, and extra
+// channels are mixed into the available ones.
+func (p *PCM) Convert(rate, channels int) []int16 {
+	frames := len(p.Samples) / p.Channels
+	if frames == 0 {
+		return nil
+	}
+
+	n := int(int64(frames) * int64(rate) / int64(p.Rate))
+	result := make([]int16, n*channels)
+	mix := make([]float64, channels)
+	ratio := float64(p.Rate) / float64(rate)
+
+	for i := 0; i < n; i++ {
+		pos := float64(i) * ratio
+		frame := int(pos)
+		next := frame + 1
+		if next >= frames {
+			next = frames - 1
+		}
+		t := pos - float64(frame)
+
+		for c := range mix {
+			mix[c] = 0
+		}
+		for c := 0; c < p.Channels; c++ {
+			a := float64(p.Samples[frame*p.Channels+c])
+			b := float64(p.Samples[next*p.Channels+c])
+			v := a + (b-a)*t
+			if p.Channels == 1 {
+				for o := range mix {
+					mix[o] = v
+				}
+				break
+			}
+			mix[c%channels] += v
+		}
+
+		for c, v := range mix {
+			if p.Channels > channels {
+				v /= float64((p.Channels + channels - 1 - c) / channels)
+			}
+			v *= 32768
+			if v > math.MaxInt16 {
+				v = math.MaxInt16
+			} else if v < math.MinInt16 {
+				v = math.MinInt16
+			}
+			result[i*channels+c] = int16(v)
+		}
+	}
+
+	return result
+}
+
+// vim: ai:ts=8:sw=8:noet:syntax=go
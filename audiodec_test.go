@@ -0,0 +1,109 @@
+/**
+ * Copyright 2025 kmeaw
+ *
+ * Licensed under the GNU Affero General Public License (AGPL).
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU Affero General Public License as published by the
+ * Free Software Foundation, version 3 of the License.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or
+ * FITNESS FOR A PARTICULAR PURPOSE.  See the GNU Affero General Public License
+ * for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"reflect"
+	"testing"
+
+	"zdrct/wad"
+)
+
+func wav16(rate, channels int, samples []int16) []byte {
+	var buf bytes.Buffer
+
+	buf.WriteString("RIFF")
+	binary.Write(&buf, binary.LittleEndian, uint32(36+2*len(samples)))
+	buf.WriteString("WAVEfmt ")
+	binary.Write(&buf, binary.LittleEndian, struct {
+		Size          uint32
+		Format        uint16
+		Channels      uint16
+		Rate          uint32
+		ByteRate      uint32
+		BlockAlign    uint16
+		BitsPerSample uint16
+	}{16, WAVE_FORMAT_PCM, uint16(channels), uint32(rate), uint32(rate * channels * 2), uint16(channels * 2), 16})
+	buf.WriteString("data")
+	binary.Write(&buf, binary.LittleEndian, uint32(2*len(samples)))
+	binary.Write(&buf, binary.LittleEndian, samples)
+
+	return buf.Bytes()
+}
+
+func TestDecodeWAV(t *testing.T) {
+	// Doom sounds are 8-bit unsigned mono at 11025 Hz
+	doom := (&wad.Sound{Rate: 11025, Samples: []byte{0x80, 0xC0, 0x40}}).WAV()
+	pcm, err := DecodeAudio(doom, "dspistol.wav")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pcm.Rate != 11025 || pcm.Channels != 1 || !reflect.DeepEqual(pcm.Samples, []float32{0, 0.5, -0.5}) {
+		t.Errorf("8-bit WAV = %+v", pcm)
+	}
+
+	pcm, err = DecodeAudio(wav16(44100, 2, []int16{16384, -16384, 0, 32767}), "stereo.wav")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pcm.Rate != 44100 || pcm.Channels != 2 || len(pcm.Samples) != 4 || pcm.Samples[0] != 0.5 || pcm.Samples[1] != -0.5 {
+		t.Errorf("16-bit WAV = %+v", pcm)
+	}
+
+	// a truncated file still plays
+	truncated := wav16(44100, 2, []int16{1, 2, 3, 4})
+	if pcm, err := DecodeAudio(truncated[:len(truncated)-3], "truncated.wav"); err != nil || len(pcm.Samples) != 2 {
+		t.Errorf("truncated WAV = %+v, %v", pcm, err)
+	}
+
+	// A-law is left to ffmpeg
+	alaw := wav16(8000, 1, []int16{0})
+	binary.LittleEndian.PutUint16(alaw[20:], 6)
+	if _, err := DecodeAudio(alaw, "alaw.wav"); !errors.Is(err, ErrUnsupportedAudio) {
+		t.Errorf("A-law WAV: %v", err)
+	}
+
+	if _, err := DecodeAudio([]byte("fLaC\x00\x00\x00\x22"), "music.flac"); !errors.Is(err, ErrUnsupportedAudio) {
+		t.Errorf("FLAC: %v", err)
+	}
+	if _, err := DecodeAudio([]byte("not really an mp3"), "broken.mp3"); err == nil || errors.Is(err, ErrUnsupportedAudio) {
+		t.Errorf("broken MP3: %v", err)
+	}
+}
+
+func TestConvertPCM(t *testing.T) {
+	mono := &PCM{Rate: 24000, Channels: 1, Samples: []float32{0, 0.5, -0.5}}
+	if got, want := mono.Convert(48000, 2), []int16{0, 0, 8192, 8192, 16384, 16384, 0, 0, -16384, -16384, -16384, -16384}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Convert() = %v, want %v", got, want)
+	}
+
+	stereo := &PCM{Rate: 48000, Channels: 2, Samples: []float32{0.5, -0.5, 1, 1}}
+	if got, want := stereo.Convert(48000, 1), []int16{0, 32767}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Convert() = %v, want %v", got, want)
+	}
+
+	doom := &PCM{Rate: 11025, Channels: 1, Samples: make([]float32, 11025)}
+	if n := len(doom.Convert(48000, 2)); n != 2*48000 {
+		t.Errorf("len(Convert()) = %d, want %d", n, 2*48000)
+	}
+}
+
+// vim: ai:ts=8:sw=8:noet:syntax=go
@@ -6,15 +6,40 @@ import (
 	"fmt"
 	"html/template"
 	"io/fs"
+	"net/http"
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/gin-gonic/contrib/renders/multitemplate"
 	"github.com/gin-gonic/gin"
 )
 
-var Assets = map[string]string{}
+// Assets maps the base names of the asset files to their paths, the files
+// written after the start are added too.
+var (
+	Assets   = map[string]string{}
+	assetsMu sync.RWMutex
+)
+
+// AddAsset makes the file available by its base name.
+func AddAsset(name string) {
+	assetsMu.Lock()
+	defer assetsMu.Unlock()
+
+	Assets[filepath.Base(name)] = name
+}
+
+// LookupAsset returns the path of the asset with the given base name.
+func LookupAsset(basename string) (string, bool) {
+	assetsMu.RLock()
+	defer assetsMu.RUnlock()
+
+	name, ok := Assets[basename]
+	return name, ok
+}
 
 type Config struct {
 	BroadcasterToken string `json:"broadcaster_token,omitempty"`
@@ -25,14 +50,53 @@ type Config struct {
 	RconAddress      string `json:"rcon_address,omitempty"`
 	RconPassword     string `json:"rcon_password,omitempty"`
 
-	TtsEndpoint            string `json:"tts_endpoint,omitempty"`
-	RconAutoStart          bool   `json:"rcon_auto_start"`
-	NoMappedRewardCommands bool   `json:"no_mapped_reward_commands"`
-	SoundVolume            int    `json:"sound_volume,omitempty"`
+	RconTargets map[string]*RconTarget `json:"rcon_targets,omitempty"`
+
+	Profiles map[string]*LaunchProfile `json:"profiles,omitempty"`
+	Profile  string                    `json:"profile,omitempty"`
+
+	TtsProvider            string   `json:"tts_provider,omitempty"`
+	TtsEndpoint            string   `json:"tts_endpoint,omitempty"`
+	TtsCommand             string   `json:"tts_command,omitempty"`
+	TtsVoice               string   `json:"tts_voice,omitempty"`
+	TtsMaxLength           int      `json:"tts_max_length,omitempty"`
+	TtsFilter              []string `json:"tts_filter,omitempty"`
+	RconAutoStart          bool     `json:"rcon_auto_start"`
+	RconDeadPeerTimeout    int      `json:"rcon_dead_peer_timeout"`
+	RconDryRun             bool     `json:"rcon_dry_run"`
+	RconPolicy             string   `json:"rcon_policy"`
+	RconPolicyVerbs        []string `json:"rcon_policy_verbs"`
+	NoMappedRewardCommands bool     `json:"no_mapped_reward_commands"`
+	RecordDemos            bool     `json:"record_demos"`
+	SoundVolume            int      `json:"sound_volume,omitempty"`
+	AlertVolume            int      `json:"alert_volume"`
+	TtsVolume              int      `json:"tts_volume"`
+	EffectsVolume          int      `json:"effects_volume"`
+	MaxVoices              int      `json:"max_voices"`
+	TtsDucking             int      `json:"tts_ducking"`
+	NormalizeSounds        bool     `json:"normalize_sounds"`
+	SoundCacheSize         int      `json:"sound_cache_size"`
+	AlertAudio             string   `json:"alert_audio,omitempty"`
 
 	zdrctConfigDir string
 }
 
+type RconTarget struct {
+	Address   string `json:"address"`
+	Password  string `json:"password,omitempty"`
+	AutoStart bool   `json:"auto_start"`
+}
+
+// LaunchProfile describes how to run a particular game.
+type LaunchProfile struct {
+	Engine     string   `json:"engine"`
+	Iwad       string   `json:"iwad,omitempty"`
+	Pwads      []string `json:"pwads,omitempty"`
+	Args       string   `json:"args,omitempty"`
+	RconTarget string   `json:"rcon_target,omitempty"`
+	Script     string   `json:"script,omitempty"`
+}
+
 func (c *Config) SetDefaultScript() {
 	c.Script = `
 cmd_event_join = func() {
@@ -81,11 +145,11 @@ func redeem(price, cmd) {
 }
 
 func spawn_actor(a) {
-  if rcon("summon %s", a.ID) {
+  if summon(a).OK {
     actor_alert(a, from())
     actor_reply(a, from())
     sleep(3)
-    rcon("summon CrossbowAmmo")
+    summon("CrossbowAmmo")
     return true
   } else {
     return false
@@ -121,7 +185,7 @@ cmd_random = redeem(40, func() {
 })
 
 cmd_flask = redeem(5, func() {
-  if rcon("summon " + roll(3.0, "ArtiHealth", 1.0, "ActivatedTimeBomb")) {
+  if summon(roll(3.0, "ArtiHealth", 1.0, "ActivatedTimeBomb")).OK {
     reply("%s, thank you!", from())
     alert(sprintf("%s has spawned a flask", from()), "QuartzFlask.gif", "artiup.mp3")
     return true
@@ -197,10 +261,80 @@ func (c *Config) SetDefaults() {
 	c.DoomExe = "path/to/zdoom.exe"
 	c.DoomArgs = "+sv_cheats 1\n-skill 5\n-warp 1 2\n"
 
+	c.TtsProvider = TTS_HTTP
 	c.TtsEndpoint = ""
+	c.TtsMaxLength = TTS_MAX_LENGTH
 	c.RconAutoStart = false
+	c.RconDeadPeerTimeout = int(DEAD_PEER_TIMEOUT / time.Second)
 	c.NoMappedRewardCommands = false
-	c.SoundVolume = 100
+	c.setSoundDefaults()
+
+	c.RconPolicy = RCON_POLICY_DENY
+	c.RconPolicyVerbs = append([]string(nil), DEFAULT_RCON_DENIED_VERBS...)
+}
+
+func (c *Config) setSoundDefaults() {
+	c.SoundVolume = SOUND_VOLUME
+	c.AlertVolume = SOUND_VOLUME
+	c.TtsVolume = SOUND_VOLUME
+	c.EffectsVolume = SOUND_VOLUME
+	c.MaxVoices = SOUND_MAX_VOICES
+	c.TtsDucking = SOUND_DUCKING
+	c.NormalizeSounds = true
+	c.SoundCacheSize = SOUND_CACHE_SIZE
+}
+
+// AlertAudioOutput returns where the sounds of the alerts are played and their
+// volume on the overlays, which is the master volume times the alert volume.
+func (c *Config) AlertAudioOutput() (string, int) {
+	output := c.AlertAudio
+	if output == "" {
+		output = ALERT_AUDIO_LOCAL
+	}
+
+	return output, c.SoundVolume * c.AlertVolume / 100
+}
+
+// TTSSettings returns the settings of the text-to-speech.
+func (c *Config) TTSSettings() TTSSettings {
+	return TTSSettings{
+		Provider:  c.TtsProvider,
+		Endpoint:  c.TtsEndpoint,
+		Command:   c.TtsCommand,
+		Voice:     c.TtsVoice,
+		MaxLength: c.TtsMaxLength,
+		Filter:    c.TtsFilter,
+	}
+}
+
+// SoundSettings returns the settings of the sound mixer.
+func (c *Config) SoundSettings() SoundSettings {
+	return SoundSettings{
+		Volume: c.SoundVolume,
+		Volumes: map[string]int{
+			SOUND_ALERTS:  c.AlertVolume,
+			SOUND_TTS:     c.TtsVolume,
+			SOUND_EFFECTS: c.EffectsVolume,
+		},
+		MaxVoices: c.MaxVoices,
+		Ducking:   c.TtsDucking,
+		Normalize: c.NormalizeSounds,
+		CacheSize: c.SoundCacheSize,
+	}
+}
+
+// GetRconTarget returns the settings of the named RCON target. The default
+// target is described by the top-level RconAddress and RconPassword fields.
+func (c *Config) GetRconTarget(name string) *RconTarget {
+	if name == "" || name == DEFAULT_RCON_TARGET {
+		return &RconTarget{
+			Address:   c.RconAddress,
+			Password:  c.RconPassword,
+			AutoStart: c.RconAutoStart,
+		}
+	}
+
+	return c.RconTargets[name]
 }
 
 func (c *Config) Init() error {
@@ -241,7 +375,11 @@ func (c *Config) LoadConfig() error {
 	}
 	defer f.Close()
 
-	c.SoundVolume = 100
+	c.setSoundDefaults()
+	c.TtsMaxLength = TTS_MAX_LENGTH
+	c.RconDeadPeerTimeout = int(DEAD_PEER_TIMEOUT / time.Second)
+	c.RconPolicy = RCON_POLICY_DENY
+	c.RconPolicyVerbs = append([]string(nil), DEFAULT_RCON_DENIED_VERBS...)
 	dec := json.NewDecoder(f)
 	err = dec.Decode(c)
 	if err != nil {
@@ -251,11 +389,15 @@ func (c *Config) LoadConfig() error {
 	return nil
 }
 
+// LoadScript reads the script of the active profile. A profile which does
+// not have its script file yet inherits the current script.
 func (c *Config) LoadScript() error {
-	b, err := os.ReadFile(filepath.Join(c.zdrctConfigDir, "script.anko"))
+	b, err := os.ReadFile(filepath.Join(c.zdrctConfigDir, c.ActiveProfile().ScriptFile()))
 	if err != nil {
 		if errors.Is(err, fs.ErrNotExist) {
-			c.SetDefaultScript()
+			if c.Script == "" {
+				c.SetDefaultScript()
+			}
 			return nil
 		}
 
@@ -294,7 +436,7 @@ func (c Config) SaveConfig() error {
 }
 
 func (c Config) SaveScript() error {
-	f, err := os.OpenFile(filepath.Join(c.zdrctConfigDir, "script.anko"), os.O_CREATE|os.O_RDWR|os.O_TRUNC, 0666)
+	f, err := os.OpenFile(filepath.Join(c.zdrctConfigDir, c.ActiveProfile().ScriptFile()), os.O_CREATE|os.O_RDWR|os.O_TRUNC, 0666)
 	if err != nil {
 		return err
 	}
@@ -308,6 +450,21 @@ func (c Config) SaveScript() error {
 	return nil
 }
 
+func (c Config) AlertStyles() *AlertStyles {
+	return &AlertStyles{Dirs: []string{
+		filepath.Join(c.zdrctConfigDir, ALERT_STYLES_DIR),
+		ALERT_STYLES_DIR,
+	}}
+}
+
+func (c Config) AlertHistoryFile() string {
+	return filepath.Join(c.zdrctConfigDir, ALERT_HISTORY_FILE)
+}
+
+func (c Config) DemoDir() string {
+	return filepath.Join(c.zdrctConfigDir, DEMO_DIR)
+}
+
 func (c Config) Asset(filename string) string {
 	_, err := os.Stat(filepath.Join(c.zdrctConfigDir, "assets", filename))
 	if err == nil { // if NO error
@@ -379,7 +536,12 @@ func (c Config) WriteAsset(basename string, data []byte) error {
 	}
 
 	name := filepath.Join(c.zdrctConfigDir, "assets", basename)
-	return os.WriteFile(name, data, 0777)
+	if err := os.WriteFile(name, data, 0777); err != nil {
+		return err
+	}
+
+	AddAsset(name)
+	return nil
 }
 
 func (c Config) InitAssetsTemplates(r *gin.Engine) error {
@@ -437,11 +599,22 @@ func (c Config) InitAssetsTemplates(r *gin.Engine) error {
 	}
 	for _, name := range asset_files {
 		name := name
-		Assets[filepath.Base(name)] = name
+		AddAsset(name)
 		r.GET(filepath.Base(name), func(c *gin.Context) {
 			c.File(name)
 		})
 	}
+
+	// the assets uploaded or extracted later have no routes of their own
+	r.NoRoute(func(c *gin.Context) {
+		if c.Request.Method != http.MethodGet && c.Request.Method != http.MethodHead {
+			return
+		}
+		if name, ok := LookupAsset(strings.TrimPrefix(c.Request.URL.Path, "/")); ok {
+			c.File(name)
+		}
+	})
+
 	return nil
 }
 
@@ -0,0 +1,291 @@
+/**
+ * Copyright 2025 kmeaw
+ *
+ * Licensed under the GNU Affero General Public License (AGPL).
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU Affero General Public License as published by the
+ * Free Software Foundation, version 3 of the License.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or
+ * FITNESS FOR A PARTICULAR PURPOSE.  See the GNU Affero General Public License
+ * for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// demos are kept in <config dir>/demos/<session>/
+	DEMO_DIR = "demos"
+
+	DEMO_SESSION_FORMAT = "2006-01-02_15-04-05"
+	DEMO_NAME_FORMAT    = "15-04-05"
+)
+
+// Names of the files which can be downloaded from the demo archive.
+var (
+	demoSessionName = regexp.MustCompile(`^[0-9_-]+$`)
+	demoFileName    = regexp.MustCompile(`^[A-Za-z0-9_-]+(\.lmp|\.json|\.timeline\.jsonl)$`)
+	demoNameUnsafe  = regexp.MustCompile(`[^A-Za-z0-9_-]+`)
+)
+
+// DemoInfo describes a recorded demo, it is saved as <name>.json next to the
+// demo itself.
+type DemoInfo struct {
+	Name      string    `json:"name"`
+	Session   string    `json:"session"`
+	Profile   string    `json:"profile,omitempty"`
+	Demo      string    `json:"demo"`
+	Timeline  string    `json:"timeline"`
+	Args      []string  `json:"args"`
+	StartedAt time.Time `json:"started_at"`
+	ExitedAt  time.Time `json:"exited_at"`
+	ExitCode  int       `json:"exit_code"`
+	Events    int       `json:"events"`
+
+	// Size of the demo, 0 if the engine has not written it.
+	Size int64 `json:"size"`
+}
+
+func (d DemoInfo) Duration() time.Duration {
+	return d.ExitedAt.Sub(d.StartedAt).Round(time.Second)
+}
+
+type demoRecording struct {
+	info   DemoInfo
+	path   string
+	events *os.File
+}
+
+// DemoRecorder adds -record to the engine command line and keeps the
+// timeline of everything zdrct has sent to the game while the demo was being
+// recorded. Every run of zdrct is a separate session with its own directory.
+type DemoRecorder struct {
+	Dir     string
+	Session string
+
+	enabled    bool
+	current    *demoRecording
+	recordings map[string]*demoRecording
+
+	mu sync.Mutex
+}
+
+func NewDemoRecorder(dir string) *DemoRecorder {
+	return &DemoRecorder{
+		Dir:        dir,
+		Session:    time.Now().Format(DEMO_SESSION_FORMAT),
+		recordings: make(map[string]*demoRecording),
+	}
+}
+
+func (d *DemoRecorder) SetEnabled(enabled bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.enabled = enabled
+}
+
+// Prepare is called before the engine starts, see EngineProcess.BeforeStart.
+func (d *DemoRecorder) Prepare(profile string, args []string) []string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if !d.enabled {
+		return args
+	}
+
+	dir := filepath.Join(d.Dir, d.Session)
+	if err := os.MkdirAll(dir, 0777); err != nil {
+		log.Printf("cannot record a demo: %s", err)
+		return args
+	}
+
+	prefix := demoNameUnsafe.ReplaceAllString(profile, "_")
+	if prefix == "" {
+		prefix = "doom"
+	}
+	now := time.Now()
+	name := prefix + "_" + now.Format(DEMO_NAME_FORMAT)
+	for i := 2; ; i++ {
+		if _, err := os.Stat(filepath.Join(dir, name+".lmp")); errors.Is(err, fs.ErrNotExist) {
+			break
+		}
+		name = fmt.Sprintf("%s_%s_%d", prefix, now.Format(DEMO_NAME_FORMAT), i)
+	}
+
+	rec := &demoRecording{
+		info: DemoInfo{
+			Name:      name,
+			Session:   d.Session,
+			Profile:   profile,
+			Demo:      name + ".lmp",
+			Timeline:  name + ".timeline.jsonl",
+			StartedAt: now,
+		},
+		path: filepath.Join(dir, name+".lmp"),
+	}
+
+	var err error
+	rec.events, err = os.Create(filepath.Join(dir, rec.info.Timeline))
+	if err != nil {
+		log.Printf("cannot record a demo: %s", err)
+		return args
+	}
+
+	args = append(append([]string(nil), args...), "-record", rec.path)
+	rec.info.Args = args
+
+	// the previous engine may still be exiting while the new one starts
+	d.current = rec
+	d.recordings[rec.path] = rec
+	log.Printf("recording a demo to %s", rec.path)
+
+	return args
+}
+
+// Record appends a timeline entry to the timeline of the current demo.
+func (d *DemoRecorder) Record(entry TimelineEntry) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.current == nil {
+		return
+	}
+
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return
+	}
+
+	if _, err := d.current.events.Write(append(data, '\n')); err != nil {
+		log.Printf("cannot write the demo timeline: %s", err)
+		return
+	}
+	d.current.info.Events++
+}
+
+// recording returns the recording the engine has been started for, it is
+// forgotten and its timeline is closed. Must be called with d.mu held.
+func (d *DemoRecorder) recording(args []string) *demoRecording {
+	var rec *demoRecording
+	for _, arg := range args {
+		if r, ok := d.recordings[arg]; ok {
+			rec = r
+			break
+		}
+	}
+	if rec == nil {
+		return nil
+	}
+
+	delete(d.recordings, rec.path)
+	if d.current == rec {
+		d.current = nil
+	}
+	rec.events.Close()
+
+	return rec
+}
+
+// Abort drops the recording when the engine could not be started, see
+// EngineProcess.OnStartError.
+func (d *DemoRecorder) Abort(args []string, err error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	rec := d.recording(args)
+	if rec == nil {
+		return
+	}
+
+	log.Printf("the demo %s has not been recorded: %s", rec.path, err)
+	if err := os.Remove(rec.events.Name()); err != nil {
+		log.Printf("cannot remove the demo timeline: %s", err)
+	}
+}
+
+// Finish is called when the engine exits, see EngineProcess.OnExit.
+func (d *DemoRecorder) Finish(status EngineStatus) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	rec := d.recording(status.Args)
+	if rec == nil {
+		return
+	}
+
+	rec.info.ExitedAt = status.ExitedAt
+	rec.info.ExitCode = status.ExitCode
+	if fi, err := os.Stat(rec.path); err == nil {
+		rec.info.Size = fi.Size()
+	} else {
+		log.Printf("the engine has not written the demo %s", rec.path)
+	}
+
+	data, err := json.MarshalIndent(rec.info, "", "\t")
+	if err == nil {
+		err = os.WriteFile(strings.TrimSuffix(rec.path, ".lmp")+".json", data, 0666)
+	}
+	if err != nil {
+		log.Printf("cannot save the demo info: %s", err)
+	}
+}
+
+// List returns the finished demos of all sessions, the newest one first.
+func (d *DemoRecorder) List() ([]DemoInfo, error) {
+	files, err := filepath.Glob(filepath.Join(d.Dir, "*", "*.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	var result []DemoInfo
+	for _, filename := range files {
+		data, err := os.ReadFile(filename)
+		if err != nil {
+			return nil, err
+		}
+
+		var info DemoInfo
+		if err := json.Unmarshal(data, &info); err != nil {
+			log.Printf("%s: %s", filename, err)
+			continue
+		}
+		result = append(result, info)
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].StartedAt.After(result[j].StartedAt)
+	})
+
+	return result, nil
+}
+
+// File returns the path of a file in the archive.
+func (d *DemoRecorder) File(session, name string) (string, error) {
+	if !demoSessionName.MatchString(session) || !demoFileName.MatchString(name) {
+		return "", fmt.Errorf("invalid demo file name: %s/%s", session, name)
+	}
+
+	return filepath.Join(d.Dir, session, name), nil
+}
+
+// vim: ai:ts=8:sw=8:noet:syntax=go
@@ -0,0 +1,125 @@
+/**
+ * Copyright 2025 kmeaw
+ *
+ * Licensed under the GNU Affero General Public License (AGPL).
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU Affero General Public License as published by the
+ * Free Software Foundation, version 3 of the License.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or
+ * FITNESS FOR A PARTICULAR PURPOSE.  See the GNU Affero General Public License
+ * for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDemoRecorder(t *testing.T) {
+	d := NewDemoRecorder(t.TempDir())
+
+	if args := d.Prepare("doom2", []string{"-iwad", "doom2.wad"}); len(args) != 2 {
+		t.Errorf("disabled recorder has changed the arguments: %q", args)
+	}
+	d.Record(TimelineEntry{Kind: "alert", Text: "ignored"})
+
+	d.SetEnabled(true)
+	args := d.Prepare("doom2", []string{"-iwad", "doom2.wad"})
+	if len(args) != 4 || args[2] != "-record" || !strings.HasSuffix(args[3], ".lmp") {
+		t.Fatalf("args are %q", args)
+	}
+	if err := os.WriteFile(args[3], []byte("demo"), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	d.Record(TimelineEntry{Kind: "rcon", From: "viewer", Text: "summon DoomImp"})
+	d.Record(TimelineEntry{Kind: "alert", From: "viewer", Text: "An imp!"})
+
+	// a restart may start the next recording before the previous one is finished
+	next := d.Prepare("doom2", []string{"-iwad", "doom2.wad"})
+	if next[3] == args[3] {
+		t.Errorf("both recordings use %s", args[3])
+	}
+
+	d.Finish(EngineStatus{Args: args, ExitCode: 1, ExitedAt: time.Now()})
+	d.Record(TimelineEntry{Kind: "alert", Text: "next"})
+	d.Finish(EngineStatus{Args: next, ExitedAt: time.Now()})
+	d.Finish(EngineStatus{Args: next, ExitedAt: time.Now()})
+
+	list, err := d.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 2 {
+		t.Fatalf("List() = %+v", list)
+	}
+
+	var first DemoInfo
+	for _, info := range list {
+		if info.Demo == filepath.Base(args[3]) {
+			first = info
+		}
+	}
+	if first.Profile != "doom2" || first.ExitCode != 1 || first.Events != 2 || first.Size != 4 {
+		t.Errorf("demo info is %+v", first)
+	}
+
+	filename, err := d.File(first.Session, first.Timeline)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := strings.Count(string(data), "\n"); n != 2 || !strings.Contains(string(data), `"from":"viewer"`) {
+		t.Errorf("timeline is %s", data)
+	}
+
+	for _, name := range []string{"../config.json", "x.wad", ""} {
+		if _, err := d.File(first.Session, name); err == nil {
+			t.Errorf("File(%q) has succeeded", name)
+		}
+	}
+	if _, err := d.File("..", first.Demo); err == nil {
+		t.Error("File(..) has succeeded")
+	}
+}
+
+func TestDemoRecorderStartError(t *testing.T) {
+	dir := t.TempDir()
+	d := NewDemoRecorder(dir)
+	d.SetEnabled(true)
+
+	p := NewEngineProcess()
+	p.BeforeStart(d.Prepare)
+	p.OnStartError(d.Abort)
+
+	if err := p.Start("doom2", filepath.Join(dir, "no-such-engine"), []string{"-iwad", "doom2.wad"}); err == nil {
+		t.Fatal("Start of a missing engine has succeeded")
+	}
+
+	d.mu.Lock()
+	current, recordings := d.current, len(d.recordings)
+	d.mu.Unlock()
+	if current != nil || recordings != 0 {
+		t.Errorf("the recording is still there: current = %+v, %d recording(s)", current, recordings)
+	}
+
+	d.Record(TimelineEntry{Kind: "alert", Text: "orphan"})
+	if files, _ := filepath.Glob(filepath.Join(dir, d.Session, "*")); len(files) != 0 {
+		t.Errorf("files are left behind: %q", files)
+	}
+}
+
+// vim: ai:ts=8:sw=8:noet:syntax=go
@@ -0,0 +1,341 @@
+/**
+ * Copyright 2025 kmeaw
+ *
+ * Licensed under the GNU Affero General Public License (AGPL).
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU Affero General Public License as published by the
+ * Free Software Foundation, version 3 of the License.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or
+ * FITNESS FOR A PARTICULAR PURPOSE.  See the GNU Affero General Public License
+ * for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package main
+
+import (
+	"crypto/md5"
+	"crypto/rand"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"sync"
+)
+
+// FakeRconServer implements the server side of the Zandronum RCON protocol
+// without running any game. Console commands are recorded and answered with
+// scripted replies, so RconClient and scripts can be exercised in tests.
+type FakeRconServer struct {
+	Hostname string
+	Password string
+
+	// Handler produces console output for a command. When it is not set,
+	// the replies registered with SetReply are used.
+	Handler func(cmd string) []string
+
+	players  []string
+	mapName  string
+	replies  map[string][]string
+	commands []string
+	clients  map[string]*fakeRconClient
+	muted    bool
+
+	conn *net.UDPConn
+	mu   sync.Mutex
+}
+
+type fakeRconClient struct {
+	addr     *net.UDPAddr
+	salt     string
+	loggedIn bool
+}
+
+// NewFakeRconServer starts listening on addr; use "127.0.0.1:0" to pick
+// a free port. Call Serve to start handling packets.
+func NewFakeRconServer(addr, password string) (*FakeRconServer, error) {
+	laddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := net.ListenUDP("udp", laddr)
+	if err != nil {
+		return nil, err
+	}
+
+	return &FakeRconServer{
+		Hostname: "zdrct fake server",
+		Password: password,
+		mapName:  "MAP01",
+		replies:  make(map[string][]string),
+		clients:  make(map[string]*fakeRconClient),
+		conn:     conn,
+	}, nil
+}
+
+func (s *FakeRconServer) Addr() string {
+	return s.conn.LocalAddr().String()
+}
+
+func (s *FakeRconServer) Close() error {
+	return s.conn.Close()
+}
+
+// Serve handles incoming packets until the server is closed.
+func (s *FakeRconServer) Serve() error {
+	buf := make([]byte, 4096)
+	for {
+		n, addr, err := s.conn.ReadFromUDP(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+
+		if n == 0 {
+			continue
+		}
+
+		pkt, err := HuffmanDecode(buf[0:n])
+		if err != nil || len(pkt) == 0 {
+			log.Printf("fake server: bad packet from %s: %x", addr, buf[0:n])
+			continue
+		}
+
+		s.handle(addr, CLRC(pkt[0]), pkt[1:])
+	}
+}
+
+func (s *FakeRconServer) send(addr *net.UDPAddr, svrc SVRC, payload []byte) {
+	pkt := HuffmanEncode(append([]byte{byte(svrc)}, payload...))
+	_, err := s.conn.WriteToUDP(pkt, addr)
+	if err != nil {
+		log.Printf("fake server: cannot send to %s: %s", addr, err)
+	}
+}
+
+func (s *FakeRconServer) handle(addr *net.UDPAddr, clrc CLRC, payload []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.muted {
+		return
+	}
+
+	client := s.clients[addr.String()]
+
+	switch clrc {
+	case CLRC_BEGINCONNECTION:
+		if len(payload) < 1 || payload[0] != PROTOCOL_VERSION {
+			s.send(addr, SVRC_OLDPROTOCOL, nil)
+			return
+		}
+
+		salt := make([]byte, 16)
+		if _, err := rand.Read(salt); err != nil {
+			log.Printf("fake server: cannot generate salt: %s", err)
+			return
+		}
+
+		client = &fakeRconClient{
+			addr: addr,
+			salt: fmt.Sprintf("%x", salt),
+		}
+		s.clients[addr.String()] = client
+		s.send(addr, SVRC_SALT, append([]byte(client.salt), 0))
+
+	case CLRC_PASSWORD:
+		if client == nil {
+			return
+		}
+
+		h := md5.New()
+		h.Write([]byte(client.salt))
+		h.Write([]byte(s.Password))
+		if string(payload) != fmt.Sprintf("%x", h.Sum(nil)) {
+			delete(s.clients, addr.String())
+			s.send(addr, SVRC_INVALIDPASSWORD, nil)
+			return
+		}
+
+		client.loggedIn = true
+		loggedin := []byte{PROTOCOL_VERSION}
+		loggedin = append(loggedin, s.Hostname...)
+		loggedin = append(loggedin, 0)
+		s.send(addr, SVRC_LOGGEDIN, loggedin)
+		s.send(addr, SVRC_UPDATE, s.playerData())
+		s.send(addr, SVRC_UPDATE, s.mapData())
+
+	case CLRC_COMMAND:
+		if client == nil || !client.loggedIn {
+			return
+		}
+
+		cmd := strings.TrimRight(string(payload), "\000")
+		s.commands = append(s.commands, cmd)
+		log.Printf("fake server: %s: %q", addr, cmd)
+
+		var lines []string
+		if s.Handler != nil {
+			lines = s.Handler(cmd)
+		} else if reply, ok := s.replies[cmd]; ok {
+			lines = reply
+		} else if flds := strings.Fields(cmd); len(flds) > 0 {
+			lines = s.replies[strings.ToLower(flds[0])]
+		}
+
+		for _, line := range lines {
+			s.send(addr, SVRC_MESSAGE, append([]byte(line), 0))
+		}
+
+	case CLRC_TABCOMPLETE:
+		if client == nil || !client.loggedIn {
+			return
+		}
+
+		// the fake server knows no console commands
+		s.send(addr, SVRC_TABCOMPLETE, []byte{0})
+
+	case CLRC_PONG:
+		// nothing to do
+
+	case CLRC_DISCONNECT:
+		delete(s.clients, addr.String())
+
+	default:
+		log.Printf("fake server: unexpected clrc from %s: %d", addr, clrc)
+	}
+}
+
+func (s *FakeRconServer) playerData() []byte {
+	data := []byte{byte(SVRCU_PLAYERDATA), byte(len(s.players))}
+	for _, player := range s.players {
+		data = append(data, player...)
+		data = append(data, 0)
+	}
+
+	return data
+}
+
+func (s *FakeRconServer) mapData() []byte {
+	data := []byte{byte(SVRCU_MAP)}
+	data = append(data, s.mapName...)
+
+	return append(data, 0)
+}
+
+func (s *FakeRconServer) broadcast(svrc SVRC, payload []byte) {
+	for _, client := range s.clients {
+		if client.loggedIn {
+			s.send(client.addr, svrc, payload)
+		}
+	}
+}
+
+// SetMap changes the current map and pushes it to logged in clients.
+func (s *FakeRconServer) SetMap(name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.mapName = name
+	s.broadcast(SVRC_UPDATE, s.mapData())
+}
+
+// SetPlayers changes the player list and pushes it to logged in clients.
+func (s *FakeRconServer) SetPlayers(players ...string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.players = players
+	s.broadcast(SVRC_UPDATE, s.playerData())
+}
+
+// SetReply registers console output for a command. The command is matched
+// either exactly or by its first word in lower case.
+func (s *FakeRconServer) SetReply(cmd string, lines ...string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.replies[cmd] = lines
+}
+
+// Print sends a console message to every logged in client.
+func (s *FakeRconServer) Print(line string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.broadcast(SVRC_MESSAGE, append([]byte(line), 0))
+}
+
+// SetMuted makes the server ignore all packets, as if it has hung.
+func (s *FakeRconServer) SetMuted(muted bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.muted = muted
+}
+
+// Commands returns all commands received so far.
+func (s *FakeRconServer) Commands() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]string(nil), s.commands...)
+}
+
+type replyFlags map[string][]string
+
+func (f replyFlags) String() string {
+	return fmt.Sprintf("%v", map[string][]string(f))
+}
+
+func (f replyFlags) Set(value string) error {
+	kv := strings.SplitN(value, "=", 2)
+	if len(kv) != 2 {
+		return fmt.Errorf("expected command=reply, got %q", value)
+	}
+
+	f[kv[0]] = append(f[kv[0]], kv[1])
+	return nil
+}
+
+// fakeServerMain implements "zdrct fake-server".
+func fakeServerMain(args []string) {
+	replies := replyFlags{}
+	flags := flag.NewFlagSet("fake-server", flag.ExitOnError)
+	addr := flags.String("addr", "127.0.0.1:10666", "address to listen on")
+	password := flags.String("password", "", "RCON password")
+	mapName := flags.String("map", "MAP01", "current map name")
+	players := flags.String("players", "", "comma-separated list of players")
+	flags.Var(replies, "reply", "scripted console output as command=line (can be repeated)")
+	flags.Parse(args)
+
+	s, err := NewFakeRconServer(*addr, *password)
+	if err != nil {
+		log.Fatalf("cannot start fake server: %s", err)
+	}
+
+	s.SetMap(*mapName)
+	if *players != "" {
+		s.SetPlayers(strings.Split(*players, ",")...)
+	}
+	for cmd, lines := range replies {
+		s.SetReply(cmd, lines...)
+	}
+
+	fmt.Fprintln(os.Stdout, "rconserver is ready.")
+	if err := s.Serve(); err != nil {
+		log.Fatalf("fake server: %s", err)
+	}
+}
+
+// vim: ai:ts=8:sw=8:noet:syntax=go
@@ -0,0 +1,338 @@
+/**
+ * Copyright 2025 kmeaw
+ *
+ * Licensed under the GNU Affero General Public License (AGPL).
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU Affero General Public License as published by the
+ * Free Software Foundation, version 3 of the License.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or
+ * FITNESS FOR A PARTICULAR PURPOSE.  See the GNU Affero General Public License
+ * for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Class, cvar, script and map names which can be passed to the console
+// without quoting.
+var gameName = regexp.MustCompile(`^[A-Za-z0-9_][A-Za-z0-9_.:-]*$`)
+
+// Positional parameters of summon after the class name.
+var summonParams = map[string]int{"angle": 0, "tid": 1, "special": 2}
+
+// GameResult is returned by the typed game-control builtins.
+type GameResult struct {
+	OK      bool
+	Command string
+	Target  string
+	Error   string
+}
+
+func (r *GameResult) String() string {
+	if r.OK {
+		return fmt.Sprintf("%s: %s", r.Target, r.Command)
+	}
+
+	return fmt.Sprintf("%s: %s (%s)", r.Target, r.Command, r.Error)
+}
+
+func gameNameArg(what string, v interface{}) (string, error) {
+	var name string
+	switch v := v.(type) {
+	case string:
+		name = v
+	case *Actor:
+		if v == nil {
+			return "", fmt.Errorf("%s is nil", what)
+		}
+		name = v.ID
+	default:
+		return "", fmt.Errorf("%s must be a string, got %T", what, v)
+	}
+
+	if !gameName.MatchString(name) {
+		return "", fmt.Errorf("invalid %s: %q", what, name)
+	}
+
+	return name, nil
+}
+
+func gameIntArg(what string, v interface{}) (int64, error) {
+	switch v := v.(type) {
+	case int:
+		return int64(v), nil
+	case int64:
+		return v, nil
+	case float64:
+		if v != math.Trunc(v) || math.IsInf(v, 0) {
+			return 0, fmt.Errorf("%s must be an integer, got %v", what, v)
+		}
+		return int64(v), nil
+	case string:
+		n, err := strconv.ParseInt(strings.TrimSpace(v), 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("%s must be an integer, got %q", what, v)
+		}
+		return n, nil
+	default:
+		return 0, fmt.Errorf("%s must be an integer, got %T", what, v)
+	}
+}
+
+func gameIntArgs(what string, max int, args []interface{}) ([]string, error) {
+	if len(args) > max {
+		return nil, fmt.Errorf("too many %s: %d > %d", what, len(args), max)
+	}
+
+	result := make([]string, 0, len(args))
+	for _, arg := range args {
+		n, err := gameIntArg(what, arg)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, strconv.FormatInt(n, 10))
+	}
+
+	return result, nil
+}
+
+// SummonCommand builds summon, summonfriend and spawnitem commands. The
+// supported options are angle, tid, special and args (up to 5 integers).
+func SummonCommand(verb string, actor interface{}, opts map[interface{}]interface{}) (string, error) {
+	class, err := gameNameArg("actor", actor)
+	if err != nil {
+		return "", err
+	}
+
+	var params [3]int64
+	var specialArgs []string
+	last := -1
+	for key, value := range opts {
+		k, _ := key.(string)
+		if i, ok := summonParams[k]; ok {
+			n, err := gameIntArg(k, value)
+			if err != nil {
+				return "", err
+			}
+			params[i] = n
+			if i > last {
+				last = i
+			}
+			continue
+		}
+
+		switch k {
+		case "args":
+			list, ok := value.([]interface{})
+			if !ok {
+				return "", fmt.Errorf("args must be a list, got %T", value)
+			}
+			specialArgs, err = gameIntArgs("args", 5, list)
+			if err != nil {
+				return "", err
+			}
+		default:
+			return "", fmt.Errorf("unknown option: %v", key)
+		}
+	}
+
+	// positional parameters cannot be skipped
+	if len(specialArgs) > 0 {
+		last = 2
+	}
+
+	flds := []string{verb, class}
+	for i := 0; i <= last; i++ {
+		flds = append(flds, strconv.FormatInt(params[i], 10))
+	}
+
+	return strings.Join(append(flds, specialArgs...), " "), nil
+}
+
+func GiveCommand(verb string, item interface{}, amount []interface{}) (string, error) {
+	name, err := gameNameArg("item", item)
+	if err != nil {
+		return "", err
+	}
+
+	n, err := gameIntArgs("amount", 1, amount)
+	if err != nil {
+		return "", err
+	}
+	if len(n) > 0 && strings.HasPrefix(n[0], "-") {
+		return "", fmt.Errorf("amount must not be negative: %s", n[0])
+	}
+
+	return strings.Join(append([]string{verb, name}, n...), " "), nil
+}
+
+func SetCvarCommand(name string, value interface{}) (string, error) {
+	if !gameName.MatchString(name) {
+		return "", fmt.Errorf("invalid cvar name: %q", name)
+	}
+
+	switch v := value.(type) {
+	case bool:
+		if v {
+			value = "1"
+		} else {
+			value = "0"
+		}
+	case int, int64:
+		value = fmt.Sprint(v)
+	case float64:
+		value = strconv.FormatFloat(v, 'f', -1, 64)
+	case string:
+	default:
+		return "", fmt.Errorf("cvar value must be a string, a number or a bool, got %T", value)
+	}
+
+	return fmt.Sprintf("set %s %s", name, QuoteConsoleArg(value.(string))), nil
+}
+
+func PukeCommand(script interface{}, args []interface{}) (string, error) {
+	n, err := gameIntArg("script", script)
+	if err != nil {
+		return "", err
+	}
+
+	flds, err := gameIntArgs("script arguments", 4, args)
+	if err != nil {
+		return "", err
+	}
+
+	return strings.Join(append([]string{"puke", strconv.FormatInt(n, 10)}, flds...), " "), nil
+}
+
+func PukenameCommand(name string, args []interface{}) (string, error) {
+	if name == "" {
+		return "", fmt.Errorf("script name is empty")
+	}
+
+	flds, err := gameIntArgs("script arguments", 4, args)
+	if err != nil {
+		return "", err
+	}
+
+	return strings.Join(append([]string{"pukename", QuoteConsoleArg(name)}, flds...), " "), nil
+}
+
+func NeteventCommand(name string, args []interface{}) (string, error) {
+	if !gameName.MatchString(name) {
+		return "", fmt.Errorf("invalid event name: %q", name)
+	}
+
+	flds, err := gameIntArgs("event arguments", 3, args)
+	if err != nil {
+		return "", err
+	}
+
+	return strings.Join(append([]string{"netevent", name}, flds...), " "), nil
+}
+
+func ChangemapCommand(name string) (string, error) {
+	if !gameName.MatchString(name) {
+		return "", fmt.Errorf("invalid map name: %q", name)
+	}
+
+	return "changemap " + name, nil
+}
+
+func EchoCommand(text string) (string, error) {
+	return "echo " + QuoteConsoleArg(text), nil
+}
+
+// game returns a function which sends a command built by one of the
+// functions above to the RCON target of the active profile on behalf of the
+// viewer.
+func (b *IRCBot) game(from string) func(cmd string, err error) *GameResult {
+	return func(cmd string, err error) *GameResult {
+		return b.gameCommand(from, cmd, err)
+	}
+}
+
+func (b *IRCBot) gameCommand(from, cmd string, err error) *GameResult {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	result := &GameResult{
+		Command: cmd,
+		Target:  b.rconTarget(),
+	}
+
+	if err == nil {
+		r := b.RconPool.Get(result.Target)
+		if r == nil {
+			err = fmt.Errorf("no such target: %q", result.Target)
+		} else if !r.CanSend() {
+			err = fmt.Errorf("not connected")
+		} else {
+			err = r.CommandFrom(from, cmd)
+		}
+	}
+
+	if err != nil {
+		log.Printf("game command %q failed: %s", cmd, err)
+		result.Error = err.Error()
+		return result
+	}
+
+	result.OK = true
+	return result
+}
+
+func (b *IRCBot) defineGameCommands() []error {
+	var errors []error
+
+	for _, verb := range []string{"summon", "summonfriend", "spawnitem"} {
+		verb := verb
+		errors = append(errors, b.defineWithSender(verb, func(from string, actor interface{}, opts ...map[interface{}]interface{}) *GameResult {
+			var o map[interface{}]interface{}
+			if len(opts) > 0 {
+				o = opts[0]
+			}
+			return b.game(from)(SummonCommand(verb, actor, o))
+		})...)
+	}
+	errors = append(errors, b.defineWithSender("give", func(from string, item interface{}, amount ...interface{}) *GameResult {
+		return b.game(from)(GiveCommand("give", item, amount))
+	})...)
+	errors = append(errors, b.defineWithSender("take", func(from string, item interface{}, amount ...interface{}) *GameResult {
+		return b.game(from)(GiveCommand("take", item, amount))
+	})...)
+	errors = append(errors, b.defineWithSender("set_cvar", func(from string, name string, value interface{}) *GameResult {
+		return b.game(from)(SetCvarCommand(name, value))
+	})...)
+	errors = append(errors, b.defineWithSender("puke", func(from string, script interface{}, args ...interface{}) *GameResult {
+		return b.game(from)(PukeCommand(script, args))
+	})...)
+	errors = append(errors, b.defineWithSender("pukename", func(from string, name string, args ...interface{}) *GameResult {
+		return b.game(from)(PukenameCommand(name, args))
+	})...)
+	errors = append(errors, b.defineWithSender("netevent", func(from string, name string, args ...interface{}) *GameResult {
+		return b.game(from)(NeteventCommand(name, args))
+	})...)
+	errors = append(errors, b.defineWithSender("changemap", func(from string, name string) *GameResult {
+		return b.game(from)(ChangemapCommand(name))
+	})...)
+	errors = append(errors, b.defineWithSender("echo", func(from string, text string) *GameResult {
+		return b.game(from)(EchoCommand(text))
+	})...)
+
+	return errors
+}
+
+// vim: ai:ts=8:sw=8:noet:syntax=go
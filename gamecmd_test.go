@@ -0,0 +1,108 @@
+/**
+ * Copyright 2025 kmeaw
+ *
+ * Licensed under the GNU Affero General Public License (AGPL).
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU Affero General Public License as published by the
+ * Free Software Foundation, version 3 of the License.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or
+ * FITNESS FOR A PARTICULAR PURPOSE.  See the GNU Affero General Public License
+ * for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package main
+
+import (
+	"context"
+	"testing"
+)
+
+func TestGameCommands(t *testing.T) {
+	tests := []struct {
+		name string
+		cmd  string
+		err  error
+		want string
+	}{
+		{"summon", mustGameCmd(SummonCommand("summon", &Actor{ID: "Mummy"}, nil)), nil, "summon Mummy"},
+		{"summon with opts", mustGameCmd(SummonCommand("summonfriend", "HereticImp", map[interface{}]interface{}{"tid": int64(5)})), nil, "summonfriend HereticImp 0 5"},
+		{"summon with args", mustGameCmd(SummonCommand("spawnitem", "Crossbow", map[interface{}]interface{}{"args": []interface{}{int64(1), 2.0}})), nil, "spawnitem Crossbow 0 0 0 1 2"},
+		{"give", mustGameCmd(GiveCommand("give", "CrossbowAmmo", []interface{}{int64(20)})), nil, "give CrossbowAmmo 20"},
+		{"take", mustGameCmd(GiveCommand("take", "ArtiHealth", nil)), nil, "take ArtiHealth"},
+		{"set_cvar", mustGameCmd(SetCvarCommand("sv_cheats", true)), nil, "set sv_cheats \"1\""},
+		{"puke", mustGameCmd(PukeCommand(int64(-3), []interface{}{"1"})), nil, "puke -3 1"},
+		{"pukename", mustGameCmd(PukenameCommand("Boss \"Fight\"", nil)), nil, "pukename \"Boss \\\"Fight\\\"\""},
+		{"netevent", mustGameCmd(NeteventCommand("zdrct_spawn", []interface{}{int64(1)})), nil, "netevent zdrct_spawn 1"},
+		{"changemap", mustGameCmd(ChangemapCommand("E1M2")), nil, "changemap E1M2"},
+		{"echo", mustGameCmd(EchoCommand("hi; quit")), nil, "echo \"hi; quit\""},
+	}
+
+	for _, test := range tests {
+		if test.cmd != test.want {
+			t.Errorf("%s: got %q, want %q", test.name, test.cmd, test.want)
+		}
+	}
+}
+
+func TestGameCommandsValidation(t *testing.T) {
+	bad := []error{
+		second(SummonCommand("summon", "Mummy; quit", nil)),
+		second(SummonCommand("summon", 42, nil)),
+		second(SummonCommand("summon", "Mummy", map[interface{}]interface{}{"angel": int64(1)})),
+		second(SummonCommand("summon", "Mummy", map[interface{}]interface{}{"args": []interface{}{1, 2, 3, 4, 5, 6}})),
+		second(GiveCommand("give", "Crossbow", []interface{}{int64(-1)})),
+		second(GiveCommand("give", "Crossbow", []interface{}{1.5})),
+		second(SetCvarCommand("sv cheats", 1)),
+		second(PukeCommand("one", nil)),
+		second(NeteventCommand("ev", []interface{}{1, 2, 3, 4})),
+		second(ChangemapCommand("E1M1;quit")),
+	}
+
+	for i, err := range bad {
+		if err == nil {
+			t.Errorf("case %d has been accepted", i)
+		}
+	}
+}
+
+func TestGameBuiltinBadArguments(t *testing.T) {
+	b := NewIRCBot(nil, nil)
+	b.TTS = NewTTS(nil)
+
+	err := b.LoadScript(Config{Script: `
+func cmd_map() {
+	if !changemap("E1M1", "E1M2").OK {
+		set_voice("failed")
+	}
+}
+`})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := b.ProcessMessage(context.Background(), "viewer", "!map"); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "the failed result", func() bool {
+		return b.TTS.Voice("viewer") == "failed"
+	})
+}
+
+func mustGameCmd(cmd string, err error) string {
+	if err != nil {
+		return "error: " + err.Error()
+	}
+
+	return cmd
+}
+
+func second(_ string, err error) error {
+	return err
+}
+
+// vim: ai:ts=8:sw=8:noet:syntax=go
@@ -0,0 +1,324 @@
+/**
+ * Copyright 2025 kmeaw
+ *
+ * Licensed under the GNU Affero General Public License (AGPL).
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU Affero General Public License as published by the
+ * Free Software Foundation, version 3 of the License.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or
+ * FITNESS FOR A PARTICULAR PURPOSE.  See the GNU Affero General Public License
+ * for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/png"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+
+	"zdrct/wad"
+)
+
+// The largest square the sprites are scaled to.
+const MAX_SPRITE_SIZE = 512
+
+// Directories where distributions install the engine PK3 when it is not
+// next to the executable.
+var enginePk3Dirs = []string{
+	"/usr/share/games/doom",
+	"/usr/share/doom",
+}
+
+// Asset names produced by the extraction, without the extension.
+var assetID = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// GameData keeps the archives of the active launch profile open between
+// requests and reopens them when the profile or the files change.
+type GameData struct {
+	key    string
+	res    *wad.Resources
+	actors []wad.ActorInfo
+	maps   []wad.MapInfo
+
+	mu sync.Mutex
+}
+
+// GameFiles returns the files the engine loads for the profile in the load
+// order. Relative paths are resolved against the engine directory, which is
+// the working directory of the launched engine.
+func (p *LaunchProfile) GameFiles() ([]string, error) {
+	if p.Iwad == "" {
+		return nil, fmt.Errorf("the profile has no IWAD")
+	}
+
+	dir, exe := filepath.Split(p.Engine)
+	var files []string
+
+	// gzdoom.exe comes with gzdoom.pk3, zandronum with zandronum.pk3
+	pk3 := strings.TrimSuffix(strings.ToLower(exe), ".exe") + ".pk3"
+	for _, d := range append([]string{dir}, enginePk3Dirs...) {
+		if _, err := os.Stat(filepath.Join(d, pk3)); err == nil {
+			files = append(files, filepath.Join(d, pk3))
+			break
+		}
+	}
+
+	for _, name := range append([]string{p.Iwad}, p.Pwads...) {
+		if !filepath.IsAbs(name) {
+			name = filepath.Join(dir, name)
+		}
+		files = append(files, name)
+	}
+
+	return files, nil
+}
+
+func gameFilesKey(files []string) string {
+	var sb strings.Builder
+	for _, name := range files {
+		fi, err := os.Stat(name)
+		if err != nil {
+			fmt.Fprintf(&sb, "%s:missing\n", name)
+			continue
+		}
+		fmt.Fprintf(&sb, "%s:%d:%d\n", name, fi.Size(), fi.ModTime().UnixNano())
+	}
+
+	return sb.String()
+}
+
+// open must be called with g.mu held.
+func (g *GameData) open(profile *LaunchProfile) (*wad.Resources, error) {
+	if profile == nil {
+		return nil, fmt.Errorf("no launch profile is selected")
+	}
+
+	files, err := profile.GameFiles()
+	if err != nil {
+		return nil, err
+	}
+
+	key := gameFilesKey(files)
+	if g.res != nil && g.key == key {
+		return g.res, nil
+	}
+
+	res, err := wad.OpenResources(files...)
+	if err != nil {
+		return nil, err
+	}
+
+	if g.res != nil {
+		g.res.Close()
+	}
+	log.Printf("game data: %s", strings.Join(files, ", "))
+	g.key, g.res, g.actors, g.maps = key, res, nil, nil
+
+	return res, nil
+}
+
+// Lumps returns the lumps of all archives of the profile.
+func (g *GameData) Lumps(profile *LaunchProfile) ([]*wad.Lump, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	res, err := g.open(profile)
+	if err != nil {
+		return nil, err
+	}
+
+	return res.Lumps(), nil
+}
+
+// Actors returns the summonable actors declared in the profile archives.
+func (g *GameData) Actors(profile *LaunchProfile) ([]wad.ActorInfo, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	res, err := g.open(profile)
+	if err != nil {
+		return nil, err
+	}
+
+	if g.actors == nil {
+		g.actors, err = res.Actors()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return g.actors, nil
+}
+
+// Maps returns the maps of the profile in the episode order.
+func (g *GameData) Maps(profile *LaunchProfile) ([]wad.MapInfo, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	res, err := g.open(profile)
+	if err != nil {
+		return nil, err
+	}
+
+	if g.maps == nil {
+		g.maps, err = res.Maps()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return g.maps, nil
+}
+
+// Sprites returns the names of the sprite frames.
+func (g *GameData) Sprites(profile *LaunchProfile) ([]string, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	res, err := g.open(profile)
+	if err != nil {
+		return nil, err
+	}
+
+	return res.Sprites(), nil
+}
+
+// Sounds returns the names of the sounds which can be converted to WAV.
+func (g *GameData) Sounds(profile *LaunchProfile) ([]string, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	res, err := g.open(profile)
+	if err != nil {
+		return nil, err
+	}
+
+	return res.Sounds()
+}
+
+// SpritePNG converts a sprite frame to PNG. If size is positive, the
+// picture is scaled to cover a size x size square, like the actor wizard
+// does in the browser.
+func (g *GameData) SpritePNG(profile *LaunchProfile, name string, size int) ([]byte, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	res, err := g.open(profile)
+	if err != nil {
+		return nil, err
+	}
+
+	lump := res.Find(wad.NS_SPRITES, name)
+	if lump == nil {
+		return nil, fmt.Errorf("no such sprite: %q", name)
+	}
+
+	data, err := res.PicturePNG(lump)
+	if err != nil || size <= 0 {
+		return data, err
+	}
+
+	img, err := png.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", lump.Path, err)
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, fitSquare(img, size)); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// SoundWAV converts a sound to WAV.
+func (g *GameData) SoundWAV(profile *LaunchProfile, name string) ([]byte, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	res, err := g.open(profile)
+	if err != nil {
+		return nil, err
+	}
+
+	lump := res.Find(wad.NS_SOUNDS, name)
+	if lump == nil {
+		lump = res.Find(wad.NS_GLOBAL, name)
+	}
+	if lump == nil {
+		return nil, fmt.Errorf("no such sound: %q", name)
+	}
+
+	return res.SoundWAV(lump)
+}
+
+func (b *IRCBot) defineGameData() []error {
+	var errors []error
+
+	maps := func() []wad.MapInfo {
+		b.mu.Lock()
+		g, profile := b.GameData, b.profile
+		b.mu.Unlock()
+
+		if g == nil {
+			return nil
+		}
+
+		maps, err := g.Maps(profile)
+		if err != nil {
+			log.Printf("cannot list maps: %s", err)
+			return nil
+		}
+
+		return maps
+	}
+
+	errors = append(errors, b.e.Define("maps", maps))
+	errors = append(errors, b.e.Define("find_map", func(name string) *wad.MapInfo {
+		for _, m := range maps() {
+			if strings.EqualFold(m.Name, strings.TrimSpace(name)) {
+				return &m
+			}
+		}
+
+		return nil
+	}))
+
+	return errors
+}
+
+// fitSquare scales the image with the nearest neighbour filter so it
+// covers the square and crops the rest around the center.
+func fitSquare(img image.Image, size int) *image.NRGBA {
+	b := img.Bounds()
+	side := b.Dx()
+	if b.Dy() < side {
+		side = b.Dy()
+	}
+
+	dst := image.NewNRGBA(image.Rect(0, 0, size, size))
+	dx := (b.Dx() - side) / 2
+	dy := (b.Dy() - side) / 2
+	for y := 0; y < size; y++ {
+		for x := 0; x < size; x++ {
+			dst.Set(x, y, img.At(b.Min.X+dx+x*side/size, b.Min.Y+dy+y*side/size))
+		}
+	}
+
+	return dst
+}
+
+// vim: ai:ts=8:sw=8:noet:syntax=go
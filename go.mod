@@ -6,6 +6,8 @@ require (
 	github.com/ebitengine/oto/v3 v3.3.3
 	github.com/gin-gonic/contrib v0.0.0-20201101042839-6a891bf89f19
 	github.com/gin-gonic/gin v1.7.4
+	github.com/hajimehoshi/go-mp3 v0.3.4
+	github.com/jfreymuth/oggvorbis v1.0.5
 	github.com/kr/pretty v0.3.0 // indirect
 	github.com/mattn/anko v0.1.8
 	github.com/yookoala/realpath v1.0.0
@@ -18,7 +18,8 @@
 package main
 
 import (
-	"bytes"
+	"errors"
+	"io"
 )
 
 var freqs []int = []int{
@@ -69,10 +70,31 @@ var freqs []int = []int{
 
 const MAX_FREQ = 0xffffffff
 
-var table = make([]string, 256)
-var rmap = make(map[string]byte)
-var tree = make([]*node, 257)
-var tree_root *node
+// HUFFMAN_RAW in the first byte of a packet means that the rest of it is
+// not compressed.
+const HUFFMAN_RAW = 0xff
+
+// HUFFMAN_MAX_PACKET is the largest packet HuffmanReader can receive.
+const HUFFMAN_MAX_PACKET = 8192
+
+var (
+	ErrHuffmanEmpty     = errors.New("huffman: empty packet")
+	ErrHuffmanPadding   = errors.New("huffman: invalid padding")
+	ErrHuffmanTruncated = errors.New("huffman: truncated code")
+)
+
+type huffman_code struct {
+	// bits are emitted starting from the least significant one
+	bits   uint32
+	length uint
+}
+
+var huffman_codes [256]huffman_code
+
+// huffman_tree is the decoding tree flattened into an array with the root
+// at index 0. A non-negative child is an index of another node, a negative
+// child v is a leaf holding the byte ^v.
+var huffman_tree [][2]int
 
 type node struct {
 	freq      int
@@ -81,6 +103,7 @@ type node struct {
 }
 
 func init() {
+	tree := make([]*node, 257)
 	for i, f := range freqs {
 		tree[i] = &node{
 			freq: f,
@@ -116,94 +139,167 @@ func init() {
 		tree[min_idx2] = nil
 	}
 
-	tree_root = tree[min_idx1]
-	fill_table(tree_root, "")
+	huffman_tree = make([][2]int, 0, 255)
+	fill_tables(tree[min_idx1], 0, 0)
 }
 
-func fill_table(node *node, prefix string) {
-	if node.zero != nil {
-		fill_table(node.zero, prefix+"0")
-		fill_table(node.one, prefix+"1")
-	} else {
-		table[node.val] = prefix
-		rmap[prefix] = node.val
+func fill_tables(node *node, bits uint32, length uint) int {
+	if node.zero == nil {
+		if length > 32 {
+			panic("huffman: code is too long")
+		}
+
+		huffman_codes[node.val] = huffman_code{bits: bits, length: length}
+		return ^int(node.val)
 	}
+
+	idx := len(huffman_tree)
+	huffman_tree = append(huffman_tree, [2]int{})
+	zero := fill_tables(node.zero, bits, length+1)
+	one := fill_tables(node.one, bits|1<<length, length+1)
+	huffman_tree[idx] = [2]int{zero, one}
+
+	return idx
 }
 
+// HuffmanEncode compresses a packet. The result starts with the number of
+// padding bits in the last byte, or with HUFFMAN_RAW if compression does
+// not make the packet shorter.
 func HuffmanEncode(data []byte) []byte {
-	sb := &bytes.Buffer{}
-	bits := 0
+	out := make([]byte, 1, len(data)+1)
+	acc := uint64(0)
+	n := uint(0)
 
 	for _, b := range data {
-		sb.WriteString(table[b])
-		bits += len(table[b])
-	}
-
-	b := &bytes.Buffer{}
-	chunk_buf := make([]byte, 8)
-	for sb.Len() > 0 {
-		n, err := sb.Read(chunk_buf)
-		if err != nil {
-			panic(err)
+		code := huffman_codes[b]
+		acc |= uint64(code.bits) << n
+		n += code.length
+		for n >= 8 {
+			out = append(out, byte(acc))
+			acc >>= 8
+			n -= 8
 		}
-		chunk := chunk_buf[0:n]
-		v := byte(0)
-		for i, d := range chunk {
-			if d == byte('1') {
-				v |= 1 << i
-			}
+
+		if len(out)-1 >= len(data) {
+			break
 		}
-		b.WriteByte(v)
 	}
 
-	if len(data) <= b.Len() {
-		return append([]byte{0xff}, data...)
+	if n > 0 {
+		out = append(out, byte(acc))
+		out[0] = byte(8 - n)
+	}
+
+	if len(data) <= len(out)-1 {
+		return append([]byte{HUFFMAN_RAW}, data...)
 	}
 
-	return append([]byte{byte((8 - (bits % 8)) % 8)}, b.Bytes()...)
+	return out
 }
 
-func HuffmanDecode(data []byte) []byte {
+// HuffmanDecode decompresses a packet produced by HuffmanEncode. An
+// uncompressed payload is returned as a subslice of data.
+func HuffmanDecode(data []byte) ([]byte, error) {
+	if len(data) == 0 {
+		return nil, ErrHuffmanEmpty
+	}
+
 	pad := data[0]
 	data = data[1:]
-	if pad == 0xff {
-		return data
+	if pad == HUFFMAN_RAW {
+		return data, nil
 	}
 
-	sb := &bytes.Buffer{}
-	for _, b := range data {
-		for i := 0; i < 8; i++ {
-			if (b & (1 << i)) > 0 {
-				sb.WriteByte(byte('1'))
-			} else {
-				sb.WriteByte(byte('0'))
-			}
+	if pad > 7 || (len(data) == 0 && pad != 0) {
+		return nil, ErrHuffmanPadding
+	}
+
+	bits := len(data)*8 - int(pad)
+	out := make([]byte, 0, 2*len(data))
+	idx := 0
+	for i := 0; i < bits; i++ {
+		bit := (data[i>>3] >> uint(i&7)) & 1
+		next := huffman_tree[idx][bit]
+		if next < 0 {
+			out = append(out, byte(^next))
+			idx = 0
+		} else {
+			idx = next
 		}
 	}
 
-	sb.Truncate(sb.Len() - int(pad))
+	if idx != 0 {
+		return out, ErrHuffmanTruncated
+	}
 
-	b := &bytes.Buffer{}
-	node := tree_root
-	for _, d := range sb.Bytes() {
-		if d == byte('0') && node.zero != nil {
-			node = node.zero
-		} else if d == byte('1') && node.one != nil {
-			node = node.one
-		} else {
-			b.WriteByte(node.val)
-			if d == byte('0') {
-				node = tree_root.zero
-			} else {
-				node = tree_root.one
-			}
+	return out, nil
+}
+
+// HuffmanWriter compresses every Write call into a separate packet, so it
+// should wrap a packet-oriented writer such as *net.UDPConn.
+type HuffmanWriter struct {
+	w io.Writer
+}
+
+func NewHuffmanWriter(w io.Writer) *HuffmanWriter {
+	return &HuffmanWriter{w: w}
+}
+
+func (w *HuffmanWriter) Write(p []byte) (int, error) {
+	if _, err := w.w.Write(HuffmanEncode(p)); err != nil {
+		return 0, err
+	}
+
+	return len(p), nil
+}
+
+// HuffmanReader decompresses packets from a packet-oriented reader: every
+// Read call of the underlying reader must return exactly one packet.
+type HuffmanReader struct {
+	r       io.Reader
+	buf     []byte
+	pending []byte
+}
+
+func NewHuffmanReader(r io.Reader) *HuffmanReader {
+	return &HuffmanReader{
+		r:   r,
+		buf: make([]byte, HUFFMAN_MAX_PACKET),
+	}
+}
+
+// ReadPacket reads and decompresses the next packet.
+func (r *HuffmanReader) ReadPacket() ([]byte, error) {
+	n, err := r.r.Read(r.buf)
+	if n == 0 {
+		if err == nil {
+			err = ErrHuffmanEmpty
 		}
+		return nil, err
+	}
+
+	pkt, derr := HuffmanDecode(r.buf[0:n])
+	if derr != nil {
+		return nil, derr
 	}
-	if node != tree_root {
-		b.WriteByte(node.val)
+
+	return append([]byte(nil), pkt...), err
+}
+
+// Read implements io.Reader, the packet boundaries are not preserved.
+func (r *HuffmanReader) Read(p []byte) (int, error) {
+	for len(r.pending) == 0 {
+		pkt, err := r.ReadPacket()
+		r.pending = pkt
+		if err != nil && len(r.pending) == 0 {
+			return 0, err
+		}
 	}
 
-	return b.Bytes()
+	n := copy(p, r.pending)
+	r.pending = r.pending[n:]
+
+	return n, nil
 }
 
 // vim: ai:ts=8:sw=8:noet:syntax=go
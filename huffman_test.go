@@ -0,0 +1,226 @@
+/**
+ * Copyright 2025 kmeaw
+ *
+ * Licensed under the GNU Affero General Public License (AGPL).
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU Affero General Public License as published by the
+ * Free Software Foundation, version 3 of the License.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or
+ * FITNESS FOR A PARTICULAR PURPOSE.  See the GNU Affero General Public License
+ * for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+// Produced by the original string-based implementation, which matches
+// the tree used by Zandronum.
+var huffmanVectors = []struct {
+	plain, packed string
+}{
+	{"", "\xff"},
+	{"\x00", "\xff\x00"},
+	{"say hello", "\xffsay hello"},
+	{"\x02summon HereticImp\x00", "\xff\x02summon HereticImp\x00"},
+	{"\x00\x00\x00\x00\x00\x00\x00\x00", "\x00\x92$I"},
+	{"\x00\x00\x00   \x80\x84\x00\x00", "\x05\x92\xdc\x1dJ\x02"},
+	{"\x80\x80\x84\x84\x00 \x00 \x00 \x00", "\x00\x00Ur\xb9\\"},
+}
+
+// All byte values from 0 to 255 compressed without the raw fallback.
+const huffmanAllBytes = "" +
+	"07da3b916c9a354c1a2e26271a26e7dfa123efed3ee1e637e0c3e011933a42ef" +
+	"6499fc818885818ac0ce8663ba64696e79f7744daefefdc43db30e40bbc03dd0" +
+	"39d333ce28646ef1f8c6d78f51305fc1951e1c09c7afc42fd87945ce784777eb" +
+	"9728a54dd274719e8d91da9a33fb1b31c3880d488d6a85ad71ecda31f8a71ed9" +
+	"9c360f0dd7be3908e18e69cd996166d899f1cccca7892887857496dd830d7f50" +
+	"83da7dc57bd1451232b88954b3a7d3637ee0e77bbace99e7dfcec75eada59070" +
+	"7e7c87178d217a6043f162d6bc294799e469607b7eb79c34c9046effd7fc0b3a" +
+	"ad01783e18c8ebb4f104f3893d0b63f266355abe30311ad2c6fcb4a37b129bb1" +
+	"096c0d22c84146069eef2956a2ef8f12079805c5e170ebc1c43221698a8301"
+
+func TestHuffmanVectors(t *testing.T) {
+	for _, v := range huffmanVectors {
+		if got := HuffmanEncode([]byte(v.plain)); string(got) != v.packed {
+			t.Errorf("HuffmanEncode(%q) = %q, want %q", v.plain, got, v.packed)
+		}
+
+		got, err := HuffmanDecode([]byte(v.packed))
+		if err != nil {
+			t.Errorf("HuffmanDecode(%q): %s", v.packed, err)
+		} else if string(got) != v.plain {
+			t.Errorf("HuffmanDecode(%q) = %q, want %q", v.packed, got, v.plain)
+		}
+	}
+}
+
+func TestHuffmanAllBytes(t *testing.T) {
+	packed, err := hex.DecodeString(huffmanAllBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := HuffmanDecode(packed)
+	if err != nil {
+		t.Fatalf("HuffmanDecode: %s", err)
+	}
+
+	for i := 0; i < 256; i++ {
+		if i >= len(got) || got[i] != byte(i) {
+			t.Fatalf("decoded %x", got)
+		}
+	}
+	if len(got) != 256 {
+		t.Fatalf("decoded %d bytes, want 256", len(got))
+	}
+}
+
+func TestHuffmanRoundTrip(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	for i := 0; i < 1000; i++ {
+		data := make([]byte, rng.Intn(300))
+		for j := range data {
+			// mostly zeroes and spaces, so that compression kicks in
+			switch rng.Intn(4) {
+			case 0:
+				data[j] = byte(rng.Intn(256))
+			case 1:
+				data[j] = ' '
+			default:
+				data[j] = 0
+			}
+		}
+
+		packed := HuffmanEncode(data)
+		if len(packed) > len(data)+1 {
+			t.Fatalf("HuffmanEncode(%x) is too long: %x", data, packed)
+		}
+
+		got, err := HuffmanDecode(packed)
+		if err != nil {
+			t.Fatalf("HuffmanDecode(%x): %s", packed, err)
+		}
+		if !bytes.Equal(got, data) {
+			t.Fatalf("round trip of %x gave %x", data, got)
+		}
+	}
+}
+
+func TestHuffmanMalformed(t *testing.T) {
+	tests := []struct {
+		packed string
+		err    error
+	}{
+		{"", ErrHuffmanEmpty},
+		{"\x08\x00", ErrHuffmanPadding},
+		{"\x03", ErrHuffmanPadding},
+		// a single one bit is a prefix of a longer code
+		{"\x07\x01", ErrHuffmanTruncated},
+	}
+
+	for _, test := range tests {
+		_, err := HuffmanDecode([]byte(test.packed))
+		if !errors.Is(err, test.err) {
+			t.Errorf("HuffmanDecode(%q) = %v, want %v", test.packed, err, test.err)
+		}
+	}
+}
+
+// packetPipe returns one packet per Read call like a UDP socket does.
+type packetPipe struct {
+	packets [][]byte
+}
+
+func (p *packetPipe) Write(b []byte) (int, error) {
+	p.packets = append(p.packets, append([]byte(nil), b...))
+	return len(b), nil
+}
+
+func (p *packetPipe) Read(b []byte) (int, error) {
+	if len(p.packets) == 0 {
+		return 0, io.EOF
+	}
+
+	n := copy(b, p.packets[0])
+	p.packets = p.packets[1:]
+	return n, nil
+}
+
+func TestHuffmanReaderWriter(t *testing.T) {
+	pipe := &packetPipe{}
+	w := NewHuffmanWriter(pipe)
+
+	messages := []string{"\x00\x00\x00\x00\x00\x00\x00\x00", "say hello", "", "\x00 \x00 \x00 \x00 \x80\x84"}
+	for _, msg := range messages {
+		if _, err := w.Write([]byte(msg)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if len(pipe.packets) != len(messages) {
+		t.Fatalf("got %d packets, want %d", len(pipe.packets), len(messages))
+	}
+
+	got, err := ioutil.ReadAll(NewHuffmanReader(pipe))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := strings.Join(messages, ""); string(got) != want {
+		t.Errorf("read %q, want %q", got, want)
+	}
+}
+
+func FuzzHuffmanDecode(f *testing.F) {
+	for _, v := range huffmanVectors {
+		f.Add([]byte(v.packed))
+	}
+	f.Add([]byte("\x07\x01"))
+
+	f.Fuzz(func(t *testing.T, packed []byte) {
+		data, err := HuffmanDecode(packed)
+		if err != nil {
+			return
+		}
+
+		again, err := HuffmanDecode(HuffmanEncode(data))
+		if err != nil {
+			t.Fatalf("cannot decode re-encoded %x: %s", data, err)
+		}
+		if !bytes.Equal(again, data) {
+			t.Fatalf("round trip of %x gave %x", data, again)
+		}
+	})
+}
+
+func FuzzHuffmanRoundTrip(f *testing.F) {
+	for _, v := range huffmanVectors {
+		f.Add([]byte(v.plain))
+	}
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		got, err := HuffmanDecode(HuffmanEncode(data))
+		if err != nil {
+			t.Fatalf("HuffmanDecode: %s", err)
+		}
+		if !bytes.Equal(got, data) {
+			t.Fatalf("round trip of %x gave %x", data, got)
+		}
+	})
+}
+
+// vim: ai:ts=8:sw=8:noet:syntax=go
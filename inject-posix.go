@@ -22,18 +22,39 @@
 package main
 
 import (
+	"errors"
+	"io"
 	"os"
 	"os/exec"
 	"path/filepath"
-	"time"
+	"regexp"
+	"strings"
 
 	"github.com/yookoala/realpath"
 )
 
-func inject(exePath string, args ...string) error {
+var shellSafe = regexp.MustCompile(`^[A-Za-z0-9_@%+=:,./-]+$`)
+
+// commandLine formats argv the way a POSIX shell would accept it.
+func commandLine(argv []string) string {
+	quoted := make([]string, 0, len(argv))
+	for _, arg := range argv {
+		if shellSafe.MatchString(arg) {
+			quoted = append(quoted, arg)
+		} else {
+			quoted = append(quoted, "'"+strings.ReplaceAll(arg, "'", `'\''`)+"'")
+		}
+	}
+
+	return strings.Join(quoted, " ")
+}
+
+// inject starts the engine with libinjector preloaded. The returned function
+// waits for the process to exit; a non-zero exit code is not an error.
+func inject(exePath string, stdout, stderr io.Writer, args ...string) (*os.Process, func() (*os.ProcessState, error), error) {
 	wd, err := os.Getwd()
 	if err != nil {
-		return err
+		return nil, nil, err
 	}
 
 	real, err := realpath.Realpath(exePath)
@@ -43,20 +64,23 @@ func inject(exePath string, args ...string) error {
 
 	cmd := exec.Command(exePath, args...)
 	cmd.Dir, _ = filepath.Split(real)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
 	cmd.Env = append(os.Environ(), "LD_PRELOAD="+filepath.Join(wd, "libinjector.so"))
 
-	ch := make(chan error, 1)
-	go func() {
-		ch <- cmd.Run()
-	}()
-	select {
-	case <-time.After(2 * time.Second):
-		return nil
-	case err := <-ch:
-		return err
+	if err := cmd.Start(); err != nil {
+		return nil, nil, err
 	}
+
+	return cmd.Process, func() (*os.ProcessState, error) {
+		err := cmd.Wait()
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			err = nil
+		}
+
+		return cmd.ProcessState, err
+	}, nil
 }
 
 // vim: ai:ts=8:sw=8:noet:syntax=go
@@ -23,6 +23,7 @@ package main
 
 import (
 	"fmt"
+	"io"
 	"log"
 	"os"
 	"path/filepath"
@@ -66,17 +67,30 @@ var shellcode = []byte{
 	0xFF, 0xE0, // jmp LoadLibraryW(arg0)
 }
 
-func inject(exePath string, args ...string) error {
+// commandLine formats argv the way CreateProcess passes it to the engine.
+func commandLine(argv []string) string {
+	quoted := make([]string, 0, len(argv))
+	for _, arg := range argv {
+		quoted = append(quoted, syscall.EscapeArg(arg))
+	}
+
+	return strings.Join(quoted, " ")
+}
+
+// inject starts the engine suspended, loads libinjector into it and resumes
+// it. The engine is a GUI application, so its output is not captured and
+// EngineProcess detects the readiness with the probe.
+func inject(exePath string, stdout, stderr io.Writer, args ...string) (*os.Process, func() (*os.ProcessState, error), error) {
 	wd, err := os.Getwd()
 	if err != nil {
-		return err
+		return nil, nil, err
 	}
 
 	dir, file := filepath.Split(exePath)
 	if dir != "" {
 		err := os.Chdir(dir)
 		if err != nil {
-			return err
+			return nil, nil, err
 		}
 	}
 	defer os.Chdir(wd)
@@ -84,7 +98,7 @@ func inject(exePath string, args ...string) error {
 	kernel32 := windows.NewLazySystemDLL("kernel32.dll")
 	err = kernel32.Load()
 	if err != nil {
-		return err
+		return nil, nil, err
 	}
 
 	log.Println("kernel32.dll has been loaded")
@@ -102,7 +116,7 @@ func inject(exePath string, args ...string) error {
 	} {
 		err = proc.Find()
 		if err != nil {
-			return fmt.Errorf("cannot find %q: %w", proc.Name, err)
+			return nil, nil, fmt.Errorf("cannot find %q: %w", proc.Name, err)
 		}
 		log.Printf("%s has been found in kernel32.", proc.Name)
 	}
@@ -111,7 +125,7 @@ func inject(exePath string, args ...string) error {
 	var pi windows.ProcessInformation
 	err = windows.CreateProcess(
 		nil,
-		S(strings.Join(append([]string{file}, args...), " ")),
+		S(commandLine(append([]string{file}, args...))),
 		nil, nil, false,
 		windows.CREATE_SUSPENDED,
 		nil,
@@ -120,7 +134,7 @@ func inject(exePath string, args ...string) error {
 		&pi,
 	)
 	if err != nil {
-		return err
+		return nil, nil, err
 	}
 
 	log.Printf("New process with %d has been created.", pi.ProcessId)
@@ -153,7 +167,7 @@ func inject(exePath string, args ...string) error {
 		windows.PAGE_READWRITE,
 	)
 	if err != nil && err != ERROR_OKAY {
-		return fmt.Errorf("cannot create an empty rw page: %w", err)
+		return nil, nil, fmt.Errorf("cannot create an empty rw page: %w", err)
 	}
 
 	log.Printf("I have allocated a page %x inside the process.", page)
@@ -167,7 +181,7 @@ func inject(exePath string, args ...string) error {
 			windows.PAGE_EXECUTE_READWRITE,
 		)
 		if err != nil && err != ERROR_OKAY {
-			return fmt.Errorf("cannot create an empty rwx page: %w", err)
+			return nil, nil, fmt.Errorf("cannot create an empty rwx page: %w", err)
 		}
 
 		log.Printf("I have allocated an executable page %x inside the process.", xpage)
@@ -183,7 +197,7 @@ func inject(exePath string, args ...string) error {
 		0,
 	)
 	if err != nil && err != ERROR_OKAY {
-		return fmt.Errorf("cannot write into process data memory: %w", err)
+		return nil, nil, fmt.Errorf("cannot write into process data memory: %w", err)
 	}
 
 	if isWow64 {
@@ -195,7 +209,7 @@ func inject(exePath string, args ...string) error {
 			0,
 		)
 		if err != nil && err != ERROR_OKAY {
-			return fmt.Errorf("cannot write into process code memory: %w", err)
+			return nil, nil, fmt.Errorf("cannot write into process code memory: %w", err)
 		}
 
 		log.Println("WriteProcessMemory has succeeded.")
@@ -215,7 +229,7 @@ func inject(exePath string, args ...string) error {
 		uintptr(unsafe.Pointer(&threadId)), // [out] LPDWORD                lpThreadId
 	)
 	if err != nil && err != ERROR_OKAY {
-		return fmt.Errorf("cannot create remote thread: %w", err)
+		return nil, nil, fmt.Errorf("cannot create remote thread: %w", err)
 	}
 
 	defer windows.CloseHandle(windows.Handle(threadHandle))
@@ -227,19 +241,28 @@ func inject(exePath string, args ...string) error {
 		windows.INFINITE,
 	)
 	if err != nil {
-		return fmt.Errorf("cannot wait for DllMain to finish: %w", err)
+		return nil, nil, fmt.Errorf("cannot wait for DllMain to finish: %w", err)
 	}
 
 	log.Printf("WaitForSingleObject returns %x", r)
 
 	_, err = windows.ResumeThread(pi.Thread)
 	if err != nil {
-		return fmt.Errorf("cannot resume thread: %w", err)
+		return nil, nil, fmt.Errorf("cannot resume thread: %w", err)
 	}
 
+	proc, err := os.FindProcess(int(pi.ProcessId))
+	if err != nil {
+		return nil, nil, fmt.Errorf("cannot open process %d: %w", pi.ProcessId, err)
+	}
+
+	windows.CloseHandle(pi.Thread)
+	windows.CloseHandle(pi.Process)
 	pi.Process = 0
 
-	return nil
+	fmt.Fprintf(stdout, "zdrct: output of process %d is not captured on Windows, its RCON server is polled instead\n", pi.ProcessId)
+
+	return proc, proc.Wait, nil
 }
 
 // vim: ai:ts=8:sw=8:noet:syntax=go
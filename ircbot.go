@@ -22,13 +22,9 @@ import (
 	"context"
 	"crypto/tls"
 	"fmt"
-	"io"
 	"log"
 	"math/rand"
 	"net"
-	"net/http"
-	"net/url"
-	"os"
 	"os/exec"
 	"reflect"
 	"strconv"
@@ -62,7 +58,7 @@ type IRCBot struct {
 	UserName     string
 	AdminName    string
 	ChannelName  string
-	RconClient   *RconClient
+	RconPool     *RconPool
 	Script       string
 	LastBuckets  map[string]time.Time
 	Alerter      *Alerter
@@ -70,20 +66,21 @@ type IRCBot struct {
 	RewardMap    map[string]*Command
 	RewardSet    map[string]bool
 	Sound        *Sound
-	SoundVolume  int
+	TTS          *TTS
 	TwitchFilter bool
+	GameData     *GameData
+	Timeline     *Timeline
 
 	crediter *time.Ticker
 	online   bool
+	profile  *LaunchProfile
 
 	e *env.Env
 
 	tw_broadcaster *TwitchClient
 	tw_bot         *TwitchClient
-	TtsEndpoint    string
 
-	client  *irc.Client
-	hclient *http.Client
+	client *irc.Client
 
 	conn net.Conn
 	mu   *sync.Mutex
@@ -99,8 +96,6 @@ func NewIRCBot(tw_broadcaster, tw_bot *TwitchClient) *IRCBot {
 		tw_broadcaster: tw_broadcaster,
 		tw_bot:         tw_bot,
 
-		hclient: &http.Client{Timeout: 5 * time.Second},
-
 		mu: new(sync.Mutex),
 	}
 }
@@ -147,7 +142,7 @@ func (b *IRCBot) ProcessMessage(ctx context.Context, from, msg string) error {
 		return fmt.Errorf("script is not loaded, ignoring %q: %q", from, msg)
 	}
 
-	if _, ok := b.Balances[from]; !ok {
+	if _, ok := b.Balances[from]; !ok && from != "" {
 		b.Balances[from] = 5
 	}
 
@@ -182,6 +177,7 @@ func (b *IRCBot) ProcessMessage(ctx context.Context, from, msg string) error {
 		script := fmt.Sprintf("cmd_%s(%s)", cmd, strings.Join(args, ", "))
 		e := b.e.DeepCopy()
 		ctx := context.WithValue(ctx, "from_user", from)
+		ctx = context.WithValue(ctx, "command", cmd)
 
 		go func(ctx context.Context, e *env.Env) {
 			b.e.Set("eval", func(code string) interface{} {
@@ -264,6 +260,16 @@ func (b *IRCBot) IsOnline() bool {
 	return b.online
 }
 
+// rconTarget returns the RCON target of the active launch profile, must be
+// called with b.mu held.
+func (b *IRCBot) rconTarget() string {
+	if b.profile == nil || b.profile.RconTarget == "" {
+		return DEFAULT_RCON_TARGET
+	}
+
+	return b.profile.RconTarget
+}
+
 func (b *IRCBot) LoadScript(config Config) error {
 	b.mu.Lock()
 	defer b.mu.Unlock()
@@ -275,8 +281,8 @@ func (b *IRCBot) LoadScript(config Config) error {
 	b.Buttons = nil
 	b.RewardMap = map[string]*Command{}
 	b.RewardSet = map[string]bool{}
-	b.SoundVolume = config.SoundVolume
 	b.TwitchFilter = config.NoMappedRewardCommands
+	b.profile = config.ActiveProfile()
 
 	b.e = env.NewEnv()
 	_, err := vm.Execute(b.e, nil, `
@@ -285,6 +291,9 @@ func from() {
 }
 func is_reward() {
 	return false
+}
+func command() {
+	return ""
 }
 	`)
 	if err != nil {
@@ -307,6 +316,13 @@ func is_reward() {
 		_, err := orig_from.(func(context.Context) (reflect.Value, reflect.Value))(ctx)
 		return reflect.ValueOf(from), err
 	}))
+	orig_command, err := b.e.Get("command")
+	errors = append(errors, err)
+	errors = append(errors, b.e.Set("command", func(ctx context.Context) (reflect.Value, reflect.Value) {
+		command, _ := ctx.Value("command").(string)
+		_, err := orig_command.(func(context.Context) (reflect.Value, reflect.Value))(ctx)
+		return reflect.ValueOf(command), err
+	}))
 	errors = append(errors, b.e.Define("add_command", func(commands ...*Command) {
 		if !loading {
 			log.Println("dynamic add_command is not allowed")
@@ -375,7 +391,7 @@ func is_reward() {
 
 		b.Balances[name] = value
 	}))
-	errors = append(errors, b.e.Define("actor_alert", func(actor *Actor, from string) {
+	errors = append(errors, b.defineWith("actor_alert", []string{"command()"}, func(command string, actor *Actor, from string) {
 		tmpl, err := template.New("actor_alert").Parse(actor.AlertText)
 		if err != nil {
 			log.Printf("template error: %s", err)
@@ -391,90 +407,40 @@ func is_reward() {
 		defer b.mu.Unlock()
 
 		alert := AlertEvent{
-			Text:  buf.String(),
-			Image: actor.AlertImage,
-			Sound: actor.AlertSound,
+			Text:    buf.String(),
+			Image:   actor.AlertImage,
+			Sound:   actor.AlertSound,
+			From:    from,
+			Command: command,
 		}
 
 		log.Printf("alert(%q)", alert.Text)
-		b.Alerter.Broadcast(alert, b.SoundVolume)
-	}))
-	errors = append(errors, b.e.Define("tts", func(msg string) bool {
-		b.mu.Lock()
-		defer b.mu.Unlock()
-
-		if b.TtsEndpoint == "" {
-			log.Println("TTS endpoint is not set.")
-			return false
-		}
-
-		u, err := url.Parse(b.TtsEndpoint)
-		if err != nil {
-			log.Printf("Invalid TTS endpoint: %s", err)
-			return false
-		}
-
-		ui := u.User
-		qs := u.Query()
-
-		u.User = nil
-		u.RawQuery = ""
-
-		for k, vv := range qs {
-			if len(vv) == 1 && vv[0] == "TEXT" {
-				qs[k] = []string{msg}
-			}
-		}
-
-		req, err := http.NewRequest("POST", u.String(), strings.NewReader(qs.Encode()))
-		if err != nil {
-			log.Printf("TTS cannot construct a request: %s", err)
-			return false
-		}
-
-		if ui != nil {
-			pass, ok := ui.Password()
-			if ok {
-				req.Header.Set("Authorization", ui.Username()+" "+pass)
-			}
-		}
-
-		req.Header.Set("User-Agent", "https://github.com/kmeaw/zdrct")
-
-		resp, err := b.hclient.Do(req)
-		if err != nil {
-			log.Printf("TTS request has failed: %s", err)
-			return false
-		}
-
-		defer resp.Body.Close()
-
-		if resp.StatusCode != http.StatusOK {
-			body, _ := io.ReadAll(resp.Body)
-			log.Printf("TTS request has failed: unexpected status: %d (%q)", err, body)
+		b.Alerter.Broadcast(alert, SOUND_VOLUME)
+		b.logAlert(from, alert)
+	})...)
+	errors = append(errors, b.defineWithSender("tts", func(from string, msg string, voice ...string) bool {
+		if b.TTS == nil {
+			log.Println("TTS is not available.")
 			return false
 		}
 
-		f, err := os.CreateTemp("", "zdrct-tts.*.mp3")
-		if err != nil {
-			log.Printf("Cannot create a temporary file: %s", err)
+		if len(voice) > 1 {
+			log.Printf("tts: too many arguments")
 			return false
 		}
-
-		_, err = io.Copy(f, resp.Body)
-		if err != nil {
-			log.Printf("Cannot write into the temporary file %q: %s", f.Name(), err)
+		if err := b.TTS.Say(from, msg, strings.Join(voice, "")); err != nil {
+			log.Printf("TTS: %s", err)
 			return false
 		}
 
-		go func() {
-			b.Sound.Play(f.Name(), b.SoundVolume)
-			time.Sleep(time.Second) // FIXME: ugly hack for windows
-			os.Remove(f.Name())
-		}()
-
 		return true
-	}))
+	})...)
+	// viewers can only change their own voice
+	errors = append(errors, b.defineWithSender("set_voice", func(from string, voice string) {
+		if b.TTS != nil {
+			b.TTS.SetVoice(from, voice)
+		}
+	})...)
 	errors = append(errors, b.e.Define("actor_reply", func(actor *Actor, from string) {
 		b.mu.Lock()
 		defer b.mu.Unlock()
@@ -562,22 +528,48 @@ func is_reward() {
 		return n
 	}))
 	errors = append(errors, b.e.Define("join", strings.Join))
-	errors = append(errors, b.e.Define("rcon", func(format string, args ...interface{}) bool {
+	errors = append(errors, b.defineWithSender("rcon", func(from string, format string, args ...interface{}) bool {
 		b.mu.Lock()
 		defer b.mu.Unlock()
 
-		if !b.RconClient.IsOnline() {
+		target := b.rconTarget()
+		r := b.RconPool.Get(target)
+		if r == nil {
+			log.Printf("RCON error: no such target: %q", target)
 			return false
 		}
 
-		err := b.RconClient.Command(fmt.Sprintf(format, args...))
-		if err != nil {
-			log.Printf("RCON error: %s", err)
+		return b.rcon(r, from, fmt.Sprintf(format, args...))
+	})...)
+	errors = append(errors, b.defineWithSender("rcon_to", func(from string, target string, format string, args ...interface{}) bool {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+
+		r := b.RconPool.Get(target)
+		if r == nil {
+			log.Printf("RCON error: no such target: %q", target)
 			return false
 		}
 
-		return true
-	}))
+		return b.rcon(r, from, fmt.Sprintf(format, args...))
+	})...)
+	errors = append(errors, b.defineWithSender("rcon_all", func(from string, format string, args ...interface{}) bool {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+
+		cmd := fmt.Sprintf(format, args...)
+		delivered := false
+		for _, r := range b.RconPool.Clients() {
+			if b.rcon(r, from, cmd) {
+				delivered = true
+			}
+		}
+
+		return delivered
+	})...)
+	errors = append(errors, b.e.Define("quote", QuoteConsoleArg))
+	errors = append(errors, b.defineGameCommands()...)
+	errors = append(errors, b.defineGameData()...)
 	errors = append(errors, b.e.Define("debug", func(format string, args ...interface{}) {
 		log.Printf("[DEBUG] "+format, args...)
 	}))
@@ -634,21 +626,52 @@ func is_reward() {
 		return vs[len(vs)-1] // should not happen
 	}))
 	errors = append(errors, b.e.Define("sprintf", fmt.Sprintf))
-	errors = append(errors, b.e.Define("alert", func(text string, args ...string) {
-		alert := AlertEvent{Text: text}
-		switch len(args) {
-		case 2:
-			alert.Sound = args[1]
-			fallthrough
-		case 1:
-			alert.Image = args[0]
+	errors = append(errors, b.defineWith("alert", []string{"from()", "command()"}, func(from, command string, text string, args ...interface{}) error {
+		alert, err := NewAlertEvent(text, args)
+		if err != nil {
+			return err
 		}
+		alert.From = from
+		alert.Command = command
 		log.Printf("alert(%q)", text)
 
 		b.mu.Lock()
 		defer b.mu.Unlock()
 
-		b.Alerter.Broadcast(alert, b.SoundVolume)
+		b.Alerter.Broadcast(alert, SOUND_VOLUME)
+		b.logAlert(from, alert)
+		return nil
+	})...)
+	errors = append(errors, b.defineWith("alert_to", []string{"from()", "command()"}, func(from, command string, channel string, text string, args ...interface{}) error {
+		if err := CheckAlertChannel(channel); err != nil {
+			return err
+		}
+		alert, err := NewAlertEvent(text, args)
+		if err != nil {
+			return err
+		}
+		alert.Channel = channel
+		alert.From = from
+		alert.Command = command
+		log.Printf("alert_to(%q, %q)", channel, text)
+
+		b.mu.Lock()
+		defer b.mu.Unlock()
+
+		b.Alerter.Broadcast(alert, SOUND_VOLUME)
+		b.logAlert(from, alert)
+		return nil
+	})...)
+	errors = append(errors, b.e.Define("skip_alert", func(channel ...string) bool {
+		name := DEFAULT_ALERT_CHANNEL
+		if len(channel) > 0 {
+			name = channel[0]
+		}
+		if err := CheckAlertChannel(name); err != nil {
+			log.Printf("skip_alert: %s", err)
+			return false
+		}
+		return b.Alerter.SkipCurrent(name)
 	}))
 	errors = append(errors, b.e.Define("list_cmds", func() (result []string) {
 		for _, line := range strings.Split(b.e.String(), "\n") {
@@ -688,7 +711,15 @@ func is_reward() {
 		}(cmd)
 	}))
 	errors = append(errors, b.e.Define("play", func(name string) {
-		b.Sound.Play(name, b.SoundVolume)
+		b.Sound.Play(SOUND_EFFECTS, name, SOUND_VOLUME)
+	}))
+	gains := map[string]float64{}
+	errors = append(errors, b.e.Define("sound_gain", func(name string, gain float64) {
+		if loading {
+			gains[name] = gain
+		} else if b.Sound != nil {
+			b.Sound.SetGain(name, gain)
+		}
 	}))
 
 	_, err = vm.Execute(b.e, nil, config.Script)
@@ -699,10 +730,173 @@ func is_reward() {
 	loading = false
 
 	b.Script = config.Script
-	b.TtsEndpoint = config.TtsEndpoint
+
+	if b.Sound != nil {
+		b.Sound.SetGains(gains)
+		go b.Sound.Preload(ScriptSounds(config.Script))
+	}
 	return nil
 }
 
+func (b *IRCBot) rcon(r *RconClient, from, cmd string) bool {
+	if !r.CanSend() {
+		return false
+	}
+
+	err := r.CommandFrom(from, cmd)
+	if err != nil {
+		log.Printf("RCON error (%s): %s", r.Name, err)
+		return false
+	}
+
+	return true
+}
+
+// logAlert adds the alert to the timeline, must be called with b.mu held.
+func (b *IRCBot) logAlert(from string, alert AlertEvent) {
+	if b.Timeline == nil {
+		return
+	}
+
+	b.Timeline.Add(TimelineEntry{
+		Kind:   "alert",
+		Target: alert.Channel,
+		From:   from,
+		Text:   alert.Text,
+	})
+}
+
+// defineWithSender defines a builtin which gets the name of the viewer the
+// script is running for as its first argument. Only script functions can see
+// the context of the call, so the builtin is hidden behind a script function
+// which passes from() to it.
+func (b *IRCBot) defineWithSender(name string, fn interface{}) []error {
+	return b.defineWith(name, []string{"from()"}, fn)
+}
+
+// defineWith is like defineWithSender, but the builtin gets the values of the
+// given script expressions (e.g. "from()", "command()") as its first
+// arguments.
+func (b *IRCBot) defineWith(name string, exprs []string, fn interface{}) []error {
+	fv := reflect.ValueOf(fn)
+	ft := fv.Type()
+
+	hidden := func(from interface{}, args ...interface{}) interface{} {
+		in, err := senderArgs(ft, from, args)
+		if err != nil {
+			log.Printf("%s: %s", name, err)
+			return failedResult(ft, err)
+		}
+
+		var out []reflect.Value
+		if ft.IsVariadic() {
+			out = fv.CallSlice(in)
+		} else {
+			out = fv.Call(in)
+		}
+
+		if len(out) == 0 {
+			return nil
+		}
+
+		// anko does not raise returned errors, so they would go unnoticed
+		if last := out[len(out)-1]; ft.Out(len(out)-1) == errorType && !last.IsNil() {
+			log.Printf("%s: %s", name, last.Interface())
+		}
+
+		return out[0].Interface()
+	}
+
+	// anko can only spread the arguments into the variadic tail
+	prefix := exprs[0]
+	if len(exprs) > 1 {
+		prefix = fmt.Sprintf("%s, [%s] + args", exprs[0], strings.Join(exprs[1:], ", "))
+	} else {
+		prefix += ", args"
+	}
+	_, err := vm.Execute(b.e, nil, fmt.Sprintf("func %s(args...) { return __%s(%s...) }", name, name, prefix))
+
+	return []error{b.e.Define("__"+name, hidden), err}
+}
+
+var (
+	errorType      = reflect.TypeOf((*error)(nil)).Elem()
+	gameResultType = reflect.TypeOf((*GameResult)(nil))
+)
+
+// failedResult returns what a builtin returns when it cannot be called, the
+// game builtins return a failed result, so that scripts can check its OK.
+func failedResult(ft reflect.Type, err error) interface{} {
+	if ft.NumOut() == 0 {
+		return nil
+	}
+	if ft.Out(0) == gameResultType {
+		return &GameResult{Error: err.Error()}
+	}
+
+	return reflect.Zero(ft.Out(0)).Interface()
+}
+
+// senderArgs converts script values to the arguments of a builtin defined
+// with defineWithSender.
+func senderArgs(ft reflect.Type, from interface{}, args []interface{}) ([]reflect.Value, error) {
+	name, _ := from.(string)
+	in := []reflect.Value{reflect.ValueOf(name)}
+
+	fixed := ft.NumIn() - 1
+	if ft.IsVariadic() {
+		fixed--
+	}
+
+	if len(args) < fixed || (!ft.IsVariadic() && len(args) > fixed) {
+		return nil, fmt.Errorf("wrong number of arguments: %d", len(args))
+	}
+
+	for i, arg := range args {
+		var t reflect.Type
+		if i < fixed {
+			t = ft.In(i + 1)
+		} else {
+			t = ft.In(ft.NumIn() - 1).Elem()
+		}
+
+		v, err := senderArg(arg, t)
+		if err != nil {
+			return nil, fmt.Errorf("argument %d: %w", i+1, err)
+		}
+		in = append(in, v)
+	}
+
+	if ft.IsVariadic() {
+		rest := reflect.MakeSlice(ft.In(ft.NumIn()-1), 0, len(in)-1-fixed)
+		rest = reflect.Append(rest, in[1+fixed:]...)
+		in = append(in[0:1+fixed], rest)
+	}
+
+	return in, nil
+}
+
+func senderArg(arg interface{}, t reflect.Type) (reflect.Value, error) {
+	if arg == nil {
+		switch t.Kind() {
+		case reflect.Interface, reflect.Ptr, reflect.Map, reflect.Slice:
+			return reflect.Zero(t), nil
+		}
+		return reflect.Value{}, fmt.Errorf("expected %s, got nil", t)
+	}
+
+	v := reflect.ValueOf(arg)
+	if v.Type().AssignableTo(t) {
+		return v, nil
+	}
+
+	if v.Type().ConvertibleTo(t) && v.Kind() != reflect.String && t.Kind() != reflect.String {
+		return v.Convert(t), nil
+	}
+
+	return reflect.Value{}, fmt.Errorf("expected %s, got %T", t, arg)
+}
+
 func (b *IRCBot) Start() error {
 	b.mu.Lock()
 	defer b.mu.Unlock()
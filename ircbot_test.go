@@ -0,0 +1,214 @@
+/**
+ * Copyright 2025 kmeaw
+ *
+ * Licensed under the GNU Affero General Public License (AGPL).
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU Affero General Public License as published by the
+ * Free Software Foundation, version 3 of the License.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or
+ * FITNESS FOR A PARTICULAR PURPOSE.  See the GNU Affero General Public License
+ * for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package main
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestTimelineSender(t *testing.T) {
+	timeline := NewTimeline()
+	rcons := NewRconPool()
+	rcons.SetTimeline(timeline)
+	rcons.SetDryRun(true)
+
+	b := NewIRCBot(nil, nil)
+	b.RconPool = rcons
+	b.Alerter = NewAlerter()
+	b.Timeline = timeline
+
+	err := b.LoadScript(Config{Script: `
+func cmd_imp() {
+	summon("DoomImp", {"angle": 90})
+	rcon("say %s", from())
+	alert("An imp!")
+	alert_to("spawns", "DoomImp", "imp.png", {"priority": 1})
+}
+`})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := b.ProcessMessage(context.Background(), "viewer", "!imp"); err != nil {
+		t.Fatal(err)
+	}
+
+	var entries []TimelineEntry
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		entries = timeline.Entries()
+		if len(entries) == 4 {
+			break
+		}
+	}
+
+	want := []TimelineEntry{
+		{Kind: "rcon", Target: DEFAULT_RCON_TARGET, From: "viewer", Text: "summon DoomImp 90", DryRun: true},
+		{Kind: "rcon", Target: DEFAULT_RCON_TARGET, From: "viewer", Text: "say viewer", DryRun: true},
+		{Kind: "alert", From: "viewer", Text: "An imp!"},
+		{Kind: "alert", Target: "spawns", From: "viewer", Text: "DoomImp"},
+	}
+	if len(entries) != len(want) {
+		t.Fatalf("timeline = %+v, want %+v", entries, want)
+	}
+	for i := range want {
+		entries[i].Time = time.Time{}
+		if entries[i] != want[i] {
+			t.Errorf("entry %d = %+v, want %+v", i, entries[i], want[i])
+		}
+	}
+
+	if history := b.Alerter.History(1); len(history) != 1 || history[0].Channel != "spawns" || history[0].From != "viewer" || history[0].Command != "imp" {
+		t.Errorf("alert history is %+v", history)
+	}
+}
+
+func TestProfileRconTarget(t *testing.T) {
+	timeline := NewTimeline()
+	rcons := NewRconPool()
+	rcons.SetTimeline(timeline)
+	rcons.SetDryRun(true)
+	rcons.Add("coop")
+
+	b := NewIRCBot(nil, nil)
+	b.RconPool = rcons
+	b.Timeline = timeline
+
+	err := b.LoadScript(Config{
+		Profiles: map[string]*LaunchProfile{
+			"heretic": {Engine: "zandronum", RconTarget: "coop"},
+		},
+		Profile: "heretic",
+		Script: `
+func cmd_imp() {
+	rcon("say %s", from())
+	if !summon("HereticImp").OK {
+		rcon("say failed")
+	}
+}
+`})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := b.ProcessMessage(context.Background(), "viewer", "!imp"); err != nil {
+		t.Fatal(err)
+	}
+
+	var entries []TimelineEntry
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		entries = timeline.Entries()
+		if len(entries) == 2 {
+			break
+		}
+	}
+
+	if len(entries) != 2 {
+		t.Fatalf("timeline = %+v, want 2 entries", entries)
+	}
+	for _, entry := range entries {
+		if entry.Target != "coop" {
+			t.Errorf("%q has been sent to %q, want %q", entry.Text, entry.Target, "coop")
+		}
+	}
+}
+
+// lockedBuffer collects the log of the script which runs in another goroutine.
+type lockedBuffer struct {
+	buf bytes.Buffer
+	mu  sync.Mutex
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.buf.Write(p)
+}
+
+func (b *lockedBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.buf.String()
+}
+
+func TestBuiltinErrorsAreLogged(t *testing.T) {
+	var buf lockedBuffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+
+	b := NewIRCBot(nil, nil)
+	b.RconPool = NewRconPool()
+	b.Alerter = NewAlerter()
+
+	err := b.LoadScript(Config{Script: `
+func cmd_bad() {
+	alert("Boss!", {"position": "nowhere"})
+	alert_to("bad channel", "Boss!")
+}
+`})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := b.ProcessMessage(context.Background(), "viewer", "!bad"); err != nil {
+		t.Fatal(err)
+	}
+
+	waitFor(t, "the script", func() bool {
+		return strings.Contains(buf.String(), "alert_to: ")
+	})
+
+	logs := buf.String()
+	for _, want := range []string{"alert: ", "nowhere", "alert_to: "} {
+		if !strings.Contains(logs, want) {
+			t.Errorf("%q has not been logged:\n%s", want, logs)
+		}
+	}
+	if history := b.Alerter.History(0); len(history) != 0 {
+		t.Errorf("invalid alerts have been shown: %+v", history)
+	}
+}
+
+func TestSenderArgs(t *testing.T) {
+	fn := func(from string, name string, n int, rest ...interface{}) {}
+
+	if _, err := senderArgs(reflect.TypeOf(fn), "viewer", []interface{}{"x", int64(3), "a", nil}); err != nil {
+		t.Errorf("senderArgs() = %s", err)
+	}
+
+	for _, args := range [][]interface{}{
+		{"x"},
+		{int64(1), int64(3)},
+		{"x", "3"},
+	} {
+		if _, err := senderArgs(reflect.TypeOf(fn), "viewer", args); err == nil {
+			t.Errorf("senderArgs(%v) has succeeded", args)
+		}
+	}
+}
+
+// vim: ai:ts=8:sw=8:noet:syntax=go
@@ -0,0 +1,129 @@
+/**
+ * Copyright 2025 kmeaw
+ *
+ * Licensed under the GNU Affero General Public License (AGPL).
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU Affero General Public License as published by the
+ * Free Software Foundation, version 3 of the License.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or
+ * FITNESS FOR A PARTICULAR PURPOSE.  See the GNU Affero General Public License
+ * for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package main
+
+import (
+	"math"
+	"time"
+)
+
+const (
+	// The loudness sounds are normalized to, in dBFS RMS.
+	SOUND_LOUDNESS = -20.0
+
+	// Quiet sounds are not boosted more than this (in dB), so that a
+	// mostly silent file does not turn into loud noise.
+	SOUND_MAX_BOOST = 20.0
+
+	// The loudness is measured in blocks, silent blocks (below the gate)
+	// and blocks much quieter than the rest of the sound are ignored, so
+	// pauses do not make a sound look quiet.
+	LOUDNESS_BLOCK         = 400 * time.Millisecond
+	LOUDNESS_GATE          = -70.0
+	LOUDNESS_RELATIVE_GATE = -10.0
+)
+
+func toDB(power float64) float64 {
+	return 10 * math.Log10(power)
+}
+
+// Loudness returns the gated RMS level of the sound in dBFS, silence is
+// -Inf.
+func Loudness(samples []int16, rate, channels int) float64 {
+	block := int(LOUDNESS_BLOCK.Seconds()*float64(rate)) * channels
+	if block <= 0 || block > len(samples) {
+		block = len(samples)
+	}
+
+	var powers []float64
+	for start := 0; start+block <= len(samples) && block > 0; start += block {
+		sum := 0.0
+		for _, sample := range samples[start : start+block] {
+			v := float64(sample) / 32768
+			sum += v * v
+		}
+		powers = append(powers, sum/float64(block))
+	}
+
+	gated := func(threshold float64) (float64, int) {
+		sum, n := 0.0, 0
+		for _, power := range powers {
+			if toDB(power) > threshold {
+				sum += power
+				n++
+			}
+		}
+		return sum, n
+	}
+
+	sum, n := gated(LOUDNESS_GATE)
+	if n == 0 {
+		return math.Inf(-1)
+	}
+
+	sum, n = gated(toDB(sum/float64(n)) + LOUDNESS_RELATIVE_GATE)
+	return toDB(sum / float64(n))
+}
+
+// Peak returns the highest absolute sample value in dBFS.
+func Peak(samples []int16) float64 {
+	peak := 0
+	for _, sample := range samples {
+		v := int(sample)
+		if v < 0 {
+			v = -v
+		}
+		if v > peak {
+			peak = v
+		}
+	}
+
+	return 20 * math.Log10(float64(peak)/32768)
+}
+
+// NormalizationGain returns the gain in dB which brings the sound to the
+// target loudness without clipping it.
+func NormalizationGain(samples []int16, rate, channels int, target float64) float64 {
+	loudness := Loudness(samples, rate, channels)
+	if math.IsInf(loudness, -1) {
+		return 0
+	}
+
+	gain := math.Min(target-loudness, SOUND_MAX_BOOST)
+	return math.Min(gain, -Peak(samples))
+}
+
+// ApplyGain changes the level of the samples in place.
+func ApplyGain(samples []int16, gain float64) {
+	if gain == 0 {
+		return
+	}
+
+	k := math.Pow(10, gain/20)
+	for i, sample := range samples {
+		v := math.Round(float64(sample) * k)
+		if v > math.MaxInt16 {
+			v = math.MaxInt16
+		} else if v < math.MinInt16 {
+			v = math.MinInt16
+		}
+		samples[i] = int16(v)
+	}
+}
+
+// vim: ai:ts=8:sw=8:noet:syntax=go
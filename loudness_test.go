@@ -0,0 +1,193 @@
+/**
+ * Copyright 2025 kmeaw
+ *
+ * Licensed under the GNU Affero General Public License (AGPL).
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU Affero General Public License as published by the
+ * Free Software Foundation, version 3 of the License.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or
+ * FITNESS FOR A PARTICULAR PURPOSE.  See the GNU Affero General Public License
+ * for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func square(level int16, frames int) []int16 {
+	samples := make([]int16, 2*frames)
+	for i := range samples {
+		if i/2%2 == 0 {
+			samples[i] = level
+		} else {
+			samples[i] = -level
+		}
+	}
+	return samples
+}
+
+func TestLoudness(t *testing.T) {
+	const rate = 1000
+
+	near := func(got, want float64) bool {
+		return math.Abs(got-want) < 0.1
+	}
+
+	// a square wave at half of the full scale is -6 dBFS
+	half := square(16384, rate)
+	if l := Loudness(half, rate, 2); !near(l, -6.02) {
+		t.Errorf("Loudness() = %.2f, want -6.02", l)
+	}
+
+	// pauses do not make the sound quieter
+	paused := append(append(square(16384, 2*rate/5), make([]int16, 4*rate)...), square(16384, 2*rate/5)...)
+	if l := Loudness(paused, rate, 2); !near(l, -6.02) {
+		t.Errorf("Loudness() with a pause = %.2f, want -6.02", l)
+	}
+
+	if l := Loudness(make([]int16, 2*rate), rate, 2); !math.IsInf(l, -1) {
+		t.Errorf("Loudness() of silence = %.2f", l)
+	}
+	if g := NormalizationGain(make([]int16, 2*rate), rate, 2, SOUND_LOUDNESS); g != 0 {
+		t.Errorf("NormalizationGain() of silence = %.2f", g)
+	}
+
+	// a loud sound is turned down to the target
+	if g := NormalizationGain(half, rate, 2, SOUND_LOUDNESS); !near(g, SOUND_LOUDNESS+6.02) {
+		t.Errorf("NormalizationGain() = %.2f, want %.2f", g, SOUND_LOUDNESS+6.02)
+	}
+
+	// a quiet sound is boosted, but not beyond the peak
+	spiky := square(100, rate)
+	spiky[0] = 16384
+	if g := NormalizationGain(spiky, rate, 2, SOUND_LOUDNESS); !near(g, 6.02) {
+		t.Errorf("NormalizationGain() of a spiky sound = %.2f, want 6.02", g)
+	}
+
+	// and never more than SOUND_MAX_BOOST
+	if g := NormalizationGain(square(100, rate), rate, 2, SOUND_LOUDNESS); g != SOUND_MAX_BOOST {
+		t.Errorf("NormalizationGain() of a quiet sound = %.2f, want %.2f", g, SOUND_MAX_BOOST)
+	}
+
+	samples := []int16{1000, -1000, 30000}
+	ApplyGain(samples, 6.0206)
+	if want := []int16{2000, -2000, 32767}; !reflect.DeepEqual(samples, want) {
+		t.Errorf("ApplyGain() = %v, want %v", samples, want)
+	}
+}
+
+func TestSoundCache(t *testing.T) {
+	var loads int32
+	c := NewSoundCache(func(name string) ([]int16, error) {
+		atomic.AddInt32(&loads, 1)
+		if name == "missing.wav" {
+			return nil, errors.New("no such file")
+		}
+		return make([]int16, 1000), nil
+	})
+	c.SetLimit(5000)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := c.Get("imp.wav"); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	if loads != 1 {
+		t.Errorf("the sound has been loaded %d times", loads)
+	}
+
+	c.Get("a.wav")
+	c.Get("imp.wav")
+	c.Get("b.wav")
+	if n, size := c.Size(); n != 2 || size != 4000 {
+		t.Errorf("Size() = %d, %d", n, size)
+	}
+
+	// a.wav is the least recently used one
+	loads = 0
+	c.Get("imp.wav")
+	c.Get("b.wav")
+	if loads != 0 {
+		t.Errorf("%d sound(s) have been evicted too early", loads)
+	}
+	c.Get("a.wav")
+	if loads != 1 {
+		t.Errorf("a.wav has been loaded %d times", loads)
+	}
+
+	c.Remove("a.wav")
+	c.Get("a.wav")
+	if loads != 2 {
+		t.Errorf("a.wav has not been reloaded after Remove()")
+	}
+
+	if _, err := c.Get("missing.wav"); err == nil {
+		t.Errorf("Get() of a missing sound has succeeded")
+	}
+
+	// a sound larger than the limit is still kept
+	c.SetLimit(1000)
+	if n, _ := c.Size(); n != 1 {
+		t.Errorf("%d sounds are cached", n)
+	}
+
+	c.Flush()
+	if n, size := c.Size(); n != 0 || size != 0 {
+		t.Errorf("Size() after Flush() = %d, %d", n, size)
+	}
+}
+
+func TestSoundForget(t *testing.T) {
+	s := NewSound()
+	s.cfg = &Config{zdrctConfigDir: t.TempDir()}
+	s.normalize = false
+
+	for _, level := range []int16{1000, 2000} {
+		if err := s.cfg.WriteAsset("imp.wav", wav16(48000, 2, constant(level, 10))); err != nil {
+			t.Fatal(err)
+		}
+		s.Forget("imp.wav")
+
+		samples, err := s.cache.Get("imp.wav")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if samples[0] != level {
+			t.Errorf("the cached sound starts with %d, want %d", samples[0], level)
+		}
+	}
+}
+
+func TestScriptSounds(t *testing.T) {
+	sounds := ScriptSounds(`
+Golem.AlertSound = "mumsit.mp3"
+Gargoyle.AlertSound = 'impsit.MP3'
+alert("Raid!", "raid.png", "raid.ogg")
+play("mumsit.mp3")
+reply("enjoy the .wav")
+`)
+	if want := []string{"mumsit.mp3", "impsit.MP3", "raid.ogg"}; fmt.Sprint(sounds) != fmt.Sprint(want) {
+		t.Errorf("ScriptSounds() = %q, want %q", sounds, want)
+	}
+}
+
+// vim: ai:ts=8:sw=8:noet:syntax=go
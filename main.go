@@ -22,6 +22,8 @@ import (
 	"crypto/rand"
 	"encoding/base64"
 	"encoding/json"
+	"fmt"
+	"io"
 	"log"
 	"net"
 	"net/http"
@@ -30,15 +32,24 @@ import (
 	"os/exec"
 	"path/filepath"
 	"runtime"
+	"strconv"
 	"strings"
 	"time"
 
+	"github.com/gin-gonic/contrib/renders/multitemplate"
 	"github.com/gin-gonic/gin"
 	"github.com/mattn/anko/parser"
 	"golang.org/x/net/websocket"
+
+	"zdrct/wad"
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "fake-server" {
+		fakeServerMain(os.Args[2:])
+		return
+	}
+
 	if len(os.Args) > 0 {
 		dir, _ := filepath.Split(os.Args[0])
 		if dir != "" {
@@ -58,9 +69,10 @@ func main() {
 		Purpose: "bot",
 	})
 	bot.Scopes = strings.Split(DEFAULT_BOT_SCOPES, ",")
-	rcon := NewRconClient()
+	rcons := NewRconPool()
+	rcon := rcons.Get(DEFAULT_RCON_TARGET)
 	ircbot := NewIRCBot(broadcaster, bot)
-	ircbot.RconClient = rcon
+	ircbot.RconPool = rcons
 	remote := NewRemote(ircbot)
 	alerter := NewAlerter()
 	ircbot.Alerter = alerter
@@ -74,6 +86,13 @@ func main() {
 	if err != nil {
 		log.Fatalf("error loading config file: %s", err)
 	}
+	alerter.Styles = config.AlertStyles()
+	if err := alerter.SetAudio(config.AlertAudioOutput()); err != nil {
+		log.Printf("invalid alert audio setting: %s", err)
+	}
+	if err := alerter.LoadHistory(config.AlertHistoryFile()); err != nil {
+		log.Printf("cannot load the alert history: %s", err)
+	}
 
 	gin.SetMode(gin.ReleaseMode)
 	r := gin.New()
@@ -134,15 +153,123 @@ func main() {
 
 	rcon.Addr, _ = net.ResolveUDPAddr("udp", config.RconAddress)
 	rcon.Password = config.RconPassword
+	for name, target := range config.RconTargets {
+		r := rcons.Add(name)
+		r.Addr, _ = net.ResolveUDPAddr("udp", target.Address)
+		r.Password = target.Password
+	}
+
+	timeline := NewTimeline()
+	rcons.SetTimeline(timeline)
+	remote.SetTimeline(timeline)
+
+	widgets := &Widgets{
+		IRCBot:    ircbot,
+		RconPool:  rcons,
+		Templates: r.HTMLRender.(multitemplate.Render),
+	}
+	timeline.OnAdd(widgets.Record)
+	rcons.SetDeadPeerTimeout(time.Duration(config.RconDeadPeerTimeout) * time.Second)
+	rcons.SetDryRun(config.RconDryRun)
+	if policy, err := NewRconPolicy(config.RconPolicy, config.RconPolicyVerbs); err != nil {
+		log.Printf("cannot set up the RCON policy: %s", err)
+	} else {
+		rcons.SetPolicy(policy)
+	}
+	rcons.OnStateChange(func(r *RconClient, old, new RconState) {
+		log.Printf("rcon %q: %s -> %s", r.Name, old, new)
+
+		var event string
+		if new == RCON_ONLINE {
+			event = "!event_rcon_online"
+		} else if old == RCON_ONLINE {
+			event = "!event_rcon_offline"
+		} else {
+			return
+		}
+
+		err := ircbot.ProcessMessage(context.Background(), "", event+" "+r.Name)
+		if err != nil {
+			log.Println(err)
+		}
+	})
+
+	engine := NewEngineProcess()
+	gamedata := &GameData{}
+	ircbot.GameData = gamedata
+	ircbot.Timeline = timeline
+
+	demos := NewDemoRecorder(config.DemoDir())
+	demos.SetEnabled(config.RecordDemos)
+	timeline.OnAdd(demos.Record)
+	engine.BeforeStart(demos.Prepare)
+	engine.OnStartError(demos.Abort)
+
+	engine.OnExit(func(p *EngineProcess, status EngineStatus) {
+		demos.Finish(status)
+
+		err := ircbot.ProcessMessage(context.Background(), "", fmt.Sprintf("!event_doom_exit %d", status.ExitCode))
+		if err != nil {
+			log.Println(err)
+		}
+	})
+
+	engineTarget := func(p *EngineProcess) (*RconClient, *RconTarget, error) {
+		name := DEFAULT_RCON_TARGET
+		if profile := config.Profiles[p.Status().Profile]; profile != nil && profile.RconTarget != "" {
+			name = profile.RconTarget
+		}
+
+		target := rcons.Get(name)
+		settings := config.GetRconTarget(name)
+		if target == nil || settings == nil {
+			return nil, nil, fmt.Errorf("no such RCON target: %q", name)
+		}
+
+		return target, settings, nil
+	}
+
+	engine.SetReadyProbe(func(p *EngineProcess) error {
+		_, settings, err := engineTarget(p)
+		if err != nil {
+			return err
+		}
+
+		return ProbeRcon(settings.Address, RECV_TIMEOUT)
+	})
+
+	engine.OnReady(func(p *EngineProcess) {
+		target, settings, err := engineTarget(p)
+		if err != nil {
+			log.Printf("cannot connect to the launched engine: %s", err)
+			return
+		}
+
+		err = target.Connect(settings.Address, settings.Password)
+		if err != nil {
+			log.Printf("cannot connect to the launched engine: %s", err)
+			return
+		}
+
+		p.MarkConnected()
+	})
 
 	s := NewSound()
 	err = s.Init()
 	if err != nil {
 		log.Printf("cannot start sound system: %s", err)
 	}
+	s.Configure(config.SoundSettings())
 	alerter.Sound = s
 	ircbot.Sound = s
 
+	tts := NewTTS(s)
+	if err := tts.Configure(config.TTSSettings()); err != nil {
+		log.Printf("invalid TTS settings: %s", err)
+	}
+	go tts.Run()
+	ircbot.TTS = tts
+
 	r.GET("/oauth", func(c *gin.Context) {
 		c.HTML(http.StatusOK, "oauth.html", nil)
 	})
@@ -384,31 +511,183 @@ func main() {
 		c.Redirect(http.StatusFound, twitch_impl.GetAuthLink("http://localhost:8666/oauth", p.CSRF))
 	})
 
-	r.GET("/alerts", func(c *gin.Context) {
-		c.HTML(http.StatusOK, "alerts.html", nil)
+	r.GET("/widgets/:name", func(c *gin.Context) {
+		update, err := widgets.Update(c.Param("name"), c.Request.URL.Query())
+		if err != nil {
+			c.HTML(http.StatusOK, "error.html", gin.H{"Error": err.Error()})
+			return
+		}
+
+		c.HTML(http.StatusOK, "widget.html", gin.H{
+			"Widget": c.Param("name"),
+			"Update": update,
+		})
 	})
 
-	r.GET("/alerts/ws", func(c *gin.Context) {
+	r.GET("/widgets/:name/ws", func(c *gin.Context) {
+		name, query := c.Param("name"), c.Request.URL.Query()
+		if _, err := widgets.Update(name, query); err != nil {
+			c.AbortWithError(http.StatusBadRequest, err)
+			return
+		}
+
 		handler := websocket.Handler(func(ws *websocket.Conn) {
 			defer ws.Close()
+
+			// the overlay does not send anything, reading detects the disconnect
+			closed := make(chan struct{})
+			go func() {
+				defer close(closed)
+				io.Copy(io.Discard, ws)
+			}()
+
+			t := time.NewTicker(WIDGET_INTERVAL)
+			defer t.Stop()
+
 			enc := json.NewEncoder(ws)
-			ch := alerter.Subscribe()
+			var last string
 			for {
+				update, err := widgets.Update(name, query)
+				if err != nil {
+					log.Printf("cannot update widget %q: %s", name, err)
+				} else if string(update.HTML) != last {
+					if err := enc.Encode(update); err != nil {
+						log.Printf("cannot send widget %q: %s", name, err)
+						return
+					}
+					last = string(update.HTML)
+				}
+
 				select {
-				case <-c.Request.Context().Done():
+				case <-closed:
 					return
-				case alert := <-ch:
-					err := enc.Encode(alert)
-					if err != nil {
-						log.Printf("cannot send alert: %s", err)
+				case <-t.C:
+				}
+			}
+		})
+		handler.ServeHTTP(c.Writer, c.Request)
+	})
+
+	r.GET("/alerts", func(c *gin.Context) {
+		c.HTML(http.StatusOK, "alerts.html", nil)
+	})
+
+	r.GET("/alerts/ws", func(c *gin.Context) {
+		channel := c.DefaultQuery("channel", DEFAULT_ALERT_CHANNEL)
+		if err := CheckAlertChannel(channel); err != nil {
+			c.AbortWithError(http.StatusBadRequest, err)
+			return
+		}
+
+		handler := websocket.Handler(func(ws *websocket.Conn) {
+			defer ws.Close()
+			sub := alerter.Subscribe(channel)
+			defer alerter.Unsubscribe(sub)
+
+			go func() {
+				defer alerter.Unsubscribe(sub)
+				dec := json.NewDecoder(ws)
+				for {
+					var msg struct {
+						Done uint64 `json:"done"`
+					}
+					if err := dec.Decode(&msg); err != nil {
 						return
 					}
+					alerter.Done(sub, msg.Done)
+				}
+			}()
+
+			enc := json.NewEncoder(ws)
+			for msg := range sub.C {
+				err := enc.Encode(msg)
+				if err != nil {
+					log.Printf("cannot send alert: %s", err)
+					return
 				}
 			}
 		})
 		handler.ServeHTTP(c.Writer, c.Request)
 	})
 
+	r.GET("/alerts/styles", func(c *gin.Context) {
+		c.JSON(http.StatusOK, alerter.Styles.List())
+	})
+
+	r.GET("/alerts/styles/:name", func(c *gin.Context) {
+		name := c.Param("name")
+		if !strings.HasSuffix(name, ".css") || CheckAlertStyle(strings.TrimSuffix(name, ".css")) != nil {
+			c.AbortWithStatus(http.StatusNotFound)
+			return
+		}
+
+		filename, err := alerter.Styles.File(name)
+		if err != nil {
+			c.AbortWithStatus(http.StatusNotFound)
+			return
+		}
+
+		c.File(filename)
+	})
+
+	r.GET("/alerts/queue", func(c *gin.Context) {
+		c.JSON(http.StatusOK, alerter.Queues())
+	})
+
+	r.POST("/alerts/skip", func(c *gin.Context) {
+		var p struct {
+			ID uint64 `form:"id"`
+		}
+
+		if err := c.ShouldBind(&p); err != nil {
+			c.AbortWithError(http.StatusBadRequest, err)
+			return
+		}
+
+		alerter.Skip(p.ID)
+		c.Redirect(http.StatusFound, "/?tab=twitch")
+	})
+
+	r.GET("/alerts/history", func(c *gin.Context) {
+		limit, _ := strconv.Atoi(c.Query("limit"))
+		c.JSON(http.StatusOK, alerter.History(limit))
+	})
+
+	r.POST("/alerts/replay", func(c *gin.Context) {
+		var p struct {
+			ID uint64 `form:"id" binding:"required"`
+		}
+
+		if err := c.ShouldBind(&p); err != nil {
+			c.AbortWithError(http.StatusBadRequest, err)
+			return
+		}
+
+		if _, err := alerter.Replay(p.ID, SOUND_VOLUME); err != nil {
+			c.HTML(http.StatusOK, "error.html", gin.H{"Error": err.Error()})
+			return
+		}
+		c.Redirect(http.StatusFound, "/?tab=twitch")
+	})
+
+	r.POST("/alerts/priority", func(c *gin.Context) {
+		var p struct {
+			ID       uint64 `form:"id" binding:"required"`
+			Priority int    `form:"priority"`
+		}
+
+		if err := c.ShouldBind(&p); err != nil {
+			c.AbortWithError(http.StatusBadRequest, err)
+			return
+		}
+
+		if !alerter.SetPriority(p.ID, p.Priority) {
+			c.HTML(http.StatusOK, "error.html", gin.H{"Error": "the alert is not in the queue"})
+			return
+		}
+		c.Redirect(http.StatusFound, "/?tab=twitch")
+	})
+
 	r.POST("/startbot", func(c *gin.Context) {
 		err := loadScript(c)
 		if err != nil {
@@ -460,43 +739,417 @@ func main() {
 			return
 		}
 
-		var args []string
-		for _, line := range strings.Split(p.Args, "\n") {
-			line = strings.TrimSpace(line)
-			if len(line) == 0 {
-				continue
+		args, err := ResolveDoomArgs(p.Path, p.Args)
+		if err != nil {
+			c.HTML(http.StatusOK, "error.html", gin.H{"Error": err.Error()})
+			return
+		}
+		for _, warning := range DoomArgsWarnings(p.Path, p.Args) {
+			log.Printf("engine arguments: %s", warning)
+		}
+
+		err = engine.Start("", p.Path, args)
+		if err != nil {
+			c.HTML(http.StatusOK, "error.html", gin.H{"Error": err.Error()})
+			return
+		}
+
+		config.DoomExe = p.Path
+		config.DoomArgs = p.Args
+		if err := config.Save(); err != nil {
+			log.Printf("cannot save config: %s", err)
+		}
+
+		c.Redirect(http.StatusFound, "/?tab=doomexe")
+	})
+
+	// applyProfile loads the script of the active launch profile.
+	applyProfile := func() error {
+		if err := config.LoadScript(); err != nil {
+			return err
+		}
+
+		if err := ircbot.LoadScript(*config); err != nil {
+			return err
+		}
+
+		if err := config.Save(); err != nil {
+			log.Printf("cannot save config: %s", err)
+		}
+
+		event := RemoteEvent{}
+		event.Config.Buttons = ircbot.GetButtons()
+		remote.SetConfig(event)
+
+		return nil
+	}
+
+	r.POST("/profiles", func(c *gin.Context) {
+		var p struct {
+			Name       string `form:"name"`
+			Engine     string `form:"engine"`
+			Iwad       string `form:"iwad"`
+			Pwads      string `form:"pwads"`
+			Args       string `form:"args"`
+			RconTarget string `form:"rcon_target"`
+			Script     string `form:"script"`
+		}
+
+		if err := c.ShouldBind(&p); err != nil {
+			c.AbortWithError(http.StatusBadRequest, err)
+			return
+		}
+
+		if err := ValidateProfileName(p.Name); err != nil {
+			c.HTML(http.StatusOK, "error.html", gin.H{"Error": err.Error()})
+			return
+		}
+
+		profile := &LaunchProfile{
+			Engine:     strings.TrimSpace(p.Engine),
+			Iwad:       strings.TrimSpace(p.Iwad),
+			Args:       p.Args,
+			RconTarget: p.RconTarget,
+			Script:     strings.TrimSpace(p.Script),
+		}
+		for _, pwad := range strings.Split(p.Pwads, "\n") {
+			if pwad = strings.TrimSpace(pwad); pwad != "" {
+				profile.Pwads = append(profile.Pwads, pwad)
 			}
-			if line[0] == '#' {
-				continue
+		}
+
+		if err := profile.Validate(); err != nil {
+			c.HTML(http.StatusOK, "error.html", gin.H{"Error": err.Error()})
+			return
+		}
+
+		old := config.Profiles[p.Name]
+		if config.Profiles == nil {
+			config.Profiles = make(map[string]*LaunchProfile)
+		}
+		config.Profiles[p.Name] = profile
+
+		if p.Name == config.Profile && old.ScriptFile() != profile.ScriptFile() {
+			if err := applyProfile(); err != nil {
+				c.HTML(http.StatusOK, "error.html", gin.H{"Error": err.Error()})
+				return
 			}
+		} else if err := config.Save(); err != nil {
+			log.Printf("cannot save config: %s", err)
+		}
 
-			if line[0] == '-' || line[0] == '+' {
-				idx := strings.IndexRune(line, ' ')
-				if idx == -1 {
-					args = append(args, line)
-				} else {
-					args = append(args, line[0:idx], line[idx+1:])
-				}
-			} else {
-				args = append(args, line)
+		c.Redirect(http.StatusFound, "/?tab=doomexe")
+	})
+
+	r.POST("/profiles/select", func(c *gin.Context) {
+		name := c.PostForm("profile")
+		if name != "" && config.Profiles[name] == nil {
+			c.HTML(http.StatusOK, "error.html", gin.H{"Error": "no such profile: " + name})
+			return
+		}
+
+		if name != config.Profile {
+			config.Profile = name
+			if err := applyProfile(); err != nil {
+				c.HTML(http.StatusOK, "error.html", gin.H{"Error": err.Error()})
+				return
 			}
 		}
 
-		err := inject(p.Path, args...)
+		c.Redirect(http.StatusFound, "/?tab=doomexe")
+	})
+
+	r.POST("/profiles/run", func(c *gin.Context) {
+		profile := config.ActiveProfile()
+		if profile == nil {
+			c.HTML(http.StatusOK, "error.html", gin.H{"Error": "no launch profile is selected"})
+			return
+		}
+
+		args, err := profile.CommandLine()
 		if err != nil {
 			c.HTML(http.StatusOK, "error.html", gin.H{"Error": err.Error()})
 			return
 		}
+		for _, warning := range DoomArgsWarnings(profile.Engine, profile.Args) {
+			log.Printf("engine arguments of %q: %s", config.Profile, warning)
+		}
 
-		config.DoomExe = p.Path
-		config.DoomArgs = p.Args
-		if err := config.Save(); err != nil {
+		if err := engine.Start(config.Profile, profile.Engine, args); err != nil {
+			c.HTML(http.StatusOK, "error.html", gin.H{"Error": err.Error()})
+			return
+		}
+
+		c.Redirect(http.StatusFound, "/?tab=doomexe")
+	})
+
+	r.POST("/profiles/:name/delete", func(c *gin.Context) {
+		name := c.Param("name")
+		if config.Profiles[name] == nil {
+			c.HTML(http.StatusOK, "error.html", gin.H{"Error": "no such profile: " + name})
+			return
+		}
+
+		delete(config.Profiles, name)
+		if name == config.Profile {
+			config.Profile = ""
+			if err := applyProfile(); err != nil {
+				c.HTML(http.StatusOK, "error.html", gin.H{"Error": err.Error()})
+				return
+			}
+		} else if err := config.Save(); err != nil {
 			log.Printf("cannot save config: %s", err)
 		}
 
 		c.Redirect(http.StatusFound, "/?tab=doomexe")
 	})
 
+	for action, fn := range map[string]func() error{
+		"stop":    engine.Stop,
+		"kill":    engine.Kill,
+		"restart": engine.Restart,
+	} {
+		fn := fn
+		r.POST("/doom/"+action, func(c *gin.Context) {
+			if err := fn(); err != nil {
+				c.HTML(http.StatusOK, "error.html", gin.H{"Error": err.Error()})
+				return
+			}
+
+			c.Redirect(http.StatusFound, "/?tab=doomexe")
+		})
+	}
+
+	r.GET("/doom/preview", func(c *gin.Context) {
+		if name := c.Query("profile"); name != "" {
+			profile := config.Profiles[name]
+			if profile == nil {
+				c.AbortWithStatusJSON(http.StatusNotFound, map[string]string{
+					"error":       "no_such_profile",
+					"description": name,
+				})
+				return
+			}
+
+			args, err := profile.CommandLine()
+			preview := PreviewCommandLine(profile.Engine, args, err)
+			preview.Warnings = DoomArgsWarnings(profile.Engine, profile.Args)
+			c.JSON(http.StatusOK, preview)
+			return
+		}
+
+		path := c.Query("path")
+		args, err := ResolveDoomArgs(path, c.Query("args"))
+		preview := PreviewCommandLine(path, args, err)
+		preview.Warnings = DoomArgsWarnings(path, c.Query("args"))
+		c.JSON(http.StatusOK, preview)
+	})
+
+	r.GET("/doom/status", func(c *gin.Context) {
+		c.JSON(http.StatusOK, engine.Status())
+	})
+
+	r.GET("/doom/log", func(c *gin.Context) {
+		c.String(http.StatusOK, strings.Join(engine.Log.Lines(0), "\n"))
+	})
+
+	r.GET("/demos", func(c *gin.Context) {
+		list, err := demos.List()
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, map[string]string{
+				"error":       "demo_list_failed",
+				"description": err.Error(),
+			})
+			return
+		}
+
+		c.JSON(http.StatusOK, list)
+	})
+
+	r.GET("/demos/:session/:name", func(c *gin.Context) {
+		filename, err := demos.File(c.Param("session"), c.Param("name"))
+		if err != nil {
+			c.AbortWithError(http.StatusBadRequest, err)
+			return
+		}
+
+		c.FileAttachment(filename, c.Param("name"))
+	})
+
+	r.GET("/wad/lumps", func(c *gin.Context) {
+		lumps, err := gamedata.Lumps(config.ActiveProfile())
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusNotFound, map[string]string{
+				"error":       "no_game_data",
+				"description": err.Error(),
+			})
+			return
+		}
+
+		type lumpInfo struct {
+			*wad.Lump
+			Archive string `json:"archive"`
+		}
+
+		ns, filter := c.GetQuery("namespace")
+		result := make([]lumpInfo, 0, len(lumps))
+		for _, lump := range lumps {
+			if filter && lump.Namespace != ns {
+				continue
+			}
+			result = append(result, lumpInfo{lump, lump.Archive().Path})
+		}
+
+		c.JSON(http.StatusOK, result)
+	})
+
+	r.GET("/wad/actors", func(c *gin.Context) {
+		actors, err := gamedata.Actors(config.ActiveProfile())
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusNotFound, map[string]string{
+				"error":       "no_game_data",
+				"description": err.Error(),
+			})
+			return
+		}
+
+		c.JSON(http.StatusOK, actors)
+	})
+
+	r.GET("/wad/maps", func(c *gin.Context) {
+		maps, err := gamedata.Maps(config.ActiveProfile())
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusNotFound, map[string]string{
+				"error":       "no_game_data",
+				"description": err.Error(),
+			})
+			return
+		}
+
+		c.JSON(http.StatusOK, maps)
+	})
+
+	r.GET("/wad/sprites", func(c *gin.Context) {
+		sprites, err := gamedata.Sprites(config.ActiveProfile())
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusNotFound, map[string]string{
+				"error":       "no_game_data",
+				"description": err.Error(),
+			})
+			return
+		}
+
+		c.JSON(http.StatusOK, sprites)
+	})
+
+	r.GET("/wad/sounds", func(c *gin.Context) {
+		sounds, err := gamedata.Sounds(config.ActiveProfile())
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusNotFound, map[string]string{
+				"error":       "no_game_data",
+				"description": err.Error(),
+			})
+			return
+		}
+
+		c.JSON(http.StatusOK, sounds)
+	})
+
+	spriteSize := func(c *gin.Context) (int, error) {
+		size, err := strconv.Atoi(c.DefaultQuery("size", c.DefaultPostForm("size", "0")))
+		if err != nil || size < 0 || size > MAX_SPRITE_SIZE {
+			return 0, fmt.Errorf("size must be between 0 and %d", MAX_SPRITE_SIZE)
+		}
+
+		return size, nil
+	}
+
+	r.GET("/wad/sprites/:name", func(c *gin.Context) {
+		size, err := spriteSize(c)
+		if err != nil {
+			c.String(http.StatusBadRequest, err.Error())
+			return
+		}
+
+		data, err := gamedata.SpritePNG(config.ActiveProfile(), c.Param("name"), size)
+		if err != nil {
+			c.String(http.StatusNotFound, err.Error())
+			return
+		}
+
+		c.Data(http.StatusOK, "image/png", data)
+	})
+
+	r.GET("/wad/sounds/:name", func(c *gin.Context) {
+		data, err := gamedata.SoundWAV(config.ActiveProfile(), c.Param("name"))
+		if err != nil {
+			c.String(http.StatusNotFound, err.Error())
+			return
+		}
+
+		c.Data(http.StatusOK, "audio/wav", data)
+	})
+
+	r.POST("/wad/extract", func(c *gin.Context) {
+		var p struct {
+			ID     string `form:"id"`
+			Sprite string `form:"sprite"`
+			Sound  string `form:"sound"`
+		}
+
+		fail := func(err error) {
+			c.AbortWithStatusJSON(http.StatusOK, map[string]string{
+				"error":       "extract_failed",
+				"description": err.Error(),
+			})
+			log.Printf("asset extraction failed: %s", err)
+		}
+
+		if err := c.ShouldBind(&p); err != nil {
+			fail(err)
+			return
+		}
+
+		if !assetID.MatchString(p.ID) {
+			fail(fmt.Errorf("invalid asset name: %q", p.ID))
+			return
+		}
+
+		size, err := spriteSize(c)
+		if err != nil {
+			fail(err)
+			return
+		}
+
+		result := map[string]string{}
+		if p.Sprite != "" {
+			data, err := gamedata.SpritePNG(config.ActiveProfile(), p.Sprite, size)
+			if err == nil {
+				err = config.WriteAsset(p.ID+".png", data)
+			}
+			if err != nil {
+				fail(err)
+				return
+			}
+			result["image"] = p.ID + ".png"
+		}
+
+		if p.Sound != "" {
+			data, err := gamedata.SoundWAV(config.ActiveProfile(), p.Sound)
+			if err == nil {
+				err = config.WriteAsset(p.ID+".wav", data)
+			}
+			if err != nil {
+				fail(err)
+				return
+			}
+			s.Forget(p.ID + ".wav")
+			result["sound"] = p.ID + ".wav"
+		}
+
+		c.JSON(http.StatusOK, result)
+	})
+
 	r.POST("/rcon/config", func(c *gin.Context) {
 		var p struct {
 			Addr     string `form:"addr"`
@@ -511,6 +1164,9 @@ func main() {
 		rcon.Close()
 
 		err = rcon.Connect(p.Addr, p.Password)
+		if config.RconAutoStart {
+			rcon.Supervise(p.Addr, p.Password)
+		}
 		if err != nil {
 			c.HTML(http.StatusOK, "error.html", gin.H{"Error": err.Error()})
 			return
@@ -525,8 +1181,74 @@ func main() {
 		c.Redirect(http.StatusFound, "/?tab=rcon")
 	})
 
+	r.POST("/rcon/targets", func(c *gin.Context) {
+		var p struct {
+			Name      string `form:"name"`
+			Addr      string `form:"addr"`
+			Password  string `form:"password"`
+			AutoStart bool   `form:"auto_start"`
+		}
+
+		if err := c.ShouldBind(&p); err != nil {
+			c.AbortWithError(http.StatusBadRequest, err)
+			return
+		}
+
+		if p.Name == DEFAULT_RCON_TARGET {
+			c.HTML(http.StatusOK, "error.html", gin.H{"Error": "the default target is configured above"})
+			return
+		}
+
+		if err := ValidateRconTargetName(p.Name); err != nil {
+			c.HTML(http.StatusOK, "error.html", gin.H{"Error": err.Error()})
+			return
+		}
+
+		if config.RconTargets == nil {
+			config.RconTargets = make(map[string]*RconTarget)
+		}
+		config.RconTargets[p.Name] = &RconTarget{
+			Address:   p.Addr,
+			Password:  p.Password,
+			AutoStart: p.AutoStart,
+		}
+		if err := config.Save(); err != nil {
+			log.Printf("cannot save config: %s", err)
+		}
+
+		target := rcons.Add(p.Name)
+		target.Close()
+
+		err = target.Connect(p.Addr, p.Password)
+		if p.AutoStart {
+			target.Supervise(p.Addr, p.Password)
+		}
+		if err != nil {
+			c.HTML(http.StatusOK, "error.html", gin.H{"Error": err.Error()})
+			return
+		}
+
+		c.Redirect(http.StatusFound, "/?tab=rcon")
+	})
+
+	r.POST("/rcon/targets/:name/delete", func(c *gin.Context) {
+		name := c.Param("name")
+		if err := rcons.Remove(name); err != nil {
+			c.HTML(http.StatusOK, "error.html", gin.H{"Error": err.Error()})
+			return
+		}
+
+		delete(config.RconTargets, name)
+		if err := config.Save(); err != nil {
+			log.Printf("cannot save config: %s", err)
+		}
+
+		c.Redirect(http.StatusFound, "/?tab=rcon")
+	})
+
 	r.POST("/rcon", func(c *gin.Context) {
 		var p struct {
+			Target  string `form:"target"`
 			Command string `form:"command"`
 		}
 
@@ -535,12 +1257,18 @@ func main() {
 			return
 		}
 
-		if !rcon.IsOnline() {
+		target := rcons.Get(p.Target)
+		if target == nil {
+			c.HTML(http.StatusOK, "error.html", gin.H{"Error": "no such target"})
+			return
+		}
+
+		if !target.CanSend() {
 			c.HTML(http.StatusOK, "error.html", gin.H{"Error": "not connected"})
 			return
 		}
 
-		err = rcon.Command(p.Command)
+		err = target.Command(p.Command)
 		if err != nil {
 			c.HTML(http.StatusOK, "error.html", gin.H{"Error": err.Error()})
 			return
@@ -549,12 +1277,34 @@ func main() {
 		c.Redirect(http.StatusFound, "/?tab=rcon")
 	})
 
+	r.GET("/timeline", func(c *gin.Context) {
+		c.JSON(http.StatusOK, timeline.Entries())
+	})
+
 	r.POST("/settings", func(c *gin.Context) {
 		var p struct {
+			TtsProvider            string `form:"tts_provider"`
 			TtsEndpoint            string `form:"tts_endpoint"`
+			TtsCommand             string `form:"tts_command"`
+			TtsVoice               string `form:"tts_voice"`
+			TtsMaxLength           int    `form:"tts_max_length"`
+			TtsFilter              string `form:"tts_filter"`
 			RconAutoStart          bool   `form:"rcon_auto_start"`
+			RconDeadPeerTimeout    int    `form:"rcon_dead_peer_timeout"`
+			RconDryRun             bool   `form:"rcon_dry_run"`
+			RconPolicy             string `form:"rcon_policy"`
+			RconPolicyVerbs        string `form:"rcon_policy_verbs"`
 			NoMappedRewardCommands bool   `form:"no_mapped_reward_commands"`
+			RecordDemos            bool   `form:"record_demos"`
 			SoundVolume            int    `form:"sound_volume"`
+			AlertVolume            int    `form:"alert_volume"`
+			TtsVolume              int    `form:"tts_volume"`
+			EffectsVolume          int    `form:"effects_volume"`
+			MaxVoices              int    `form:"max_voices"`
+			TtsDucking             int    `form:"tts_ducking"`
+			NormalizeSounds        bool   `form:"normalize_sounds"`
+			SoundCacheSize         int    `form:"sound_cache_size"`
+			AlertAudio             string `form:"alert_audio"`
 		}
 
 		if err := c.ShouldBind(&p); err != nil {
@@ -562,10 +1312,72 @@ func main() {
 			return
 		}
 
+		if p.AlertAudio == "" {
+			p.AlertAudio = ALERT_AUDIO_LOCAL
+		}
+		if err := checkAlertOption("alert audio output", p.AlertAudio, ALERT_AUDIO_OUTPUTS); err != nil {
+			c.HTML(http.StatusOK, "error.html", gin.H{"Error": err.Error()})
+			return
+		}
+
+		if err := CheckTTSProvider(p.TtsProvider); err != nil {
+			c.HTML(http.StatusOK, "error.html", gin.H{"Error": err.Error()})
+			return
+		}
+
+		verbs := strings.Fields(p.RconPolicyVerbs)
+		policy, err := NewRconPolicy(p.RconPolicy, verbs)
+		if err != nil {
+			c.HTML(http.StatusOK, "error.html", gin.H{"Error": err.Error()})
+			return
+		}
+
+		// nothing is applied until all the settings have been checked
+		ttsSettings := TTSSettings{
+			Provider:  p.TtsProvider,
+			Endpoint:  p.TtsEndpoint,
+			Command:   p.TtsCommand,
+			Voice:     p.TtsVoice,
+			MaxLength: p.TtsMaxLength,
+			Filter:    strings.Fields(p.TtsFilter),
+		}
+		if err := tts.Configure(ttsSettings); err != nil {
+			log.Printf("cannot configure TTS: %s", err)
+		}
+
+		config.TtsProvider = p.TtsProvider
 		config.TtsEndpoint = p.TtsEndpoint
+		config.TtsCommand = p.TtsCommand
+		config.TtsVoice = p.TtsVoice
+		config.TtsMaxLength = p.TtsMaxLength
+		config.TtsFilter = ttsSettings.Filter
+		if p.RconAutoStart && !config.RconAutoStart {
+			rcon.Supervise(config.RconAddress, config.RconPassword)
+		} else if !p.RconAutoStart && config.RconAutoStart {
+			rcon.Unsupervise()
+		}
 		config.RconAutoStart = p.RconAutoStart
+		config.RconDeadPeerTimeout = p.RconDeadPeerTimeout
+		rcons.SetDeadPeerTimeout(time.Duration(p.RconDeadPeerTimeout) * time.Second)
+		config.RconDryRun = p.RconDryRun
+		rcons.SetDryRun(p.RconDryRun)
+		config.RconPolicy = p.RconPolicy
+		config.RconPolicyVerbs = verbs
+		rcons.SetPolicy(policy)
 		config.NoMappedRewardCommands = p.NoMappedRewardCommands
+		config.RecordDemos = p.RecordDemos
+		demos.SetEnabled(p.RecordDemos)
 		config.SoundVolume = p.SoundVolume
+		config.AlertVolume = p.AlertVolume
+		config.TtsVolume = p.TtsVolume
+		config.EffectsVolume = p.EffectsVolume
+		config.MaxVoices = p.MaxVoices
+		config.TtsDucking = p.TtsDucking
+		config.NormalizeSounds = p.NormalizeSounds
+		config.SoundCacheSize = p.SoundCacheSize
+		config.AlertAudio = p.AlertAudio
+		s.Configure(config.SoundSettings())
+		alerter.SetAudio(config.AlertAudioOutput())
 
 		if err := config.Save(); err != nil {
 			log.Printf("cannot save config: %s", err)
@@ -579,14 +1391,35 @@ func main() {
 		if tab == "" {
 			tab = "twitch"
 		}
+
+		args, err := ResolveDoomArgs(config.DoomExe, config.DoomArgs)
+		doomPreview := PreviewCommandLine(config.DoomExe, args, err)
+		doomPreview.Warnings = DoomArgsWarnings(config.DoomExe, config.DoomArgs)
+
+		var profilePreview CommandPreview
+		if profile := config.ActiveProfile(); profile != nil {
+			args, err := profile.CommandLine()
+			profilePreview = PreviewCommandLine(profile.Engine, args, err)
+			profilePreview.Warnings = DoomArgsWarnings(profile.Engine, profile.Args)
+		}
+
 		c.HTML(http.StatusOK, "index.html", gin.H{
 			"CSRF":      csrf,
 			"Twitch":    broadcaster,
 			"TwitchBot": bot,
 			"Rcon":      rcon,
+			"RconPool":  rcons,
+			"Timeline":  timeline,
+			"Engine":    engine,
+			"Alerter":   alerter,
+			"Demos":     demos,
 			"IRCBot":    ircbot,
 			"Tab":       tab,
 			"Config":    config,
+			"Codecs":    s.Codecs(),
+
+			"DoomPreview":    doomPreview,
+			"ProfilePreview": profilePreview,
 		})
 	})
 
@@ -610,31 +1443,15 @@ func main() {
 			log.Printf("write asset failed: %s", err)
 			return
 		}
+		s.Forget(c.Param("name"))
 
 		c.JSON(http.StatusOK, map[string]bool{"ok": true})
 	})
 
-	if config.RconAutoStart {
-		go func() {
-			time.Sleep(2 * time.Second)
-
-			t := time.NewTicker(time.Second)
-			defer t.Stop()
-			for range t.C {
-				if rcon.IsOnline() {
-					continue
-				}
-
-				log.Println("Auto-connecting to rcon server...")
-				err := rcon.Connect(config.RconAddress, config.RconPassword)
-				if err != nil {
-					log.Printf("Error: %s", err)
-				} else {
-					log.Println("Auto-connect succeeded.")
-					time.Sleep(time.Second * 5)
-				}
-			}
-		}()
+	for _, target := range rcons.Clients() {
+		if t := config.GetRconTarget(target.Name); t != nil && t.AutoStart {
+			target.Supervise(t.Address, t.Password)
+		}
 	}
 
 	l, err := net.Listen("tcp", "localhost:8666")
@@ -0,0 +1,273 @@
+/**
+ * Copyright 2025 kmeaw
+ *
+ * Licensed under the GNU Affero General Public License (AGPL).
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU Affero General Public License as published by the
+ * Free Software Foundation, version 3 of the License.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or
+ * FITNESS FOR A PARTICULAR PURPOSE.  See the GNU Affero General Public License
+ * for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"log"
+	"math"
+	"sync"
+	"time"
+)
+
+const (
+	SOUND_ALERTS  = "alerts"
+	SOUND_TTS     = "tts"
+	SOUND_EFFECTS = "effects"
+
+	// The volume of a single voice, the master and the category volumes
+	// are applied by the mixer.
+	SOUND_VOLUME = 100
+
+	SOUND_MAX_VOICES = 16
+
+	// Other categories are turned down to this percentage while TTS is
+	// speaking.
+	SOUND_DUCKING = 30
+
+	// How long it takes to duck the other voices and to bring them back.
+	SOUND_DUCKING_RAMP = 200 * time.Millisecond
+
+	// Every voice fades in and out, so that starting or stopping it in the
+	// middle of a waveform does not pop.
+	SOUND_FADE = 10 * time.Millisecond
+)
+
+var SOUND_CATEGORIES = []string{SOUND_ALERTS, SOUND_TTS, SOUND_EFFECTS}
+
+// SoundSettings are the volumes in percents and the limits of the mixer.
+type SoundSettings struct {
+	Volume    int            `json:"volume"`
+	Volumes   map[string]int `json:"volumes"`
+	MaxVoices int            `json:"max_voices"`
+	Ducking   int            `json:"ducking"`
+	Normalize bool           `json:"normalize"`
+	CacheSize int            `json:"cache_size"`
+}
+
+type voice struct {
+	category string
+	samples  []int16
+	pos      int
+	end      int
+	gain     float64
+	stopping bool
+}
+
+// Mixer sums the voices into a single signed 16-bit little endian stream,
+// it is read by the audio device and outputs silence when nothing is playing.
+type Mixer struct {
+	channels int
+	step     float64
+	fade     int
+
+	voices    []*voice
+	master    float64
+	volumes   map[string]float64
+	maxVoices int
+	ducking   float64
+	duck      float64
+
+	mu sync.Mutex
+}
+
+func NewMixer(sampleRate, channels int) *Mixer {
+	m := &Mixer{
+		channels: channels,
+		step:     1 / (SOUND_DUCKING_RAMP.Seconds() * float64(sampleRate)),
+		fade:     int(SOUND_FADE.Seconds() * float64(sampleRate)),
+		duck:     1,
+	}
+	m.Configure(SoundSettings{
+		Volume:    SOUND_VOLUME,
+		MaxVoices: SOUND_MAX_VOICES,
+		Ducking:   SOUND_DUCKING,
+	})
+
+	return m
+}
+
+func percent(volume int) float64 {
+	v := 0.01 * float64(volume)
+	if v > 1.0 {
+		v = 1.0
+	} else if v < 0.0 {
+		v = 0.0
+	}
+	return v
+}
+
+// Configure sets the volumes, a category which is missing from the settings
+// plays at full volume.
+func (m *Mixer) Configure(settings SoundSettings) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.master = percent(settings.Volume)
+	m.volumes = make(map[string]float64)
+	for _, category := range SOUND_CATEGORIES {
+		m.volumes[category] = 1
+	}
+	for category, volume := range settings.Volumes {
+		m.volumes[category] = percent(volume)
+	}
+	m.maxVoices = settings.MaxVoices
+	if m.maxVoices <= 0 {
+		m.maxVoices = SOUND_MAX_VOICES
+	}
+	m.ducking = percent(settings.Ducking)
+}
+
+// Add starts a new voice playing the samples, when there are too many voices
+// the oldest one fades out.
+func (m *Mixer) Add(category string, samples []int16, volume int) error {
+	if len(samples)%m.channels != 0 {
+		return fmt.Errorf("the number of samples (%d) is not a multiple of the number of channels (%d)", len(samples), m.channels)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.volumes[category]; !ok {
+		return fmt.Errorf("unknown sound category: %q", category)
+	}
+
+	var playing []*voice
+	for _, v := range m.voices {
+		if !v.stopping {
+			playing = append(playing, v)
+		}
+	}
+	if n := len(playing) - m.maxVoices + 1; n > 0 {
+		log.Printf("too many voices, stopping %d oldest one(s)", n)
+		for _, v := range playing[0:n] {
+			v.stopping = true
+			if end := v.pos + m.fade*m.channels; end < v.end {
+				v.end = end
+			}
+		}
+	}
+
+	m.voices = append(m.voices, &voice{
+		category: category,
+		samples:  samples,
+		end:      len(samples),
+		gain:     percent(volume),
+	})
+
+	return nil
+}
+
+// envelope returns the fade in and out gain of the current frame of the voice.
+func (m *Mixer) envelope(v *voice) float64 {
+	env := 1.0
+	if frame := v.pos / m.channels; frame < m.fade {
+		env = float64(frame+1) / float64(m.fade)
+	}
+	if left := (v.end - v.pos) / m.channels; left < m.fade {
+		env = math.Min(env, float64(left)/float64(m.fade))
+	}
+
+	return env
+}
+
+// Voices returns the number of voices which are playing now, the ones which
+// are fading out after being stopped are not counted.
+func (m *Mixer) Voices() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	n := 0
+	for _, v := range m.voices {
+		if !v.stopping {
+			n++
+		}
+	}
+
+	return n
+}
+
+// Read implements io.Reader, it always fills p with whole frames.
+func (m *Mixer) Read(p []byte) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	frames := len(p) / (2 * m.channels)
+
+	speaking := false
+	for _, v := range m.voices {
+		if v.category == SOUND_TTS {
+			speaking = true
+			break
+		}
+	}
+
+	mix := make([]float64, m.channels)
+	for frame := 0; frame < frames; frame++ {
+		if speaking && m.duck > m.ducking {
+			m.duck = math.Max(m.duck-m.step, m.ducking)
+		} else if !speaking && m.duck < 1 {
+			m.duck = math.Min(m.duck+m.step, 1)
+		}
+
+		for i := range mix {
+			mix[i] = 0
+		}
+
+		for _, v := range m.voices {
+			if v.pos >= v.end {
+				continue
+			}
+
+			gain := v.gain * m.volumes[v.category] * m.envelope(v)
+			if v.category != SOUND_TTS {
+				gain *= m.duck
+			}
+			for i := range mix {
+				mix[i] += gain * float64(v.samples[v.pos+i])
+			}
+			v.pos += m.channels
+		}
+
+		for i, sample := range mix {
+			sample *= m.master
+			if sample > math.MaxInt16 {
+				sample = math.MaxInt16
+			} else if sample < math.MinInt16 {
+				sample = math.MinInt16
+			}
+			binary.LittleEndian.PutUint16(p[2*(frame*m.channels+i):], uint16(int16(sample)))
+		}
+	}
+
+	voices := m.voices[:0]
+	for _, v := range m.voices {
+		if v.pos < v.end {
+			voices = append(voices, v)
+		}
+	}
+	for i := len(voices); i < len(m.voices); i++ {
+		m.voices[i] = nil
+	}
+	m.voices = voices
+
+	return frames * 2 * m.channels, nil
+}
+
+// vim: ai:ts=8:sw=8:noet:syntax=go
@@ -0,0 +1,178 @@
+/**
+ * Copyright 2025 kmeaw
+ *
+ * Licensed under the GNU Affero General Public License (AGPL).
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU Affero General Public License as published by the
+ * Free Software Foundation, version 3 of the License.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or
+ * FITNESS FOR A PARTICULAR PURPOSE.  See the GNU Affero General Public License
+ * for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package main
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+func readMixer(t *testing.T, m *Mixer, frames int) []int16 {
+	t.Helper()
+
+	buf := make([]byte, frames*4+1)
+	n, err := m.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != frames*4 {
+		t.Fatalf("Read() = %d, want %d", n, frames*4)
+	}
+
+	samples := make([]int16, n/2)
+	for i := range samples {
+		samples[i] = int16(binary.LittleEndian.Uint16(buf[2*i:]))
+	}
+	return samples
+}
+
+func constant(value int16, frames int) []int16 {
+	samples := make([]int16, 2*frames)
+	for i := range samples {
+		samples[i] = value
+	}
+	return samples
+}
+
+// newMixer returns a mixer without the fades, so that the mixed samples can be
+// compared exactly.
+func newMixer() *Mixer {
+	m := NewMixer(48000, 2)
+	m.fade = 0
+	return m
+}
+
+func TestMixerVoices(t *testing.T) {
+	m := newMixer()
+
+	if samples := readMixer(t, m, 4); samples[0] != 0 || samples[7] != 0 {
+		t.Errorf("silence = %v", samples)
+	}
+
+	// the same sample twice at once
+	imp := constant(1000, 4)
+	m.Add(SOUND_EFFECTS, imp, SOUND_VOLUME)
+	m.Add(SOUND_EFFECTS, imp, 50)
+	if m.Voices() != 2 {
+		t.Errorf("Voices() = %d", m.Voices())
+	}
+	if samples := readMixer(t, m, 2); samples[0] != 1500 || samples[3] != 1500 {
+		t.Errorf("two voices = %v", samples)
+	}
+	m.Add(SOUND_EFFECTS, imp, SOUND_VOLUME)
+	if samples := readMixer(t, m, 4); samples[0] != 2500 || samples[3] != 2500 || samples[4] != 1000 || samples[7] != 1000 {
+		t.Errorf("three voices = %v", samples)
+	}
+	if m.Voices() != 0 {
+		t.Errorf("Voices() = %d after all voices have finished", m.Voices())
+	}
+
+	// clipping
+	m.Add(SOUND_EFFECTS, constant(30000, 1), SOUND_VOLUME)
+	m.Add(SOUND_EFFECTS, constant(30000, 1), SOUND_VOLUME)
+	if samples := readMixer(t, m, 1); samples[0] != 32767 {
+		t.Errorf("clipped = %v", samples)
+	}
+
+	if err := m.Add("music", imp, SOUND_VOLUME); err == nil {
+		t.Errorf("Add() with an unknown category has succeeded")
+	}
+	if err := m.Add(SOUND_EFFECTS, []int16{1, 2, 3}, SOUND_VOLUME); err == nil {
+		t.Errorf("Add() with a partial frame has succeeded")
+	}
+}
+
+func TestMixerSettings(t *testing.T) {
+	m := newMixer()
+	m.Configure(SoundSettings{
+		Volume:    50,
+		Volumes:   map[string]int{SOUND_ALERTS: 50, SOUND_EFFECTS: 0},
+		MaxVoices: 2,
+		Ducking:   0,
+	})
+
+	m.Add(SOUND_ALERTS, constant(1000, 1), SOUND_VOLUME)
+	m.Add(SOUND_EFFECTS, constant(1000, 1), SOUND_VOLUME)
+	if samples := readMixer(t, m, 1); samples[0] != 250 {
+		t.Errorf("mixed = %v, want 250", samples)
+	}
+
+	// the oldest voice is stopped
+	for i := 0; i < 3; i++ {
+		m.Add(SOUND_ALERTS, constant(1000, 1), SOUND_VOLUME)
+	}
+	if m.Voices() != 2 {
+		t.Errorf("Voices() = %d, want 2", m.Voices())
+	}
+	readMixer(t, m, 1)
+
+	// the alerts fade out while TTS is speaking and fade back in after
+	m.Configure(SoundSettings{Volume: 100, Ducking: 0})
+	alert := constant(1000, 48000)
+	m.Add(SOUND_ALERTS, alert, SOUND_VOLUME)
+	m.Add(SOUND_TTS, constant(0, 24000), SOUND_VOLUME)
+	if samples := readMixer(t, m, 24000); samples[0] != 999 || samples[2*24000-1] != 0 {
+		t.Errorf("ducking: first = %d, last = %d", samples[0], samples[2*24000-1])
+	}
+	if samples := readMixer(t, m, 24000); samples[0] != 0 || samples[2*24000-1] != 1000 {
+		t.Errorf("unducking: first = %d, last = %d", samples[0], samples[2*24000-1])
+	}
+}
+
+func TestMixerFade(t *testing.T) {
+	m := NewMixer(48000, 2)
+	fade := m.fade
+	if fade == 0 {
+		t.Fatal("the fade is not set")
+	}
+
+	m.Add(SOUND_EFFECTS, constant(1000, 4*fade), SOUND_VOLUME)
+	samples := readMixer(t, m, 4*fade)
+	if samples[0] == 0 || samples[0] > 1000/int16(fade)+1 {
+		t.Errorf("the first frame is %d, want a fade in", samples[0])
+	}
+	if samples[2*fade] != 1000 || samples[2*3*fade] != 1000 {
+		t.Errorf("the middle is %d..%d, want 1000", samples[2*fade], samples[2*3*fade])
+	}
+	for i := 2; i < len(samples); i += 2 {
+		if i < 2*2*fade && samples[i] < samples[i-2] || i >= 2*2*fade && samples[i] > samples[i-2] {
+			t.Fatalf("the envelope is not monotonic at frame %d: %d, %d", i/2, samples[i-2], samples[i])
+		}
+	}
+	if last := samples[len(samples)-1]; last > 1000/int16(fade)+1 {
+		t.Errorf("the last frame is %d, want a fade out", last)
+	}
+
+	// a stolen voice fades out instead of being cut
+	m.Configure(SoundSettings{Volume: 100, MaxVoices: 1})
+	m.Add(SOUND_EFFECTS, constant(1000, 10*fade), SOUND_VOLUME)
+	readMixer(t, m, 2*fade)
+	m.Add(SOUND_EFFECTS, constant(0, 10*fade), SOUND_VOLUME)
+	if m.Voices() != 1 {
+		t.Errorf("Voices() = %d, want 1", m.Voices())
+	}
+	samples = readMixer(t, m, 2*fade)
+	if samples[0] < 990 {
+		t.Errorf("the stolen voice has been cut: %d", samples[0])
+	}
+	if samples[2*(fade-1)] > 1000/int16(fade)+1 || samples[2*fade] != 0 {
+		t.Errorf("the stolen voice has not faded out: %d, %d", samples[2*(fade-1)], samples[2*fade])
+	}
+}
+
+// vim: ai:ts=8:sw=8:noet:syntax=go
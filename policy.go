@@ -0,0 +1,206 @@
+/**
+ * Copyright 2025 kmeaw
+ *
+ * Licensed under the GNU Affero General Public License (AGPL).
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU Affero General Public License as published by the
+ * Free Software Foundation, version 3 of the License.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or
+ * FITNESS FOR A PARTICULAR PURPOSE.  See the GNU Affero General Public License
+ * for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	RCON_POLICY_DENY  = "deny"
+	RCON_POLICY_ALLOW = "allow"
+	RCON_POLICY_OFF   = "off"
+)
+
+// DEFAULT_RCON_DENIED_VERBS are the console commands which let a viewer
+// stop the game, run arbitrary files, leak the password or hide other
+// commands behind an alias.
+var DEFAULT_RCON_DENIED_VERBS = []string{
+	"quit", "exit", "exec", "alias", "logfile",
+	"rcon_password", "sv_rconpassword",
+	"kick", "kickfromgame", "ban", "addban",
+}
+
+type RconPolicyViolation struct {
+	Command string
+	Verb    string
+	Reason  string
+}
+
+func (v *RconPolicyViolation) Error() string {
+	if v.Verb == "" {
+		return fmt.Sprintf("command %q is rejected: %s", v.Command, v.Reason)
+	}
+
+	return fmt.Sprintf("command %q is rejected: %s %q", v.Command, v.Reason, v.Verb)
+}
+
+// RconPolicy decides which console commands may be sent to the game.
+// In the deny mode the listed verbs are rejected, in the allow mode only
+// the listed verbs are accepted.
+type RconPolicy struct {
+	Mode  string
+	Verbs map[string]bool
+}
+
+func NewRconPolicy(mode string, verbs []string) (*RconPolicy, error) {
+	switch mode {
+	case RCON_POLICY_DENY, RCON_POLICY_ALLOW, RCON_POLICY_OFF:
+	default:
+		return nil, fmt.Errorf("unknown policy mode: %q", mode)
+	}
+
+	p := &RconPolicy{
+		Mode:  mode,
+		Verbs: make(map[string]bool),
+	}
+	for _, verb := range verbs {
+		verb = strings.ToLower(strings.TrimSpace(verb))
+		if verb != "" {
+			p.Verbs[verb] = true
+		}
+	}
+
+	return p, nil
+}
+
+// Check splits the line into separate console commands and checks the verb
+// of each one.
+func (p *RconPolicy) Check(line string) error {
+	if p == nil || p.Mode == RCON_POLICY_OFF {
+		return nil
+	}
+
+	for _, cmd := range SplitConsoleCommands(line) {
+		args, err := TokenizeConsoleCommand(cmd)
+		if err != nil {
+			return &RconPolicyViolation{Command: line, Reason: err.Error()}
+		}
+
+		if len(args) == 0 {
+			continue
+		}
+
+		verb := strings.ToLower(args[0])
+		if p.Mode == RCON_POLICY_DENY && p.Verbs[verb] {
+			return &RconPolicyViolation{Command: line, Verb: verb, Reason: "denied verb"}
+		}
+		if p.Mode == RCON_POLICY_ALLOW && !p.Verbs[verb] {
+			return &RconPolicyViolation{Command: line, Verb: verb, Reason: "verb is not allowed"}
+		}
+	}
+
+	return nil
+}
+
+// SplitConsoleCommands splits a console line the way ZDoom does: on
+// semicolons outside of quotes. Line breaks are treated as separators too.
+func SplitConsoleCommands(line string) []string {
+	var result []string
+
+	quoted := false
+	start := 0
+	for i := 0; i < len(line); i++ {
+		switch line[i] {
+		case '"':
+			quoted = !quoted
+		case '\\':
+			if quoted && i+1 < len(line) {
+				i++
+			}
+		case ';':
+			if quoted {
+				continue
+			}
+			fallthrough
+		case '\n', '\r':
+			result = append(result, line[start:i])
+			start = i + 1
+		}
+	}
+
+	return append(result, line[start:])
+}
+
+// TokenizeConsoleCommand splits a single console command into arguments.
+// Quoted arguments may contain spaces and \" or \\ escapes.
+func TokenizeConsoleCommand(cmd string) ([]string, error) {
+	var result []string
+
+	i := 0
+	for {
+		for i < len(cmd) && (cmd[i] == ' ' || cmd[i] == '\t') {
+			i++
+		}
+		if i == len(cmd) {
+			return result, nil
+		}
+
+		arg := &strings.Builder{}
+		if cmd[i] == '"' {
+			i++
+			for {
+				if i == len(cmd) {
+					return nil, fmt.Errorf("unterminated quote")
+				}
+
+				c := cmd[i]
+				i++
+				if c == '"' {
+					break
+				}
+				if c == '\\' && i < len(cmd) && (cmd[i] == '"' || cmd[i] == '\\') {
+					c = cmd[i]
+					i++
+				}
+				arg.WriteByte(c)
+			}
+		} else {
+			for i < len(cmd) && cmd[i] != ' ' && cmd[i] != '\t' {
+				arg.WriteByte(cmd[i])
+				i++
+			}
+		}
+
+		result = append(result, arg.String())
+	}
+}
+
+// QuoteConsoleArg makes a single quoted console argument out of an arbitrary
+// string, so that viewer text cannot break out of it.
+func QuoteConsoleArg(s string) string {
+	b := &strings.Builder{}
+	b.WriteByte('"')
+	for _, c := range s {
+		switch {
+		case c == '"' || c == '\\':
+			b.WriteByte('\\')
+			b.WriteRune(c)
+		case c < ' ' || c == 0x7f:
+			// control characters end the command in the console
+		default:
+			b.WriteRune(c)
+		}
+	}
+	b.WriteByte('"')
+
+	return b.String()
+}
+
+// vim: ai:ts=8:sw=8:noet:syntax=go
@@ -0,0 +1,112 @@
+/**
+ * Copyright 2025 kmeaw
+ *
+ * Licensed under the GNU Affero General Public License (AGPL).
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU Affero General Public License as published by the
+ * Free Software Foundation, version 3 of the License.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or
+ * FITNESS FOR A PARTICULAR PURPOSE.  See the GNU Affero General Public License
+ * for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestRconPolicyDeny(t *testing.T) {
+	p, err := NewRconPolicy(RCON_POLICY_DENY, DEFAULT_RCON_DENIED_VERBS)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		cmd string
+		ok  bool
+	}{
+		{"summon HereticImp", true},
+		{"echo x; quit", false},
+		{"echo \"x; quit\"", true},
+		{"echo \"x\\\"; quit\"", true},
+		{"echo \"x\\\\\"; quit", false},
+		{"echo x\nEXIT", false},
+		{"echo \"x", false},
+		{"  ;  ; give BFG9000", true},
+		{QuoteConsoleArg("x\\\"; quit \\"), true},
+	}
+
+	for _, test := range tests {
+		if err := p.Check(test.cmd); (err == nil) != test.ok {
+			t.Errorf("Check(%q) = %v, want ok=%v", test.cmd, err, test.ok)
+		}
+	}
+}
+
+func TestRconPolicyAllow(t *testing.T) {
+	p, err := NewRconPolicy(RCON_POLICY_ALLOW, []string{"summon", "Give"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := p.Check("summon Mummy; give Crossbow"); err != nil {
+		t.Errorf("Check: %s", err)
+	}
+	if err := p.Check("summon Mummy; map E1M1"); err == nil {
+		t.Errorf("map has been allowed")
+	}
+}
+
+func TestQuoteConsoleArg(t *testing.T) {
+	for _, s := range []string{"hello", "x; quit", "\"quoted\"", "back\\slash\\", "new\nline"} {
+		args, err := TokenizeConsoleCommand("echo " + QuoteConsoleArg(s))
+		if err != nil {
+			t.Errorf("cannot tokenize %q: %s", s, err)
+			continue
+		}
+
+		want := s
+		if s == "new\nline" {
+			want = "newline"
+		}
+		if !reflect.DeepEqual(args, []string{"echo", want}) {
+			t.Errorf("got %q for %q", args, s)
+		}
+	}
+}
+
+func TestRconPolicyConfigDoesNotAlterDefaults(t *testing.T) {
+	defaults := append([]string(nil), DEFAULT_RCON_DENIED_VERBS...)
+
+	c := &Config{zdrctConfigDir: t.TempDir()}
+	c.SetDefaults()
+	c.RconPolicyVerbs[0] = "summon"
+	if !reflect.DeepEqual(DEFAULT_RCON_DENIED_VERBS, defaults) {
+		t.Fatalf("SetDefaults() shares the default verbs: %q", DEFAULT_RCON_DENIED_VERBS)
+	}
+
+	data := []byte(`{"rcon_policy": "deny", "rcon_policy_verbs": ["give", "summon"]}`)
+	if err := os.WriteFile(filepath.Join(c.zdrctConfigDir, "config.json"), data, 0666); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.LoadConfig(); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"give", "summon"}; !reflect.DeepEqual(c.RconPolicyVerbs, want) {
+		t.Errorf("RconPolicyVerbs = %q, want %q", c.RconPolicyVerbs, want)
+	}
+	if !reflect.DeepEqual(DEFAULT_RCON_DENIED_VERBS, defaults) {
+		t.Errorf("LoadConfig() has overwritten the default verbs: %q", DEFAULT_RCON_DENIED_VERBS)
+	}
+}
+
+// vim: ai:ts=8:sw=8:noet:syntax=go
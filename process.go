@@ -0,0 +1,440 @@
+/**
+ * Copyright 2025 kmeaw
+ *
+ * Licensed under the GNU Affero General Public License (AGPL).
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU Affero General Public License as published by the
+ * Free Software Foundation, version 3 of the License.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or
+ * FITNESS FOR A PARTICULAR PURPOSE.  See the GNU Affero General Public License
+ * for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	ENGINE_LOG_LINES    = 1000
+	ENGINE_STOP_TIMEOUT = 5 * time.Second
+
+	// printed by rconserver when it starts listening
+	ENGINE_READY_LINE = "rconserver is ready."
+
+	// The output of the engine is not captured on Windows, so the ready
+	// probe is polled with an exponential backoff until the engine is ready
+	// or exits.
+	ENGINE_PROBE_DELAY     = 2 * time.Second
+	ENGINE_PROBE_MAX_DELAY = 15 * time.Second
+)
+
+// Launch stages shown in the UI.
+const (
+	ENGINE_STOPPED   = "stopped"
+	ENGINE_LAUNCHED  = "launched"
+	ENGINE_READY     = "ready"
+	ENGINE_CONNECTED = "connected"
+)
+
+// ProcessLog keeps the last lines written to it and copies everything to
+// zdrct's own stdout.
+type ProcessLog struct {
+	lines   []string
+	partial []byte
+	onLine  func(line string)
+	mu      sync.Mutex
+}
+
+func (l *ProcessLog) Write(p []byte) (int, error) {
+	os.Stdout.Write(p)
+
+	l.mu.Lock()
+	var lines []string
+	l.partial = append(l.partial, p...)
+	for {
+		idx := bytes.IndexByte(l.partial, '\n')
+		if idx == -1 {
+			break
+		}
+
+		lines = append(lines, string(bytes.TrimRight(l.partial[0:idx], "\r")))
+		l.partial = l.partial[idx+1:]
+	}
+
+	l.lines = append(l.lines, lines...)
+	if len(l.lines) > ENGINE_LOG_LINES {
+		l.lines = append([]string(nil), l.lines[len(l.lines)-ENGINE_LOG_LINES:]...)
+	}
+	onLine := l.onLine
+	l.mu.Unlock()
+
+	if onLine != nil {
+		for _, line := range lines {
+			onLine(line)
+		}
+	}
+
+	return len(p), nil
+}
+
+// OnLine sets a function which is called for every complete line.
+func (l *ProcessLog) OnLine(fn func(line string)) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.onLine = fn
+}
+
+// Lines returns up to n last lines, all of them if n <= 0.
+func (l *ProcessLog) Lines(n int) []string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	lines := l.lines
+	if len(l.partial) > 0 {
+		lines = append(lines[0:len(lines):len(lines)], string(l.partial))
+	}
+	if n > 0 && len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+
+	return append([]string(nil), lines...)
+}
+
+func (l *ProcessLog) Reset() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.lines = nil
+	l.partial = nil
+}
+
+type EngineStatus struct {
+	Profile   string    `json:"profile,omitempty"`
+	Path      string    `json:"path"`
+	Args      []string  `json:"args"`
+	Pid       int       `json:"pid,omitempty"`
+	Running   bool      `json:"running"`
+	Stage     string    `json:"stage"`
+	ExitCode  int       `json:"exit_code"`
+	Error     string    `json:"error,omitempty"`
+	StartedAt time.Time `json:"started_at"`
+	ExitedAt  time.Time `json:"exited_at"`
+}
+
+// EngineProcess supervises the engine launched by inject.
+type EngineProcess struct {
+	Log *ProcessLog
+
+	status   EngineStatus
+	baseArgs []string
+	proc     *os.Process
+	done     chan struct{}
+	onExit   []func(p *EngineProcess, status EngineStatus)
+	onReady  []func(p *EngineProcess)
+	prepare  []func(profile string, args []string) []string
+	abort    []func(args []string, err error)
+	probe    func(p *EngineProcess) error
+
+	probeDelay, probeMaxDelay time.Duration
+
+	mu sync.Mutex
+}
+
+func NewEngineProcess() *EngineProcess {
+	p := &EngineProcess{
+		Log:           &ProcessLog{},
+		probeDelay:    ENGINE_PROBE_DELAY,
+		probeMaxDelay: ENGINE_PROBE_MAX_DELAY,
+	}
+	p.status.Stage = ENGINE_STOPPED
+	p.Log.OnLine(p.watch)
+
+	return p
+}
+
+// OnReady registers a callback which is called when the engine reports that
+// its RCON server is ready or the ready probe succeeds. Callbacks are run in a
+// separate goroutine, so they may take their time.
+func (p *EngineProcess) OnReady(fn func(p *EngineProcess)) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.onReady = append(p.onReady, fn)
+}
+
+// SetReadyProbe sets a function which checks whether the RCON server of the
+// engine is up, it is used when the ready line does not show up in the log.
+func (p *EngineProcess) SetReadyProbe(fn func(p *EngineProcess) error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.probe = fn
+}
+
+func (p *EngineProcess) watch(line string) {
+	if !strings.Contains(line, ENGINE_READY_LINE) {
+		return
+	}
+
+	p.mu.Lock()
+	done := p.done
+	p.mu.Unlock()
+
+	p.markReady(done, "has reported that it is ready")
+}
+
+// markReady moves the launched engine to the ready stage, done identifies the
+// run of the engine, so that a late probe does not affect a restarted one.
+func (p *EngineProcess) markReady(done chan struct{}, reason string) {
+	p.mu.Lock()
+	if p.done != done || p.status.Stage != ENGINE_LAUNCHED {
+		p.mu.Unlock()
+		return
+	}
+	p.status.Stage = ENGINE_READY
+	pid := p.status.Pid
+	callbacks := p.onReady
+	p.mu.Unlock()
+
+	log.Printf("engine %d %s", pid, reason)
+	go func() {
+		for _, fn := range callbacks {
+			fn(p)
+		}
+	}()
+}
+
+// poll runs the ready probe until it succeeds or the engine exits.
+func (p *EngineProcess) poll(probe func(p *EngineProcess) error, done chan struct{}, delay, maxDelay time.Duration) {
+	for {
+		t := time.NewTimer(delay)
+		select {
+		case <-done:
+			t.Stop()
+			return
+		case <-t.C:
+		}
+
+		if p.Status().Stage != ENGINE_LAUNCHED {
+			return
+		}
+
+		if err := probe(p); err == nil {
+			p.markReady(done, "has answered the ready probe")
+			return
+		}
+
+		delay *= 2
+		if delay > maxDelay {
+			delay = maxDelay
+		}
+	}
+}
+
+// MarkConnected moves a ready engine to the connected stage.
+func (p *EngineProcess) MarkConnected() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.status.Running && p.status.Stage == ENGINE_READY {
+		p.status.Stage = ENGINE_CONNECTED
+	}
+}
+
+// BeforeStart registers a function which may change the arguments every
+// time the engine is started, restarts included. It is called with the lock
+// held and must not call other methods of the process.
+func (p *EngineProcess) BeforeStart(fn func(profile string, args []string) []string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.prepare = append(p.prepare, fn)
+}
+
+// OnStartError registers a function which is called with the prepared
+// arguments when the engine cannot be launched, so that the BeforeStart
+// functions can undo their work. It is called with the lock held too.
+func (p *EngineProcess) OnStartError(fn func(args []string, err error)) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.abort = append(p.abort, fn)
+}
+
+// OnExit registers a callback which is called every time the engine exits.
+func (p *EngineProcess) OnExit(fn func(p *EngineProcess, status EngineStatus)) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.onExit = append(p.onExit, fn)
+}
+
+func (p *EngineProcess) Status() EngineStatus {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	status := p.status
+	status.Args = append([]string(nil), status.Args...)
+
+	return status
+}
+
+func (p *EngineProcess) IsRunning() bool {
+	return p.Status().Running
+}
+
+// Start launches the engine. profile is the name of the launch profile, if
+// any, it is kept for the callbacks.
+func (p *EngineProcess) Start(profile, path string, args []string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.status.Running {
+		return fmt.Errorf("the engine is already running, pid %d", p.status.Pid)
+	}
+
+	baseArgs := args
+	for _, fn := range p.prepare {
+		args = fn(profile, args)
+	}
+
+	p.Log.Reset()
+	proc, wait, err := inject(path, p.Log, p.Log, args...)
+	if err != nil {
+		for _, fn := range p.abort {
+			fn(args, err)
+		}
+		return err
+	}
+
+	log.Printf("engine has been started, pid %d", proc.Pid)
+
+	p.proc = proc
+	p.baseArgs = baseArgs
+	p.done = make(chan struct{})
+	p.status = EngineStatus{
+		Profile:   profile,
+		Path:      path,
+		Args:      args,
+		Pid:       proc.Pid,
+		Running:   true,
+		Stage:     ENGINE_LAUNCHED,
+		StartedAt: time.Now(),
+	}
+
+	go p.wait(wait, p.done)
+	if p.probe != nil {
+		go p.poll(p.probe, p.done, p.probeDelay, p.probeMaxDelay)
+	}
+
+	return nil
+}
+
+func (p *EngineProcess) wait(wait func() (*os.ProcessState, error), done chan struct{}) {
+	state, err := wait()
+
+	p.mu.Lock()
+	p.status.Running = false
+	p.status.Stage = ENGINE_STOPPED
+	p.status.ExitedAt = time.Now()
+	p.status.ExitCode = -1
+	p.status.Error = ""
+	if state != nil {
+		p.status.ExitCode = state.ExitCode()
+	}
+	if err != nil {
+		p.status.Error = err.Error()
+	}
+	p.proc = nil
+	status := p.status
+	callbacks := p.onExit
+	p.mu.Unlock()
+
+	close(done)
+
+	log.Printf("engine %d has exited with code %d", status.Pid, status.ExitCode)
+	for _, fn := range callbacks {
+		fn(p, status)
+	}
+}
+
+// Stop asks the engine to quit and kills it if it is still running after
+// ENGINE_STOP_TIMEOUT.
+func (p *EngineProcess) Stop() error {
+	p.mu.Lock()
+	proc, done := p.proc, p.done
+	p.mu.Unlock()
+
+	if proc == nil {
+		return fmt.Errorf("the engine is not running")
+	}
+
+	if err := proc.Signal(syscall.SIGTERM); err != nil {
+		// not supported on Windows
+		return p.Kill()
+	}
+
+	select {
+	case <-done:
+		return nil
+	case <-time.After(ENGINE_STOP_TIMEOUT):
+		log.Printf("engine %d ignores SIGTERM, killing it", proc.Pid)
+		return p.Kill()
+	}
+}
+
+func (p *EngineProcess) Kill() error {
+	p.mu.Lock()
+	proc, done := p.proc, p.done
+	p.mu.Unlock()
+
+	if proc == nil {
+		return fmt.Errorf("the engine is not running")
+	}
+
+	if err := proc.Kill(); err != nil && !errors.Is(err, os.ErrProcessDone) {
+		return err
+	}
+
+	<-done
+	return nil
+}
+
+// Restart stops the engine if it is running and starts it again with the
+// same arguments, the BeforeStart functions are applied again.
+func (p *EngineProcess) Restart() error {
+	status := p.Status()
+	p.mu.Lock()
+	args := p.baseArgs
+	p.mu.Unlock()
+
+	if status.Path == "" {
+		return fmt.Errorf("the engine has never been started")
+	}
+
+	if status.Running {
+		if err := p.Stop(); err != nil {
+			return err
+		}
+	}
+
+	return p.Start(status.Profile, status.Path, args)
+}
+
+// vim: ai:ts=8:sw=8:noet:syntax=go
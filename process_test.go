@@ -0,0 +1,221 @@
+//go:build !windows
+// +build !windows
+
+/**
+ * Copyright 2025 kmeaw
+ *
+ * Licensed under the GNU Affero General Public License (AGPL).
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU Affero General Public License as published by the
+ * Free Software Foundation, version 3 of the License.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or
+ * FITNESS FOR A PARTICULAR PURPOSE.  See the GNU Affero General Public License
+ * for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"errors"
+	"reflect"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestEngineProcessExit(t *testing.T) {
+	p := NewEngineProcess()
+
+	exited := make(chan EngineStatus, 1)
+	p.OnExit(func(p *EngineProcess, status EngineStatus) {
+		exited <- status
+	})
+
+	if err := p.Start("", "/bin/sh", []string{"-c", "echo hello; echo world; exit 3"}); err != nil {
+		t.Fatalf("Start: %s", err)
+	}
+
+	select {
+	case status := <-exited:
+		if status.Running || status.ExitCode != 3 {
+			t.Errorf("status is %+v, want exit code 3", status)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the process to exit")
+	}
+
+	// LD_PRELOAD of a missing libinjector may add a warning on stderr
+	lines := p.Log.Lines(0)
+	if len(lines) < 2 || !reflect.DeepEqual(lines[len(lines)-2:], []string{"hello", "world"}) {
+		t.Errorf("log is %q", lines)
+	}
+}
+
+func TestEngineProcessStop(t *testing.T) {
+	p := NewEngineProcess()
+
+	if err := p.Start("", "/bin/sh", []string{"-c", "exec sleep 30"}); err != nil {
+		t.Fatalf("Start: %s", err)
+	}
+	if err := p.Start("", "/bin/sh", []string{"-c", "true"}); err == nil {
+		t.Error("the second Start has succeeded")
+	}
+
+	if err := p.Stop(); err != nil {
+		t.Fatalf("Stop: %s", err)
+	}
+	if p.IsRunning() {
+		t.Error("the process is still running")
+	}
+	if err := p.Stop(); err == nil {
+		t.Error("Stop of a stopped process has succeeded")
+	}
+}
+
+func TestEngineProcessBeforeStart(t *testing.T) {
+	p := NewEngineProcess()
+
+	n := 0
+	p.BeforeStart(func(profile string, args []string) []string {
+		n++
+		return append(args, profile, strconv.Itoa(n))
+	})
+
+	base := []string{"-c", "exec sleep 30", "sh"}
+	if err := p.Start("doom2", "/bin/sh", base); err != nil {
+		t.Fatalf("Start: %s", err)
+	}
+	defer p.Kill()
+
+	if args := p.Status().Args; !reflect.DeepEqual(args, []string{"-c", "exec sleep 30", "sh", "doom2", "1"}) {
+		t.Errorf("args are %q", args)
+	}
+
+	if err := p.Restart(); err != nil {
+		t.Fatalf("Restart: %s", err)
+	}
+
+	if args := p.Status().Args; !reflect.DeepEqual(args, []string{"-c", "exec sleep 30", "sh", "doom2", "2"}) {
+		t.Errorf("args after restart are %q", args)
+	}
+}
+
+func waitReady(t *testing.T, ready <-chan *EngineProcess) {
+	t.Helper()
+
+	select {
+	case <-ready:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the engine to get ready")
+	}
+}
+
+func TestEngineProcessStages(t *testing.T) {
+	p := NewEngineProcess()
+
+	ready := make(chan *EngineProcess, 1)
+	p.OnReady(func(p *EngineProcess) {
+		ready <- p
+	})
+
+	if err := p.Start("", "/bin/sh", []string{"-c", "sleep 0.3; echo rconserver is ready.; exec sleep 30"}); err != nil {
+		t.Fatalf("Start: %s", err)
+	}
+	defer p.Kill()
+
+	if stage := p.Status().Stage; stage != ENGINE_LAUNCHED {
+		t.Fatalf("stage is %q, want %q", stage, ENGINE_LAUNCHED)
+	}
+	p.MarkConnected()
+	if stage := p.Status().Stage; stage != ENGINE_LAUNCHED {
+		t.Errorf("a launched engine has been marked connected: %q", stage)
+	}
+
+	waitReady(t, ready)
+	if stage := p.Status().Stage; stage != ENGINE_READY {
+		t.Fatalf("stage is %q, want %q", stage, ENGINE_READY)
+	}
+
+	p.MarkConnected()
+	if stage := p.Status().Stage; stage != ENGINE_CONNECTED {
+		t.Errorf("stage is %q, want %q", stage, ENGINE_CONNECTED)
+	}
+
+	if err := p.Kill(); err != nil {
+		t.Fatalf("Kill: %s", err)
+	}
+	p.MarkConnected()
+	if stage := p.Status().Stage; stage != ENGINE_STOPPED {
+		t.Errorf("stage is %q after exit, want %q", stage, ENGINE_STOPPED)
+	}
+}
+
+func TestEngineProcessReadyProbe(t *testing.T) {
+	p := NewEngineProcess()
+	p.probeDelay = 10 * time.Millisecond
+	p.probeMaxDelay = 40 * time.Millisecond
+
+	var probes int32
+	p.SetReadyProbe(func(p *EngineProcess) error {
+		if atomic.AddInt32(&probes, 1) < 3 {
+			return errors.New("not yet")
+		}
+		return nil
+	})
+
+	ready := make(chan *EngineProcess, 1)
+	p.OnReady(func(p *EngineProcess) {
+		ready <- p
+	})
+
+	// the engine never prints the ready line, as on Windows
+	if err := p.Start("", "/bin/sh", []string{"-c", "exec sleep 30"}); err != nil {
+		t.Fatalf("Start: %s", err)
+	}
+	defer p.Kill()
+
+	waitReady(t, ready)
+	if stage := p.Status().Stage; stage != ENGINE_READY {
+		t.Errorf("stage is %q, want %q", stage, ENGINE_READY)
+	}
+	if n := atomic.LoadInt32(&probes); n != 3 {
+		t.Errorf("the probe has been called %d times, want 3", n)
+	}
+}
+
+func TestEngineProcessProbeStopsOnExit(t *testing.T) {
+	p := NewEngineProcess()
+	p.probeDelay = 10 * time.Millisecond
+	p.probeMaxDelay = 10 * time.Millisecond
+
+	var probes int32
+	p.SetReadyProbe(func(p *EngineProcess) error {
+		atomic.AddInt32(&probes, 1)
+		return errors.New("never")
+	})
+
+	if err := p.Start("", "/bin/sh", []string{"-c", "exec sleep 30"}); err != nil {
+		t.Fatalf("Start: %s", err)
+	}
+	waitFor(t, "the probe", func() bool {
+		return atomic.LoadInt32(&probes) > 0
+	})
+	if err := p.Kill(); err != nil {
+		t.Fatalf("Kill: %s", err)
+	}
+
+	n := atomic.LoadInt32(&probes)
+	time.Sleep(100 * time.Millisecond)
+	if m := atomic.LoadInt32(&probes); m > n+1 {
+		t.Errorf("the probe has been called %d times after the exit", m-n)
+	}
+}
+
+// vim: ai:ts=8:sw=8:noet:syntax=go
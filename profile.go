@@ -0,0 +1,107 @@
+/**
+ * Copyright 2025 kmeaw
+ *
+ * Licensed under the GNU Affero General Public License (AGPL).
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU Affero General Public License as published by the
+ * Free Software Foundation, version 3 of the License.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or
+ * FITNESS FOR A PARTICULAR PURPOSE.  See the GNU Affero General Public License
+ * for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+)
+
+const DEFAULT_SCRIPT_FILE = "script.anko"
+
+var (
+	profileName    = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+	scriptFileName = regexp.MustCompile(`^[A-Za-z0-9_-][A-Za-z0-9_.-]*\.anko$`)
+)
+
+func ValidateProfileName(name string) error {
+	if !profileName.MatchString(name) {
+		return fmt.Errorf("invalid profile name: %q", name)
+	}
+
+	return nil
+}
+
+func (p *LaunchProfile) Validate() error {
+	if p.Engine == "" {
+		return fmt.Errorf("engine path is empty")
+	}
+
+	if p.Script != "" && !scriptFileName.MatchString(p.Script) {
+		return fmt.Errorf("invalid script file name: %q, expected something like heretic.anko", p.Script)
+	}
+
+	if p.RconTarget != "" && p.RconTarget != DEFAULT_RCON_TARGET {
+		if err := ValidateRconTargetName(p.RconTarget); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// CommandLine returns the engine arguments: the IWAD, the PWADs in their
+// order and then the extra arguments with response files expanded.
+func (p *LaunchProfile) CommandLine() ([]string, error) {
+	var args []string
+
+	if p.Iwad != "" {
+		args = append(args, "-iwad", p.Iwad)
+	}
+
+	if len(p.Pwads) > 0 {
+		args = append(args, "-file")
+		args = append(args, p.Pwads...)
+	}
+
+	extra, err := ResolveDoomArgs(p.Engine, p.Args)
+	if err != nil {
+		return nil, err
+	}
+
+	return append(args, extra...), nil
+}
+
+// ScriptFile returns the name of the script file used by the profile.
+func (p *LaunchProfile) ScriptFile() string {
+	if p == nil || p.Script == "" {
+		return DEFAULT_SCRIPT_FILE
+	}
+
+	return p.Script
+}
+
+// ActiveProfile returns the selected launch profile or nil if none is
+// selected.
+func (c *Config) ActiveProfile() *LaunchProfile {
+	return c.Profiles[c.Profile]
+}
+
+// ProfileNames returns the names of all launch profiles in order.
+func (c *Config) ProfileNames() []string {
+	names := make([]string, 0, len(c.Profiles))
+	for name := range c.Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// vim: ai:ts=8:sw=8:noet:syntax=go
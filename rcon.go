@@ -30,18 +30,75 @@ import (
 	"time"
 )
 
+type RconState int
+
+const (
+	RCON_DISCONNECTED RconState = iota
+	RCON_CONNECTING
+	RCON_AUTHENTICATING
+	RCON_ONLINE
+	RCON_FAILED
+)
+
+func (s RconState) String() string {
+	switch s {
+	case RCON_DISCONNECTED:
+		return "disconnected"
+	case RCON_CONNECTING:
+		return "connecting"
+	case RCON_AUTHENTICATING:
+		return "authenticating"
+	case RCON_ONLINE:
+		return "online"
+	case RCON_FAILED:
+		return "failed"
+	}
+
+	return fmt.Sprintf("RconState(%d)", int(s))
+}
+
 type RconClient struct {
+	Name     string
 	Addr     *net.UDPAddr
 	Password string
 
+	// DeadPeerTimeout is how long an online connection may stay silent
+	// before the server is considered dead, zero disables the check.
+	// Zandronum sends updates only when something changes, so a quiet
+	// connection is probed after half of the timeout.
+	DeadPeerTimeout time.Duration
+
+	// DryRun makes Command pretend that every command has been
+	// delivered without sending anything.
+	DryRun bool
+
+	// Timeline records every command sent through Command.
+	Timeline *Timeline
+
+	// Policy rejects dangerous console commands in Command.
+	Policy *RconPolicy
+
 	c *net.UDPConn
 
+	// done is closed when the current connection is dropped, it stops the
+	// ponger of that connection.
+	done chan struct{}
+
 	messages <-chan string
 
 	Players                 []string
 	PlayerCount, AdminCount int
 	Map                     string
 
+	state         RconState
+	lastErr       error
+	lastSeen      time.Time
+	changed       chan struct{}
+	onStateChange []func(r *RconClient, old, new RconState)
+
+	supervisor chan struct{}
+	connecting *sync.Mutex
+
 	cv *sync.Cond
 	mu *sync.Mutex
 }
@@ -90,27 +147,43 @@ const (
 const PROTOCOL_VERSION = 4
 const PONG_INTERVAL = time.Second * 5
 
+const RECV_TIMEOUT = time.Second * 4
+const DEAD_PEER_TIMEOUT = PONG_INTERVAL * 6
+
+// The server answers a tab completion request even if nothing matches, so it
+// is used to check whether a quiet server is still alive.
+const DEAD_PEER_PROBE = "map"
+
+const RECONNECT_MIN_DELAY = time.Second
+const RECONNECT_MAX_DELAY = time.Second * 30
+
+var errSupervisionStopped = errors.New("supervision has been stopped")
+
 func NewRconClient() *RconClient {
 	r := &RconClient{}
+	r.DeadPeerTimeout = DEAD_PEER_TIMEOUT
 	r.mu = new(sync.Mutex)
 	r.cv = sync.NewCond(r.mu)
+	r.connecting = new(sync.Mutex)
+	r.changed = make(chan struct{})
 	r.Addr = &net.UDPAddr{
 		IP:   net.IP{127, 0, 0, 1},
 		Port: 10666,
 	}
-	go r.ponger(time.NewTicker(PONG_INTERVAL))
 
 	return r
 }
 
-func (r *RconClient) ponger(t *time.Ticker) {
-	for range t.C {
-		r.mu.Lock()
-		conn := r.c
-		r.mu.Unlock()
+// ponger keeps the connection alive until it is dropped.
+func (r *RconClient) ponger(conn *net.UDPConn, done <-chan struct{}) {
+	t := time.NewTicker(PONG_INTERVAL)
+	defer t.Stop()
 
-		if conn == nil {
-			continue
+	for {
+		select {
+		case <-done:
+			return
+		case <-t.C:
 		}
 
 		_, err := conn.Write([]byte{0xFF, byte(CLRC_PONG)})
@@ -125,17 +198,21 @@ func (r *RconClient) Recv() ([]byte, error) {
 	conn := r.c
 	r.mu.Unlock()
 
+	return r.recv(conn, RECV_TIMEOUT)
+}
+
+func (r *RconClient) recv(conn *net.UDPConn, wait time.Duration) ([]byte, error) {
 	if conn == nil {
 		return nil, net.ErrClosed
 	}
 
 	buf := make([]byte, 4096)
-	err := conn.SetReadDeadline(time.Now().Add(4 * time.Second))
+	err := conn.SetReadDeadline(time.Now().Add(wait))
 	if err != nil {
 		return nil, err
 	}
 
-	n, err := r.c.Read(buf)
+	n, err := conn.Read(buf)
 	if err != nil {
 		if errors.Is(err, os.ErrDeadlineExceeded) {
 			return nil, nil
@@ -148,31 +225,151 @@ func (r *RconClient) Recv() ([]byte, error) {
 		return nil, io.ErrUnexpectedEOF
 	}
 
-	return HuffmanDecode(buf[0:n]), nil
+	r.mu.Lock()
+	r.lastSeen = time.Now()
+	r.mu.Unlock()
+
+	pkt, err := HuffmanDecode(buf[0:n])
+	if err != nil || len(pkt) == 0 {
+		log.Printf("rcon %q: dropping a bad packet %x: %v", r.Name, buf[0:n], err)
+		return nil, nil
+	}
+
+	return pkt, nil
 }
 
 func (r *RconClient) Send(clrc CLRC, buf []byte) error {
-	_, err := r.c.Write(append([]byte{0xff, byte(clrc)}, buf...))
+	r.mu.Lock()
+	conn := r.c
+	r.mu.Unlock()
+
+	if conn == nil {
+		return net.ErrClosed
+	}
+
+	_, err := conn.Write(append([]byte{0xff, byte(clrc)}, buf...))
 	return err
 }
 
 func (r *RconClient) IsOnline() bool {
+	return r.State() == RCON_ONLINE
+}
+
+func (r *RconClient) State() RconState {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.state
+}
+
+// GameInfo returns the current map and the names of the players.
+func (r *RconClient) GameInfo() (string, []string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.Map, append([]string(nil), r.Players...)
+}
+
+// Err returns the error which has caused the last failure.
+func (r *RconClient) Err() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.lastErr
+}
+
+// OnStateChange registers a callback which is called after every state
+// transition.
+func (r *RconClient) OnStateChange(fn func(r *RconClient, old, new RconState)) {
 	r.mu.Lock()
 	defer r.mu.Unlock()
 
-	if r.c == nil {
-		return false
+	r.onStateChange = append(r.onStateChange, fn)
+}
+
+func (r *RconClient) setState(state RconState, err error) {
+	r.mu.Lock()
+	old := r.state
+	r.state = state
+	if err != nil || state == RCON_ONLINE {
+		r.lastErr = err
+	}
+	close(r.changed)
+	r.changed = make(chan struct{})
+	callbacks := r.onStateChange
+	r.mu.Unlock()
+
+	if old == state {
+		return
+	}
+
+	for _, fn := range callbacks {
+		fn(r, old, state)
+	}
+}
+
+// closeConn drops the current connection (if any) and moves into the
+// specified state. It does not stop the supervisor.
+func (r *RconClient) closeConn(state RconState, err error) error {
+	r.mu.Lock()
+	conn := r.c
+	r.c = nil
+	if r.done != nil {
+		close(r.done)
+		r.done = nil
+	}
+	r.mu.Unlock()
+
+	var cerr error
+	if conn != nil {
+		conn.Write([]byte{0xff, byte(CLRC_DISCONNECT)})
+		cerr = conn.Close()
 	}
 
-	return true
+	r.setState(state, err)
+
+	return cerr
 }
 
-func (r *RconClient) loop(messages chan<- string) {
+func (r *RconClient) loop(conn *net.UDPConn, messages chan<- string) {
+	defer close(messages)
+
 	for {
-		pkt, err := r.Recv()
+		r.mu.Lock()
+		timeout := r.DeadPeerTimeout
+		r.mu.Unlock()
+
+		wait := RECV_TIMEOUT
+		if timeout > 0 && timeout/4 < wait {
+			wait = timeout / 4
+		}
+
+		pkt, err := r.recv(conn, wait)
+
+		r.mu.Lock()
+		current := r.c == conn
+		silence := time.Since(r.lastSeen)
+		r.mu.Unlock()
+
+		if !current {
+			// closed on purpose or replaced by a new connection
+			return
+		}
+
+		if err == nil && pkt == nil && timeout > 0 {
+			if silence > timeout {
+				err = fmt.Errorf("no data from the server for %s", silence.Round(time.Second))
+			} else if silence > timeout/2 {
+				if _, werr := conn.Write(append([]byte{0xff, byte(CLRC_TABCOMPLETE)}, DEAD_PEER_PROBE...)); werr != nil {
+					log.Printf("rcon %q: cannot probe the server: %s", r.Name, werr)
+				}
+			}
+		}
+
 		if err != nil {
 			log.Printf("rcon error: %s", err)
-			break
+			r.closeConn(RCON_FAILED, err)
+			return
 		}
 
 		if pkt == nil {
@@ -181,7 +378,12 @@ func (r *RconClient) loop(messages chan<- string) {
 
 		switch SVRC(pkt[0]) {
 		case SVRC_MESSAGE:
-			messages <- string(pkt[1:])
+			msg := strings.TrimRight(string(pkt[1:]), "\000")
+			select {
+			case messages <- msg:
+			default:
+				// nobody reads the console output
+			}
 
 		case SVRC_UPDATE:
 			r.mu.Lock()
@@ -194,13 +396,17 @@ func (r *RconClient) loop(messages chan<- string) {
 			switch SVRCU(pkt[1]) {
 			case SVRCU_PLAYERDATA:
 				r.PlayerCount = int(pkt[2])
-				r.Players = strings.Split(string(pkt[3:]), "\000")
+				r.Players = nil
+				if r.PlayerCount > 0 {
+					players := strings.TrimSuffix(string(pkt[3:]), "\000")
+					r.Players = strings.Split(players, "\000")
+				}
 
 			case SVRCU_ADMINCOUNT:
 				r.AdminCount = int(pkt[2])
 
 			case SVRCU_MAP:
-				r.Map = string(pkt[2:])
+				r.Map = strings.TrimRight(string(pkt[2:]), "\000")
 
 			default:
 				log.Printf("unexpected svrcu: %x", pkt[1:])
@@ -218,37 +424,113 @@ func (r *RconClient) loop(messages chan<- string) {
 			log.Printf("unexpected pkt: %x", pkt)
 		}
 	}
-
-	close(messages)
 }
 
 func (r *RconClient) Command(cmd string) error {
-	return r.Send(CLRC_COMMAND, []byte(cmd))
+	return r.CommandFrom("", cmd)
 }
 
-func (r *RconClient) Connect(hostport, password string) (err error) {
+// CommandFrom is Command on behalf of a viewer, the name is kept in the
+// timeline.
+func (r *RconClient) CommandFrom(from, cmd string) error {
 	r.mu.Lock()
-	prev_addr := r.Addr
-	prev_password := r.Password
-	r.Password = password
-	r.Addr, err = net.ResolveUDPAddr("udp", hostport)
+	dryRun := r.DryRun
+	timeline := r.Timeline
+	policy := r.Policy
 	r.mu.Unlock()
 
+	if err := policy.Check(cmd); err != nil {
+		log.Printf("rcon %q: policy violation: %s", r.Name, err)
+		if timeline != nil {
+			timeline.Add(TimelineEntry{
+				Kind:   "policy",
+				Target: r.Name,
+				From:   from,
+				Text:   cmd,
+				DryRun: dryRun,
+			})
+		}
+		return err
+	}
+
+	if dryRun {
+		log.Printf("rcon %q (dry run): %q", r.Name, cmd)
+	} else if err := r.Send(CLRC_COMMAND, []byte(cmd)); err != nil {
+		return err
+	}
+
+	if timeline != nil {
+		timeline.Add(TimelineEntry{
+			Kind:   "rcon",
+			Target: r.Name,
+			From:   from,
+			Text:   cmd,
+			DryRun: dryRun,
+		})
+	}
+
+	return nil
+}
+
+// CanSend reports whether Command is expected to succeed: the client is
+// either online or in the dry-run mode.
+func (r *RconClient) CanSend() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.state == RCON_ONLINE || r.DryRun
+}
+
+// Connect makes a single attempt to connect and log in to the server.
+func (r *RconClient) Connect(hostport, password string) error {
+	return r.connect(nil, hostport, password)
+}
+
+// connect gives up without touching the connection if stop is closed, so a
+// supervisor which has been stopped does not reconnect behind Close.
+func (r *RconClient) connect(stop <-chan struct{}, hostport, password string) (err error) {
+	r.connecting.Lock()
+	defer r.connecting.Unlock()
+
+	select {
+	case <-stop:
+		return errSupervisionStopped
+	default:
+	}
+
+	addr, err := net.ResolveUDPAddr("udp", hostport)
 	if err != nil {
-		r.mu.Lock()
-		r.Addr = prev_addr
-		r.Password = prev_password
-		r.mu.Unlock()
 		return
 	}
 
+	r.closeConn(RCON_CONNECTING, nil)
+
 	r.mu.Lock()
-	r.c, err = net.DialUDP("udp", nil, r.Addr)
+	r.Addr = addr
+	r.Password = password
 	r.mu.Unlock()
+
+	conn, err := net.DialUDP("udp", nil, addr)
 	if err != nil {
+		r.setState(RCON_FAILED, err)
 		return
 	}
 
+	done := make(chan struct{})
+	r.mu.Lock()
+	r.c = conn
+	r.done = done
+	r.lastSeen = time.Now()
+	r.mu.Unlock()
+
+	go r.ponger(conn, done)
+
+	defer func() {
+		if err != nil {
+			r.closeConn(RCON_FAILED, err)
+		}
+	}()
+
 	err = r.Send(CLRC_BEGINCONNECTION, []byte{PROTOCOL_VERSION})
 	if err != nil {
 		return
@@ -263,21 +545,22 @@ func (r *RconClient) Connect(hostport, password string) (err error) {
 
 		if pkt == nil {
 			err = fmt.Errorf("timed out")
-			r.Close()
 			return
 		}
 
 		switch SVRC(pkt[0]) {
 		case SVRC_LOGGEDIN:
 			messages := make(chan string, 16)
-			go r.loop(messages)
 			r.mu.Lock()
 			r.messages = messages
 			r.mu.Unlock()
+			go r.loop(conn, messages)
+			r.setState(RCON_ONLINE, nil)
 
 			return
 
 		case SVRC_SALT:
+			r.setState(RCON_AUTHENTICATING, nil)
 			h := md5.New()
 			h.Write(pkt[1:33])
 			h.Write([]byte(password))
@@ -306,17 +589,135 @@ func (r *RconClient) Connect(hostport, password string) (err error) {
 	}
 }
 
-func (r *RconClient) Close() error {
+// ProbeRcon checks whether an RCON server answers at hostport without logging
+// in: the connection is started and dropped as soon as the salt arrives.
+func ProbeRcon(hostport string, timeout time.Duration) error {
+	addr, err := net.ResolveUDPAddr("udp", hostport)
+	if err != nil {
+		return err
+	}
+
+	conn, err := net.DialUDP("udp", nil, addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if err := conn.SetDeadline(time.Now().Add(timeout)); err != nil {
+		return err
+	}
+
+	if _, err := conn.Write([]byte{0xff, byte(CLRC_BEGINCONNECTION), PROTOCOL_VERSION}); err != nil {
+		return err
+	}
+
+	buf := make([]byte, 4096)
+	if _, err := conn.Read(buf); err != nil {
+		return err
+	}
+
+	conn.Write([]byte{0xff, byte(CLRC_DISCONNECT)})
+
+	return nil
+}
+
+// Supervise keeps the client connected to the server: whenever the
+// connection fails, it is re-established with an exponential backoff.
+// Supervision lasts until Unsupervise or Close is called.
+func (r *RconClient) Supervise(hostport, password string) {
 	r.mu.Lock()
 	defer r.mu.Unlock()
 
-	if r.c == nil {
-		return nil
+	if r.supervisor != nil {
+		close(r.supervisor)
 	}
+	stop := make(chan struct{})
+	r.supervisor = stop
 
-	r.Send(CLRC_DISCONNECT, nil)
-	err := r.c.Close()
-	r.c = nil
+	go r.supervise(stop, hostport, password)
+}
+
+func (r *RconClient) Unsupervise() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.supervisor != nil {
+		close(r.supervisor)
+		r.supervisor = nil
+	}
+}
+
+func (r *RconClient) supervise(stop <-chan struct{}, hostport, password string) {
+	delay := RECONNECT_MIN_DELAY
+
+	for {
+		r.mu.Lock()
+		state := r.state
+		changed := r.changed
+		r.mu.Unlock()
+
+		if state == RCON_ONLINE {
+			delay = RECONNECT_MIN_DELAY
+			select {
+			case <-stop:
+				return
+			case <-changed:
+				continue
+			}
+		}
+
+		select {
+		case <-stop:
+			return
+		default:
+		}
+
+		log.Printf("rcon %q: connecting to %s...", r.Name, hostport)
+		err := r.connect(stop, hostport, password)
+		if err == errSupervisionStopped {
+			return
+		}
+		if err == nil {
+			continue
+		}
+
+		log.Printf("rcon %q: %s, retrying in %s", r.Name, err, delay)
+		t := time.NewTimer(delay)
+		select {
+		case <-stop:
+			t.Stop()
+			return
+		case <-t.C:
+		}
+
+		delay = nextReconnectDelay(delay)
+	}
+}
+
+// nextReconnectDelay doubles the delay up to RECONNECT_MAX_DELAY.
+func nextReconnectDelay(delay time.Duration) time.Duration {
+	delay *= 2
+	if delay > RECONNECT_MAX_DELAY {
+		delay = RECONNECT_MAX_DELAY
+	}
+
+	return delay
+}
+
+// Close stops the supervision and drops the connection. A connection attempt
+// which is in progress is interrupted and waited for, so nothing stays
+// connected after Close returns.
+func (r *RconClient) Close() error {
+	r.Unsupervise()
+
+	err := r.closeConn(RCON_DISCONNECTED, nil)
+
+	r.connecting.Lock()
+	defer r.connecting.Unlock()
+
+	if cerr := r.closeConn(RCON_DISCONNECTED, nil); err == nil {
+		err = cerr
+	}
 
 	return err
 }
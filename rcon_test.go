@@ -0,0 +1,270 @@
+/**
+ * Copyright 2025 kmeaw
+ *
+ * Licensed under the GNU Affero General Public License (AGPL).
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU Affero General Public License as published by the
+ * Free Software Foundation, version 3 of the License.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or
+ * FITNESS FOR A PARTICULAR PURPOSE.  See the GNU Affero General Public License
+ * for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package main
+
+import (
+	"net"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func startFakeServer(t *testing.T, password string) *FakeRconServer {
+	t.Helper()
+
+	s, err := NewFakeRconServer("127.0.0.1:0", password)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Serve()
+	t.Cleanup(func() { s.Close() })
+
+	return s
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestRconLogin(t *testing.T) {
+	s := startFakeServer(t, "secret")
+	s.SetMap("E1M1")
+	s.SetPlayers("doomguy", "corvus")
+	s.SetReply("summon", "Summoned!")
+
+	r := NewRconClient()
+	defer r.Close()
+
+	if err := r.Connect(s.Addr(), "secret"); err != nil {
+		t.Fatalf("Connect: %s", err)
+	}
+	if r.State() != RCON_ONLINE {
+		t.Fatalf("state is %s, want online", r.State())
+	}
+
+	waitFor(t, "the map update", func() bool {
+		var m string
+		r.Locked(func(r *RconClient) error {
+			m = r.Map
+			return nil
+		})
+		return m == "E1M1"
+	})
+
+	r.Locked(func(r *RconClient) error {
+		if want := []string{"doomguy", "corvus"}; !reflect.DeepEqual(r.Players, want) {
+			t.Errorf("players are %q, want %q", r.Players, want)
+		}
+		return nil
+	})
+
+	if err := r.Command("summon HereticImp"); err != nil {
+		t.Fatalf("Command: %s", err)
+	}
+
+	select {
+	case msg := <-r.Messages():
+		if msg != "Summoned!" {
+			t.Errorf("got %q, want %q", msg, "Summoned!")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for a reply")
+	}
+
+	if cmds := s.Commands(); !reflect.DeepEqual(cmds, []string{"summon HereticImp"}) {
+		t.Errorf("server has received %q", cmds)
+	}
+}
+
+func TestRconInvalidPassword(t *testing.T) {
+	s := startFakeServer(t, "secret")
+
+	r := NewRconClient()
+	defer r.Close()
+
+	if err := r.Connect(s.Addr(), "wrong"); err == nil {
+		t.Fatal("Connect has succeeded with a wrong password")
+	}
+	if r.State() != RCON_FAILED {
+		t.Errorf("state is %s, want failed", r.State())
+	}
+}
+
+func TestRconStateChanges(t *testing.T) {
+	s := startFakeServer(t, "")
+
+	r := NewRconClient()
+	defer r.Close()
+
+	states := make(chan RconState, 16)
+	r.OnStateChange(func(r *RconClient, old, new RconState) {
+		states <- new
+	})
+
+	if err := r.Connect(s.Addr(), ""); err != nil {
+		t.Fatalf("Connect: %s", err)
+	}
+	r.Close()
+
+	want := []RconState{RCON_CONNECTING, RCON_AUTHENTICATING, RCON_ONLINE, RCON_DISCONNECTED}
+	for _, w := range want {
+		select {
+		case got := <-states:
+			if got != w {
+				t.Fatalf("got state %s, want %s", got, w)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("timed out waiting for %s", w)
+		}
+	}
+}
+
+func TestRconReconnectDelay(t *testing.T) {
+	delay := RECONNECT_MIN_DELAY
+	var got []time.Duration
+	for i := 0; i < 7; i++ {
+		got = append(got, delay)
+		delay = nextReconnectDelay(delay)
+	}
+
+	want := []time.Duration{1, 2, 4, 8, 16, 30, 30}
+	for i := range want {
+		want[i] *= time.Second
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("delays are %v, want %v", got, want)
+	}
+}
+
+func TestRconDeadPeer(t *testing.T) {
+	s := startFakeServer(t, "")
+
+	r := NewRconClient()
+	defer r.Close()
+
+	if r.DeadPeerTimeout != DEAD_PEER_TIMEOUT {
+		t.Errorf("the default dead peer timeout is %s, want %s", r.DeadPeerTimeout, DEAD_PEER_TIMEOUT)
+	}
+	r.DeadPeerTimeout = 400 * time.Millisecond
+
+	if err := r.Connect(s.Addr(), ""); err != nil {
+		t.Fatalf("Connect: %s", err)
+	}
+
+	// the server has nothing to say, but answers the probes
+	time.Sleep(3 * r.DeadPeerTimeout)
+	if r.State() != RCON_ONLINE {
+		t.Fatalf("a quiet server has been dropped: %s (%v)", r.State(), r.Err())
+	}
+
+	s.SetMuted(true)
+	waitFor(t, "the dead peer detection", func() bool {
+		return r.State() == RCON_FAILED
+	})
+	if r.Err() == nil {
+		t.Error("no error for the dead peer")
+	}
+}
+
+func freeUDPAddr(t *testing.T) string {
+	t.Helper()
+
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IP{127, 0, 0, 1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	return conn.LocalAddr().String()
+}
+
+func TestRconSupervise(t *testing.T) {
+	addr := freeUDPAddr(t)
+
+	r := NewRconClient()
+	defer r.Close()
+
+	r.Supervise(addr, "")
+	waitFor(t, "the first attempt to fail", func() bool {
+		return r.State() == RCON_FAILED
+	})
+
+	s, err := NewFakeRconServer(addr, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Serve()
+	defer s.Close()
+
+	waitFor(t, "the reconnection", r.IsOnline)
+
+	if err := r.Close(); err != nil {
+		t.Fatalf("Close: %s", err)
+	}
+	time.Sleep(2 * RECONNECT_MIN_DELAY)
+	if r.State() != RCON_DISCONNECTED {
+		t.Errorf("state is %s after Close, want disconnected", r.State())
+	}
+}
+
+func TestRconCloseWhileConnecting(t *testing.T) {
+	s := startFakeServer(t, "")
+	s.SetMuted(true)
+
+	r := NewRconClient()
+	r.Supervise(s.Addr(), "")
+	waitFor(t, "the connection attempt", func() bool {
+		return r.State() == RCON_CONNECTING
+	})
+
+	start := time.Now()
+	r.Close()
+	if elapsed := time.Since(start); elapsed > RECV_TIMEOUT/2 {
+		t.Errorf("Close has waited for %s", elapsed)
+	}
+
+	s.SetMuted(false)
+	time.Sleep(2 * RECONNECT_MIN_DELAY)
+	if r.State() != RCON_DISCONNECTED {
+		t.Errorf("state is %s after Close, want disconnected", r.State())
+	}
+}
+
+func TestProbeRcon(t *testing.T) {
+	s := startFakeServer(t, "secret")
+
+	if err := ProbeRcon(s.Addr(), time.Second); err != nil {
+		t.Errorf("ProbeRcon: %s", err)
+	}
+	if err := ProbeRcon(freeUDPAddr(t), time.Second); err == nil {
+		t.Error("ProbeRcon has succeeded without a server")
+	}
+	if cmds := s.Commands(); len(cmds) != 0 {
+		t.Errorf("the probe has sent %q", cmds)
+	}
+}
+
+// vim: ai:ts=8:sw=8:noet:syntax=go
@@ -0,0 +1,211 @@
+/**
+ * Copyright 2025 kmeaw
+ *
+ * Licensed under the GNU Affero General Public License (AGPL).
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU Affero General Public License as published by the
+ * Free Software Foundation, version 3 of the License.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or
+ * FITNESS FOR A PARTICULAR PURPOSE.  See the GNU Affero General Public License
+ * for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+)
+
+const DEFAULT_RCON_TARGET = "default"
+
+var rconTargetName = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// RconPool holds a set of named RCON clients, one per game server.
+// The default target always exists and cannot be removed.
+type RconPool struct {
+	clients map[string]*RconClient
+
+	onStateChange   []func(r *RconClient, old, new RconState)
+	deadPeerTimeout time.Duration
+	dryRun          bool
+	timeline        *Timeline
+	policy          *RconPolicy
+
+	mu sync.Mutex
+}
+
+func NewRconPool() *RconPool {
+	p := &RconPool{
+		clients:         make(map[string]*RconClient),
+		deadPeerTimeout: DEAD_PEER_TIMEOUT,
+	}
+	p.Add(DEFAULT_RCON_TARGET)
+
+	return p
+}
+
+func ValidateRconTargetName(name string) error {
+	if !rconTargetName.MatchString(name) {
+		return fmt.Errorf("invalid target name: %q", name)
+	}
+
+	return nil
+}
+
+// Get returns the named client or nil if there is no such target.
+// An empty name refers to the default target.
+func (p *RconPool) Get(name string) *RconClient {
+	if name == "" {
+		name = DEFAULT_RCON_TARGET
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.clients[name]
+}
+
+// Add returns the named client, creating it if necessary.
+func (p *RconPool) Add(name string) *RconClient {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	r, ok := p.clients[name]
+	if !ok {
+		r = NewRconClient()
+		r.Name = name
+		r.DeadPeerTimeout = p.deadPeerTimeout
+		r.DryRun = p.dryRun
+		r.Timeline = p.timeline
+		r.Policy = p.policy
+		for _, fn := range p.onStateChange {
+			r.OnStateChange(fn)
+		}
+		p.clients[name] = r
+	}
+
+	return r
+}
+
+// OnStateChange registers a state change callback for every target,
+// including the ones which are added later.
+func (p *RconPool) OnStateChange(fn func(r *RconClient, old, new RconState)) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.onStateChange = append(p.onStateChange, fn)
+	for _, r := range p.clients {
+		r.OnStateChange(fn)
+	}
+}
+
+func (p *RconPool) SetDeadPeerTimeout(timeout time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.deadPeerTimeout = timeout
+	for _, r := range p.clients {
+		r.Locked(func(r *RconClient) error {
+			r.DeadPeerTimeout = timeout
+			return nil
+		})
+	}
+}
+
+func (p *RconPool) SetDryRun(dryRun bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.dryRun = dryRun
+	for _, r := range p.clients {
+		r.Locked(func(r *RconClient) error {
+			r.DryRun = dryRun
+			return nil
+		})
+	}
+}
+
+func (p *RconPool) SetTimeline(timeline *Timeline) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.timeline = timeline
+	for _, r := range p.clients {
+		r.Locked(func(r *RconClient) error {
+			r.Timeline = timeline
+			return nil
+		})
+	}
+}
+
+func (p *RconPool) SetPolicy(policy *RconPolicy) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.policy = policy
+	for _, r := range p.clients {
+		r.Locked(func(r *RconClient) error {
+			r.Policy = policy
+			return nil
+		})
+	}
+}
+
+// IsDryRun reports whether the pool is in the dry-run mode.
+func (p *RconPool) IsDryRun() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.dryRun
+}
+
+func (p *RconPool) Remove(name string) error {
+	if name == DEFAULT_RCON_TARGET {
+		return fmt.Errorf("cannot remove the default target")
+	}
+
+	p.mu.Lock()
+	r, ok := p.clients[name]
+	delete(p.clients, name)
+	p.mu.Unlock()
+
+	if !ok {
+		return fmt.Errorf("no such target: %q", name)
+	}
+
+	return r.Close()
+}
+
+// Clients returns all clients ordered by name, the default one goes first.
+func (p *RconPool) Clients() []*RconClient {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	result := make([]*RconClient, 0, len(p.clients))
+	for _, r := range p.clients {
+		result = append(result, r)
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Name == DEFAULT_RCON_TARGET {
+			return result[j].Name != DEFAULT_RCON_TARGET
+		}
+		if result[j].Name == DEFAULT_RCON_TARGET {
+			return false
+		}
+		return result[i].Name < result[j].Name
+	})
+
+	return result
+}
+
+// vim: ai:ts=8:sw=8:noet:syntax=go
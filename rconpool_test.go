@@ -0,0 +1,107 @@
+/**
+ * Copyright 2025 kmeaw
+ *
+ * Licensed under the GNU Affero General Public License (AGPL).
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU Affero General Public License as published by the
+ * Free Software Foundation, version 3 of the License.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or
+ * FITNESS FOR A PARTICULAR PURPOSE.  See the GNU Affero General Public License
+ * for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func poolNames(p *RconPool) []string {
+	var names []string
+	for _, r := range p.Clients() {
+		names = append(names, r.Name)
+	}
+	return names
+}
+
+func TestRconPool(t *testing.T) {
+	p := NewRconPool()
+
+	if r := p.Get(""); r == nil || r.Name != DEFAULT_RCON_TARGET {
+		t.Fatalf("Get(\"\") = %v, want the default target", r)
+	}
+	if r := p.Get("coop"); r != nil {
+		t.Fatalf("Get(%q) = %v, want nil", "coop", r)
+	}
+
+	p.SetDryRun(true)
+	coop := p.Add("coop")
+	if again := p.Add("coop"); again != coop {
+		t.Error("Add has replaced an existing target")
+	}
+	if p.Get("coop") != coop {
+		t.Error("Get has not returned the added target")
+	}
+	if !coop.CanSend() {
+		t.Error("a new target has not inherited the dry-run mode")
+	}
+
+	p.Add("alpha")
+	if names, want := poolNames(p), []string{DEFAULT_RCON_TARGET, "alpha", "coop"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("Clients() = %q, want %q", names, want)
+	}
+
+	if err := p.Remove(DEFAULT_RCON_TARGET); err == nil {
+		t.Error("the default target has been removed")
+	}
+	if err := p.Remove("nope"); err == nil {
+		t.Error("a missing target has been removed")
+	}
+	if err := p.Remove("coop"); err != nil {
+		t.Fatalf("Remove: %s", err)
+	}
+	if p.Get("coop") != nil {
+		t.Error("the removed target is still there")
+	}
+	if names, want := poolNames(p), []string{DEFAULT_RCON_TARGET, "alpha"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("Clients() = %q, want %q", names, want)
+	}
+}
+
+func TestRconPoolRemoveStopsPonger(t *testing.T) {
+	s := startFakeServer(t, "")
+
+	p := NewRconPool()
+	r := p.Add("coop")
+	if err := r.Connect(s.Addr(), ""); err != nil {
+		t.Fatalf("Connect: %s", err)
+	}
+
+	r.mu.Lock()
+	done := r.done
+	r.mu.Unlock()
+	if done == nil {
+		t.Fatal("the ponger has not been started")
+	}
+
+	if err := p.Remove("coop"); err != nil {
+		t.Fatalf("Remove: %s", err)
+	}
+
+	select {
+	case <-done:
+	default:
+		t.Fatal("the ponger has not been stopped")
+	}
+	if r.State() != RCON_DISCONNECTED {
+		t.Errorf("state is %s, want disconnected", r.State())
+	}
+}
+
+// vim: ai:ts=8:sw=8:noet:syntax=go
@@ -42,6 +42,9 @@ type Remote struct {
 	IRCBot      *IRCBot
 	Config      *RemoteEvent
 
+	// Timeline records the redemptions.
+	Timeline *Timeline
+
 	ImageCache map[string]string
 
 	conn             *websocket.Conn
@@ -162,6 +165,10 @@ func (r *Remote) readLoop() error {
 
 		log.Printf("ws: got event: %#v", event)
 
+		if event.IsReward {
+			r.logRedemption(event.Origin, event.Command)
+		}
+
 		err = bot.ProcessMessage(
 			context.WithValue(context.Background(), "is_reward", event.IsReward),
 			event.Origin,
@@ -397,6 +404,8 @@ func (r *Remote) readEventSub() error {
 			reward.SetClient(r.Broadcaster)
 			r.mu.Unlock()
 
+			r.logRedemption(redemption.UserName, redemption.RewardInfo.Title)
+
 			cmd, ok := m[redemption.RewardInfo.ID]
 			if ok {
 				log.Printf(
@@ -431,6 +440,29 @@ func (r *Remote) readEventSub() error {
 	}
 }
 
+func (r *Remote) SetTimeline(timeline *Timeline) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.Timeline = timeline
+}
+
+func (r *Remote) logRedemption(from, title string) {
+	r.mu.Lock()
+	timeline := r.Timeline
+	r.mu.Unlock()
+
+	if timeline == nil {
+		return
+	}
+
+	timeline.Add(TimelineEntry{
+		Kind: "redemption",
+		From: from,
+		Text: title,
+	})
+}
+
 func (r *Remote) connect() {
 	t := time.NewTicker(5 * time.Second)
 	defer t.Stop()
@@ -490,7 +522,7 @@ func (r *Remote) sendConfig() error {
 			continue
 		}
 
-		asset, ok := Assets[button.Image]
+		asset, ok := LookupAsset(button.Image)
 		if !ok {
 			log.Printf("error loading button %q: no such asset: %q", button.Cmd, button.Image)
 			continue
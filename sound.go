@@ -20,11 +20,14 @@ package main
 
 import (
 	"bytes"
+	"encoding/binary"
+	"errors"
 	"fmt"
 	"log"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"regexp"
 	"runtime"
 	"sync"
 	"time"
@@ -35,12 +38,18 @@ import (
 const antiPop = 4800
 
 type Sound struct {
-	sounds map[string]*oto.Player
+	cache  *SoundCache
 	cfg    *Config
 	opts   *oto.NewContextOptions
 	ctx    *oto.Context
+	mixer  *Mixer
+	player *oto.Player
 
 	ffmpeg string
+
+	// per-file gains in dB, they replace the loudness normalization
+	gains     map[string]float64
+	normalize bool
 	sync.Mutex
 }
 
@@ -48,16 +57,31 @@ func NewSound() *Sound {
 	cfg := &Config{}
 	cfg.Init()
 
-	return &Sound{
-		sounds: map[string]*oto.Player{},
-		cfg:    cfg,
-		opts: &oto.NewContextOptions{
-			SampleRate:   48000,
-			ChannelCount: 2,
-			Format:       oto.FormatSignedInt16LE,
-		},
-		ctx: nil,
+	ffmpeg := "ffmpeg"
+	if e := os.Getenv("ffmpeg"); e != "" {
+		ffmpeg = e
+	} else if runtime.GOOS == "windows" {
+		ffmpeg = ".\\ffmpeg.exe"
+	}
+
+	opts := &oto.NewContextOptions{
+		SampleRate:   48000,
+		ChannelCount: 2,
+		Format:       oto.FormatSignedInt16LE,
+	}
+
+	s := &Sound{
+		cfg:       cfg,
+		opts:      opts,
+		ctx:       nil,
+		mixer:     NewMixer(opts.SampleRate, opts.ChannelCount),
+		ffmpeg:    ffmpeg,
+		gains:     map[string]float64{},
+		normalize: true,
 	}
+	s.cache = NewSoundCache(s.loadSound)
+
+	return s
 }
 
 func (s *Sound) Init() error {
@@ -68,20 +92,48 @@ func (s *Sound) Init() error {
 
 	<-readyChan
 	s.ctx = otoCtx
-	s.ffmpeg = "ffmpeg"
-	if e := os.Getenv("ffmpeg"); e != "" {
-		s.ffmpeg = e
-	} else if runtime.GOOS == "windows" {
-		s.ffmpeg = ".\\ffmpeg.exe"
-	}
+
+	// the mixer outputs silence when nothing is playing, so the player
+	// never stops; a small buffer keeps the latency of new voices low
+	s.player = s.ctx.NewPlayer(s.mixer)
+	s.player.SetBufferSize(s.opts.SampleRate * s.opts.ChannelCount * 2 / 20)
+	s.player.Play()
+
 	return nil
 }
 
-func (s *Sound) decodeWithFFmpeg(filename string) ([]byte, error) {
+// AudioCodecStatus tells the settings page which formats can be played.
+type AudioCodecStatus struct {
+	Name      string
+	Native    bool
+	Available bool
+}
+
+// Codecs lists the built-in decoders and ffmpeg, which is used for all the
+// other formats.
+func (s *Sound) Codecs() []AudioCodecStatus {
+	var result []AudioCodecStatus
+	for _, codec := range AUDIO_CODECS {
+		result = append(result, AudioCodecStatus{Name: codec.Name, Native: true, Available: true})
+	}
+
+	_, err := exec.LookPath(s.ffmpeg)
+	result = append(result, AudioCodecStatus{Name: "other formats (ffmpeg)", Available: err == nil})
+
+	return result
+}
+
+// decodeWithFFmpeg decodes the file, or the data if it is not nil.
+func (s *Sound) decodeWithFFmpeg(filename string, data []byte) ([]byte, error) {
+	input := filename
+	if data != nil {
+		input = "pipe:0"
+	}
+
 	buf := new(bytes.Buffer)
 	cmd := exec.Command(
 		s.ffmpeg,
-		"-i", filename,
+		"-i", input,
 		"-f", "s16le",
 		"-acodec", "pcm_s16le",
 		"-ar", fmt.Sprintf("%d", s.opts.SampleRate),
@@ -93,6 +145,9 @@ func (s *Sound) decodeWithFFmpeg(filename string) ([]byte, error) {
 		buf.WriteByte(0)
 	}
 
+	if data != nil {
+		cmd.Stdin = bytes.NewReader(data)
+	}
 	cmd.Stdout = buf
 	cmd.Stderr = os.Stderr
 
@@ -109,73 +164,213 @@ func (s *Sound) decodeWithFFmpeg(filename string) ([]byte, error) {
 	return buf.Bytes(), nil
 }
 
-func (s *Sound) loadSound(filename string) (*oto.Player, error) {
+// loadSound decodes the sound and brings it to the target loudness, unless
+// there is a gain set for it.
+func (s *Sound) loadSound(name string) ([]int16, error) {
+	log.Printf("loading %q", name)
+	samples, err := s.decodeSound(name)
+	if err != nil {
+		return nil, err
+	}
+
+	s.Lock()
+	gain, ok := s.gains[name]
+	normalize := s.normalize
+	s.Unlock()
+
+	if !ok && normalize {
+		gain = NormalizationGain(samples, s.opts.SampleRate, s.opts.ChannelCount, SOUND_LOUDNESS)
+		log.Printf("%q: loudness is %.1f dBFS, applying %.1f dB", name, Loudness(samples, s.opts.SampleRate, s.opts.ChannelCount), gain)
+	}
+	ApplyGain(samples, gain)
+
+	return samples, nil
+}
+
+func (s *Sound) decodeSound(filename string) ([]int16, error) {
 	dir, _ := filepath.Split(filename)
 	if dir == "" {
 		filename = s.cfg.Asset(filename)
 	}
 
-	b, err := s.decodeWithFFmpeg(filename)
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.decode(filename, data, false)
+}
+
+// decode converts the audio file to the format of the mixer, the formats
+// which do not have a built-in decoder are passed to ffmpeg as a file or
+// through its standard input.
+func (s *Sound) decode(filename string, data []byte, stdin bool) ([]int16, error) {
+	pcm, err := DecodeAudio(data, filename)
+	if err == nil {
+		return pcm.Convert(s.opts.SampleRate, s.opts.ChannelCount), nil
+	}
+	if !errors.Is(err, ErrUnsupportedAudio) {
+		log.Printf("cannot decode %q, trying ffmpeg: %s", filename, err)
+	}
+
+	if !stdin {
+		data = nil
+	}
+	b, err := s.decodeWithFFmpeg(filename, data)
 	if err != nil {
 		return nil, fmt.Errorf("decode error: %w", err)
 	}
 
+	samples := make([]int16, len(b)/2)
+	for i := range samples {
+		samples[i] = int16(binary.LittleEndian.Uint16(b[2*i:]))
+	}
+
+	// ffmpeg outputs whole frames, but the mixer must not get a partial one
+	// from a truncated file
+	return samples[:len(samples)-len(samples)%s.opts.ChannelCount], nil
+}
+
+// Configure sets the master volume, the volumes of the categories and the
+// limits of the mixer and the cache.
+func (s *Sound) Configure(settings SoundSettings) {
+	s.mixer.Configure(settings)
+
+	limit := settings.CacheSize
+	if limit <= 0 {
+		limit = SOUND_CACHE_SIZE
+	}
+	s.cache.SetLimit(limit << 20)
+
 	s.Lock()
-	defer s.Unlock()
-	return s.ctx.NewPlayer(bytes.NewReader(b)), nil
+	changed := s.normalize != settings.Normalize
+	s.normalize = settings.Normalize
+	s.Unlock()
+
+	if changed {
+		s.cache.Flush()
+	}
 }
 
-func (s *Sound) Play(filename string, volume int) (err error) {
-	if s.ctx == nil {
-		return fmt.Errorf("sound system is disabled")
+// SetGains replaces the per-file gains, the sounds whose gain has changed are
+// loaded again.
+func (s *Sound) SetGains(gains map[string]float64) {
+	s.Lock()
+	old := s.gains
+	s.gains = gains
+	s.Unlock()
+
+	for name, gain := range old {
+		if g, ok := gains[name]; !ok || g != gain {
+			s.cache.Remove(name)
+		}
+	}
+	for name := range gains {
+		if _, ok := old[name]; !ok {
+			s.cache.Remove(name)
+		}
 	}
+}
+
+// Forget drops the decoded sound from the cache, so that a file which has
+// been replaced is decoded again the next time it is played.
+func (s *Sound) Forget(name string) {
+	s.cache.Remove(name)
+}
 
+// SetGain sets the gain of a single file.
+func (s *Sound) SetGain(name string, gain float64) {
 	s.Lock()
-	p, ok := s.sounds[filename]
+	gains := make(map[string]float64, len(s.gains)+1)
+	for k, v := range s.gains {
+		gains[k] = v
+	}
 	s.Unlock()
 
-	if !ok {
-		log.Printf("loading %q", filename)
-		p, err = s.loadSound(filename)
-		if err != nil {
-			log.Printf("error loading sound %q: %s", filename, err)
-			return
+	gains[name] = gain
+	s.SetGains(gains)
+}
+
+// scriptSound matches the quoted file names of sounds in a script, names
+// with spaces are not recognized to avoid matching chat replies.
+var scriptSound = regexp.MustCompile(`["']([^"'\\\s]+\.(?i:wav|mp3|ogg|oga|flac|aac|m4a|opus))["']`)
+
+// ScriptSounds returns the names of the sound files mentioned in the script,
+// e.g. Golem.AlertSound = "mumsit.mp3" or alert("Raid!", "", "raid.mp3").
+func ScriptSounds(script string) []string {
+	seen := make(map[string]bool)
+	var result []string
+	for _, m := range scriptSound.FindAllStringSubmatch(script, -1) {
+		if !seen[m[1]] {
+			seen[m[1]] = true
+			result = append(result, m[1])
 		}
-		p.SetVolume(1)
-		s.Lock()
-		s.sounds[filename] = p
-		s.Unlock()
 	}
 
-	log.Printf("playing back %q", filename)
-	if p.IsPlaying() {
-		p.Pause()
-		time.Sleep(time.Millisecond * 50)
+	return result
+}
+
+// Preload loads the sounds into the cache, so that their first playback does
+// not wait for decoding.
+func (s *Sound) Preload(names []string) {
+	if s.ctx == nil {
+		return
 	}
-	_, err = p.Seek(0, 0)
-	if err != nil {
-		log.Printf("seek failed: %s", err)
-	}
-	p.SetVolume(0)
-	p.Play()
-	maxVol := 0.01 * float64(volume)
-	if maxVol > 1.0 {
-		maxVol = 1.0
-	} else if maxVol < 0.0 {
-		maxVol = 0.0
-	}
-	for v := 0.0; v <= maxVol; v += maxVol / 20 {
-		p.SetVolume(v)
-		time.Sleep(time.Millisecond * 10)
-		if maxVol == 0.0 {
-			break
+
+	for _, name := range names {
+		if _, err := s.cache.Get(name); err != nil {
+			log.Printf("cannot preload %q: %s", name, err)
 		}
 	}
-	err = p.Err()
+}
+
+// Play starts playing the sound in the given category (SOUND_ALERTS,
+// SOUND_TTS or SOUND_EFFECTS) and returns immediately. The same sound can be
+// played several times at once.
+func (s *Sound) Play(category, filename string, volume int) (err error) {
+	if s.ctx == nil {
+		return fmt.Errorf("sound system is disabled")
+	}
+
+	samples, err := s.cache.Get(filename)
+	if err != nil {
+		log.Printf("error loading sound %q: %s", filename, err)
+		return
+	}
+
+	log.Printf("playing back %q (%s)", filename, category)
+	err = s.mixer.Add(category, samples, volume)
 	if err != nil {
 		log.Printf("play error: %s", err)
 	}
 	return
 }
 
+// PlayData plays an audio file from memory without caching it, e.g. the
+// result of TTS, and returns how long it is going to play.
+func (s *Sound) PlayData(category string, data []byte, volume int) (time.Duration, error) {
+	if s.ctx == nil {
+		return 0, fmt.Errorf("sound system is disabled")
+	}
+
+	samples, err := s.decode(category, data, true)
+	if err != nil {
+		return 0, err
+	}
+
+	s.Lock()
+	normalize := s.normalize
+	s.Unlock()
+	if normalize {
+		ApplyGain(samples, NormalizationGain(samples, s.opts.SampleRate, s.opts.ChannelCount, SOUND_LOUDNESS))
+	}
+
+	if err := s.mixer.Add(category, samples, volume); err != nil {
+		return 0, err
+	}
+
+	frames := len(samples) / s.opts.ChannelCount
+	return time.Duration(frames) * time.Second / time.Duration(s.opts.SampleRate), nil
+}
+
 // vim: ai:ts=8:sw=8:noet:syntax=go
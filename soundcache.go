@@ -0,0 +1,154 @@
+/**
+ * Copyright 2025 kmeaw
+ *
+ * Licensed under the GNU Affero General Public License (AGPL).
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU Affero General Public License as published by the
+ * Free Software Foundation, version 3 of the License.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or
+ * FITNESS FOR A PARTICULAR PURPOSE.  See the GNU Affero General Public License
+ * for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package main
+
+import (
+	"container/list"
+	"sync"
+)
+
+// The default limit of the decoded sounds kept in memory, in MiB.
+const SOUND_CACHE_SIZE = 128
+
+type soundCacheEntry struct {
+	name    string
+	samples []int16
+}
+
+type soundLoad struct {
+	done    chan struct{}
+	samples []int16
+	err     error
+}
+
+// SoundCache keeps the decoded sounds and evicts the least recently used ones
+// when they take more memory than allowed. A sound which is being loaded is
+// loaded only once, no matter how many times it is requested meanwhile.
+type SoundCache struct {
+	Load func(name string) ([]int16, error)
+
+	limit   int
+	size    int
+	lru     *list.List
+	entries map[string]*list.Element
+	loading map[string]*soundLoad
+	gen     uint64
+
+	mu sync.Mutex
+}
+
+func NewSoundCache(load func(name string) ([]int16, error)) *SoundCache {
+	return &SoundCache{
+		Load:    load,
+		limit:   SOUND_CACHE_SIZE << 20,
+		lru:     list.New(),
+		entries: make(map[string]*list.Element),
+		loading: make(map[string]*soundLoad),
+	}
+}
+
+// SetLimit sets the memory limit in bytes.
+func (c *SoundCache) SetLimit(limit int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.limit = limit
+	c.evict()
+}
+
+// evict removes the least recently used sounds, but always keeps the most
+// recent one, must be called with c.mu held.
+func (c *SoundCache) evict() {
+	for c.size > c.limit && c.lru.Len() > 1 {
+		c.remove(c.lru.Back())
+	}
+}
+
+func (c *SoundCache) remove(e *list.Element) {
+	entry := c.lru.Remove(e).(*soundCacheEntry)
+	delete(c.entries, entry.name)
+	c.size -= 2 * len(entry.samples)
+}
+
+// Get returns the sound from the cache, loading it if needed.
+func (c *SoundCache) Get(name string) ([]int16, error) {
+	c.mu.Lock()
+	if e, ok := c.entries[name]; ok {
+		c.lru.MoveToFront(e)
+		c.mu.Unlock()
+		return e.Value.(*soundCacheEntry).samples, nil
+	}
+
+	if load, ok := c.loading[name]; ok {
+		c.mu.Unlock()
+		<-load.done
+		return load.samples, load.err
+	}
+
+	load := &soundLoad{done: make(chan struct{})}
+	c.loading[name] = load
+	gen := c.gen
+	c.mu.Unlock()
+
+	load.samples, load.err = c.Load(name)
+
+	c.mu.Lock()
+	delete(c.loading, name)
+	// the sound could have been invalidated while it was loading
+	if load.err == nil && gen == c.gen {
+		c.entries[name] = c.lru.PushFront(&soundCacheEntry{name: name, samples: load.samples})
+		c.size += 2 * len(load.samples)
+		c.evict()
+	}
+	c.mu.Unlock()
+	close(load.done)
+
+	return load.samples, load.err
+}
+
+// Remove drops the sound from the cache, so the next Get loads it again.
+func (c *SoundCache) Remove(name string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.gen++
+	if e, ok := c.entries[name]; ok {
+		c.remove(e)
+	}
+}
+
+// Flush drops all the sounds.
+func (c *SoundCache) Flush() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.gen++
+	for c.lru.Len() > 0 {
+		c.remove(c.lru.Back())
+	}
+}
+
+// Size returns the number of cached sounds and the memory they take.
+func (c *SoundCache) Size() (int, int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.lru.Len(), c.size
+}
+
+// vim: ai:ts=8:sw=8:noet:syntax=go
@@ -0,0 +1,81 @@
+/**
+ * Copyright 2025 kmeaw
+ *
+ * Licensed under the GNU Affero General Public License (AGPL).
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU Affero General Public License as published by the
+ * Free Software Foundation, version 3 of the License.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or
+ * FITNESS FOR A PARTICULAR PURPOSE.  See the GNU Affero General Public License
+ * for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+const TIMELINE_SIZE = 500
+
+type TimelineEntry struct {
+	Time   time.Time `json:"time"`
+	Kind   string    `json:"kind"`
+	Target string    `json:"target,omitempty"`
+	From   string    `json:"from,omitempty"`
+	Text   string    `json:"text"`
+	DryRun bool      `json:"dry_run,omitempty"`
+}
+
+// Timeline keeps the most recent actions zdrct has performed.
+type Timeline struct {
+	entries   []TimelineEntry
+	listeners []func(entry TimelineEntry)
+	mu        sync.Mutex
+}
+
+func NewTimeline() *Timeline {
+	return &Timeline{}
+}
+
+func (t *Timeline) Add(entry TimelineEntry) {
+	if entry.Time.IsZero() {
+		entry.Time = time.Now()
+	}
+
+	t.mu.Lock()
+	t.entries = append(t.entries, entry)
+	if len(t.entries) > TIMELINE_SIZE {
+		t.entries = append([]TimelineEntry(nil), t.entries[len(t.entries)-TIMELINE_SIZE:]...)
+	}
+	listeners := t.listeners
+	t.mu.Unlock()
+
+	for _, fn := range listeners {
+		fn(entry)
+	}
+}
+
+// OnAdd registers a function which is called for every new entry.
+func (t *Timeline) OnAdd(fn func(entry TimelineEntry)) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.listeners = append(t.listeners, fn)
+}
+
+// Entries returns a copy of the timeline in chronological order.
+func (t *Timeline) Entries() []TimelineEntry {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return append([]TimelineEntry(nil), t.entries...)
+}
+
+// vim: ai:ts=8:sw=8:noet:syntax=go
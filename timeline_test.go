@@ -0,0 +1,133 @@
+/**
+ * Copyright 2025 kmeaw
+ *
+ * Licensed under the GNU Affero General Public License (AGPL).
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU Affero General Public License as published by the
+ * Free Software Foundation, version 3 of the License.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or
+ * FITNESS FOR A PARTICULAR PURPOSE.  See the GNU Affero General Public License
+ * for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func timelineWithoutTime(timeline *Timeline) []TimelineEntry {
+	entries := timeline.Entries()
+	for i := range entries {
+		entries[i].Time = time.Time{}
+	}
+	return entries
+}
+
+func TestTimelineDryRun(t *testing.T) {
+	s := startFakeServer(t, "")
+
+	timeline := NewTimeline()
+	r := NewRconClient()
+	defer r.Close()
+	r.Name = "coop"
+	r.Timeline = timeline
+
+	if err := r.Connect(s.Addr(), ""); err != nil {
+		t.Fatalf("Connect: %s", err)
+	}
+	if err := r.CommandFrom("viewer", "say live"); err != nil {
+		t.Fatalf("CommandFrom: %s", err)
+	}
+
+	r.Locked(func(r *RconClient) error {
+		r.DryRun = true
+		return nil
+	})
+	if err := r.CommandFrom("viewer", "say dry"); err != nil {
+		t.Fatalf("CommandFrom: %s", err)
+	}
+
+	r.Close()
+	if err := r.CommandFrom("", "say offline"); err != nil {
+		t.Fatalf("CommandFrom has failed in the dry-run mode: %s", err)
+	}
+
+	waitFor(t, "the live command", func() bool {
+		return len(s.Commands()) > 0
+	})
+	if cmds := s.Commands(); len(cmds) != 1 || cmds[0] != "say live" {
+		t.Errorf("server has received %q, want only %q", cmds, "say live")
+	}
+
+	want := []TimelineEntry{
+		{Kind: "rcon", Target: "coop", From: "viewer", Text: "say live"},
+		{Kind: "rcon", Target: "coop", From: "viewer", Text: "say dry", DryRun: true},
+		{Kind: "rcon", Target: "coop", Text: "say offline", DryRun: true},
+	}
+	entries := timelineWithoutTime(timeline)
+	if len(entries) != len(want) {
+		t.Fatalf("timeline = %+v, want %+v", entries, want)
+	}
+	for i := range want {
+		if entries[i] != want[i] {
+			t.Errorf("entry %d = %+v, want %+v", i, entries[i], want[i])
+		}
+	}
+}
+
+func TestTimelineFailedCommands(t *testing.T) {
+	timeline := NewTimeline()
+	r := NewRconClient()
+	r.Timeline = timeline
+	r.Policy, _ = NewRconPolicy(RCON_POLICY_DENY, []string{"quit"})
+
+	if err := r.CommandFrom("viewer", "say hi"); err == nil {
+		t.Error("a command has been sent without a connection")
+	}
+	if err := r.CommandFrom("viewer", "quit"); err == nil {
+		t.Error("a denied command has been accepted")
+	}
+
+	entries := timelineWithoutTime(timeline)
+	want := TimelineEntry{Kind: "policy", From: "viewer", Text: "quit"}
+	if len(entries) != 1 || entries[0] != want {
+		t.Errorf("timeline = %+v, want only %+v", entries, want)
+	}
+}
+
+func TestTimelineSize(t *testing.T) {
+	timeline := NewTimeline()
+
+	var seen int
+	timeline.OnAdd(func(entry TimelineEntry) {
+		seen++
+	})
+
+	for i := 0; i < TIMELINE_SIZE+10; i++ {
+		timeline.Add(TimelineEntry{Kind: "rcon", Text: fmt.Sprint(i)})
+	}
+
+	entries := timeline.Entries()
+	if len(entries) != TIMELINE_SIZE {
+		t.Fatalf("the timeline has %d entries, want %d", len(entries), TIMELINE_SIZE)
+	}
+	if entries[0].Text != "10" || entries[len(entries)-1].Text != fmt.Sprint(TIMELINE_SIZE+9) {
+		t.Errorf("the timeline spans %q..%q", entries[0].Text, entries[len(entries)-1].Text)
+	}
+	if entries[0].Time.IsZero() {
+		t.Error("the time has not been set")
+	}
+	if seen != TIMELINE_SIZE+10 {
+		t.Errorf("the listener has seen %d entries, want %d", seen, TIMELINE_SIZE+10)
+	}
+}
+
+// vim: ai:ts=8:sw=8:noet:syntax=go
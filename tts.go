@@ -0,0 +1,362 @@
+/**
+ * Copyright 2025 kmeaw
+ *
+ * Licensed under the GNU Affero General Public License (AGPL).
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU Affero General Public License as published by the
+ * Free Software Foundation, version 3 of the License.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or
+ * FITNESS FOR A PARTICULAR PURPOSE.  See the GNU Affero General Public License
+ * for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+const (
+	TTS_HTTP    = "http"
+	TTS_COMMAND = "command"
+
+	// Messages longer than this (in characters) are cut.
+	TTS_MAX_LENGTH = 200
+
+	// Messages which do not fit into the queue are dropped.
+	TTS_QUEUE_SIZE = 16
+
+	TTS_TIMEOUT = 30 * time.Second
+)
+
+var TTS_PROVIDERS = []string{TTS_HTTP, TTS_COMMAND}
+
+// TTSProvider turns text into speech, the result is an audio file in any
+// format Sound can decode.
+type TTSProvider interface {
+	Synthesize(ctx context.Context, text, voice string) ([]byte, error)
+}
+
+// HTTPTTS posts the query of the endpoint as a form. Query values TEXT (or
+// {text}) and {voice} are replaced with the text and the voice. The password
+// of the URL is sent in the Authorization header as "<user> <password>", e.g.
+// "Api-Key AQXXXXXX".
+type HTTPTTS struct {
+	Endpoint string
+	Client   *http.Client
+}
+
+func (p *HTTPTTS) Synthesize(ctx context.Context, text, voice string) ([]byte, error) {
+	if p.Endpoint == "" {
+		return nil, fmt.Errorf("TTS endpoint is not set")
+	}
+
+	u, err := url.Parse(p.Endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("invalid TTS endpoint: %w", err)
+	}
+
+	ui := u.User
+	qs := u.Query()
+
+	u.User = nil
+	u.RawQuery = ""
+
+	for k, vv := range qs {
+		if len(vv) != 1 {
+			continue
+		}
+		switch vv[0] {
+		case "TEXT", "{text}":
+			qs[k] = []string{text}
+		case "{voice}":
+			if voice == "" {
+				delete(qs, k)
+			} else {
+				qs[k] = []string{voice}
+			}
+		}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", u.String(), strings.NewReader(qs.Encode()))
+	if err != nil {
+		return nil, fmt.Errorf("cannot construct a request: %w", err)
+	}
+
+	if ui != nil {
+		pass, ok := ui.Password()
+		if ok {
+			req.Header.Set("Authorization", ui.Username()+" "+pass)
+		}
+	}
+
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("User-Agent", "https://github.com/kmeaw/zdrct")
+
+	resp, err := p.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("unexpected status: %d (%q)", resp.StatusCode, body)
+	}
+
+	return io.ReadAll(resp.Body)
+}
+
+// CommandTTS runs a local engine, e.g. "espeak-ng --stdin --stdout -v {voice}".
+// The text is written to its standard input and a WAV file is expected on its
+// standard output. Without a voice the arguments with {voice} are dropped, and
+// so is the option before a bare {voice}, like HTTPTTS drops the parameter.
+type CommandTTS struct {
+	Command string
+}
+
+func (p *CommandTTS) Synthesize(ctx context.Context, text, voice string) ([]byte, error) {
+	args, err := ParseDoomArgs(p.Command)
+	if err != nil {
+		return nil, fmt.Errorf("invalid TTS command: %w", err)
+	}
+	if len(args) == 0 {
+		return nil, fmt.Errorf("TTS command is not set")
+	}
+
+	var cmdArgs []string
+	for i, arg := range args {
+		switch {
+		case !strings.Contains(arg, "{voice}"):
+			cmdArgs = append(cmdArgs, arg)
+		case voice != "":
+			cmdArgs = append(cmdArgs, strings.ReplaceAll(arg, "{voice}", voice))
+		case arg == "{voice}" && i > 1 && strings.HasPrefix(args[i-1], "-") && !strings.Contains(args[i-1], "{voice}"):
+			cmdArgs = cmdArgs[0 : len(cmdArgs)-1]
+		}
+	}
+	args = cmdArgs
+	if len(args) == 0 {
+		return nil, fmt.Errorf("TTS command is not set")
+	}
+
+	stdout := &bytes.Buffer{}
+	stderr := &bytes.Buffer{}
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	cmd.Stdin = strings.NewReader(text + "\n")
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("%s: %w (%q)", args[0], err, stderr.String())
+	}
+
+	return stdout.Bytes(), nil
+}
+
+// TTSSettings configure the provider and the processing of the messages.
+type TTSSettings struct {
+	Provider  string
+	Endpoint  string
+	Command   string
+	Voice     string
+	MaxLength int
+	Filter    []string
+}
+
+type ttsMessage struct {
+	text  string
+	voice string
+}
+
+// TTS speaks the messages one after another.
+type TTS struct {
+	Sound  *Sound
+	Client *http.Client
+
+	provider  TTSProvider
+	voice     string
+	maxLength int
+	filter    map[string]bool
+	voices    map[string]string
+
+	queue chan ttsMessage
+	mu    sync.Mutex
+}
+
+func NewTTS(sound *Sound) *TTS {
+	t := &TTS{
+		Sound:     sound,
+		Client:    &http.Client{Timeout: TTS_TIMEOUT},
+		maxLength: TTS_MAX_LENGTH,
+		voices:    make(map[string]string),
+		queue:     make(chan ttsMessage, TTS_QUEUE_SIZE),
+	}
+	t.provider = &HTTPTTS{Client: t.Client}
+
+	return t
+}
+
+// CheckTTSProvider returns an error if the provider is unknown, an empty one
+// means the default (HTTP) provider.
+func CheckTTSProvider(name string) error {
+	if name == "" {
+		return nil
+	}
+	for _, provider := range TTS_PROVIDERS {
+		if provider == name {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("unknown TTS provider: %q, expected one of %s", name, strings.Join(TTS_PROVIDERS, ", "))
+}
+
+func (t *TTS) Configure(settings TTSSettings) error {
+	if err := CheckTTSProvider(settings.Provider); err != nil {
+		return err
+	}
+
+	var provider TTSProvider
+	switch settings.Provider {
+	case TTS_HTTP, "":
+		provider = &HTTPTTS{Endpoint: settings.Endpoint, Client: t.Client}
+	case TTS_COMMAND:
+		provider = &CommandTTS{Command: settings.Command}
+	}
+
+	filter := make(map[string]bool)
+	for _, word := range settings.Filter {
+		filter[strings.ToLower(word)] = true
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.provider = provider
+	t.voice = settings.Voice
+	t.maxLength = settings.MaxLength
+	if t.maxLength <= 0 {
+		t.maxLength = TTS_MAX_LENGTH
+	}
+	t.filter = filter
+
+	return nil
+}
+
+// SetVoice sets the voice for the messages of the viewer, an empty voice
+// brings back the default one.
+func (t *TTS) SetVoice(user, voice string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if voice == "" {
+		delete(t.voices, user)
+	} else {
+		t.voices[user] = voice
+	}
+}
+
+// Voice returns the voice of the viewer.
+func (t *TTS) Voice(user string) string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if voice, ok := t.voices[user]; ok {
+		return voice
+	}
+	return t.voice
+}
+
+// Clean removes the filtered words and cuts the text to the maximum length.
+func (t *TTS) Clean(text string) string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var result strings.Builder
+	var word strings.Builder
+	flush := func() {
+		if !t.filter[strings.ToLower(word.String())] {
+			result.WriteString(word.String())
+		}
+		word.Reset()
+	}
+	for _, r := range text {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			word.WriteRune(r)
+			continue
+		}
+		flush()
+		result.WriteRune(r)
+	}
+	flush()
+
+	runes := []rune(strings.Join(strings.Fields(result.String()), " "))
+	if len(runes) > t.maxLength {
+		runes = runes[:t.maxLength]
+	}
+	return strings.TrimSpace(string(runes))
+}
+
+// Say puts the message into the queue, the voice of the viewer is used if
+// no voice is given.
+func (t *TTS) Say(from, text, voice string) error {
+	text = t.Clean(text)
+	if text == "" {
+		return fmt.Errorf("nothing to say")
+	}
+	if voice == "" {
+		voice = t.Voice(from)
+	}
+
+	select {
+	case t.queue <- ttsMessage{text: text, voice: voice}:
+		return nil
+	default:
+		return fmt.Errorf("the TTS queue is full")
+	}
+}
+
+// Run speaks the queued messages, the next one is synthesized after the
+// previous one has been played.
+func (t *TTS) Run() {
+	for msg := range t.queue {
+		t.mu.Lock()
+		provider := t.provider
+		t.mu.Unlock()
+
+		ctx, cancel := context.WithTimeout(context.Background(), TTS_TIMEOUT)
+		data, err := provider.Synthesize(ctx, msg.text, msg.voice)
+		cancel()
+		if err != nil {
+			log.Printf("TTS has failed: %s", err)
+			continue
+		}
+
+		duration, err := t.Sound.PlayData(SOUND_TTS, data, SOUND_VOLUME)
+		if err != nil {
+			log.Printf("cannot play TTS: %s", err)
+			continue
+		}
+		time.Sleep(duration)
+	}
+}
+
+// vim: ai:ts=8:sw=8:noet:syntax=go
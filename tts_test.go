@@ -0,0 +1,197 @@
+/**
+ * Copyright 2025 kmeaw
+ *
+ * Licensed under the GNU Affero General Public License (AGPL).
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU Affero General Public License as published by the
+ * Free Software Foundation, version 3 of the License.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or
+ * FITNESS FOR A PARTICULAR PURPOSE.  See the GNU Affero General Public License
+ * for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestTTSClean(t *testing.T) {
+	tts := NewTTS(nil)
+	if err := tts.Configure(TTSSettings{MaxLength: 20, Filter: []string{"darn", "ЖОПА"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		text, want string
+	}{
+		{"hello   world", "hello world"},
+		{"Darn it, darnit!", "it, darnit!"},
+		{"ну жопа же", "ну же"},
+		{"a very long message which is cut", "a very long message"},
+		{"darn", ""},
+	} {
+		if got := tts.Clean(tc.text); got != tc.want {
+			t.Errorf("Clean(%q) = %q, want %q", tc.text, got, tc.want)
+		}
+	}
+
+	if err := tts.Configure(TTSSettings{Provider: "carrier-pigeon"}); err == nil {
+		t.Errorf("Configure() with an unknown provider has succeeded")
+	}
+	for _, provider := range []string{"", TTS_HTTP, TTS_COMMAND} {
+		if err := CheckTTSProvider(provider); err != nil {
+			t.Errorf("CheckTTSProvider(%q): %s", provider, err)
+		}
+	}
+	if err := CheckTTSProvider("carrier-pigeon"); err == nil {
+		t.Errorf("CheckTTSProvider() with an unknown provider has succeeded")
+	}
+}
+
+func TestTTSQueue(t *testing.T) {
+	tts := NewTTS(nil)
+	tts.Configure(TTSSettings{Voice: "default"})
+	tts.SetVoice("alice", "oksana")
+
+	if err := tts.Say("bob", "darn", ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := tts.Say("alice", "hi", ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := tts.Say("alice", "hi", "zahar"); err != nil {
+		t.Fatal(err)
+	}
+	if err := tts.Say("alice", "  ", ""); err == nil {
+		t.Errorf("Say() of an empty message has succeeded")
+	}
+
+	for _, want := range []string{"default", "oksana", "zahar"} {
+		if msg := <-tts.queue; msg.voice != want {
+			t.Errorf("voice = %q, want %q", msg.voice, want)
+		}
+	}
+
+	for i := 0; i < TTS_QUEUE_SIZE; i++ {
+		tts.Say("bob", "spam", "")
+	}
+	if err := tts.Say("bob", "spam", ""); err == nil {
+		t.Errorf("Say() has succeeded with a full queue")
+	}
+}
+
+func TestTTSBuiltin(t *testing.T) {
+	b := NewIRCBot(nil, nil)
+	b.TTS = NewTTS(nil)
+
+	err := b.LoadScript(Config{Script: `
+func cmd_voice(voice) {
+	set_voice(voice)
+}
+
+func cmd_say() {
+	tts("hi", "zahar")
+	tts("hello")
+}
+`})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := b.ProcessMessage(context.Background(), "alice", "!voice oksana"); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "the voice of alice", func() bool {
+		return b.TTS.Voice("alice") == "oksana"
+	})
+	if voice := b.TTS.Voice("bob"); voice != "" {
+		t.Errorf("the voice of bob is %q, want the default one", voice)
+	}
+
+	if err := b.ProcessMessage(context.Background(), "alice", "!say"); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []ttsMessage{{"hi", "zahar"}, {"hello", "oksana"}} {
+		if msg := <-b.TTS.queue; msg != want {
+			t.Errorf("got %+v, want %+v", msg, want)
+		}
+	}
+}
+
+func TestHTTPTTS(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			t.Error(err)
+		}
+		if r.Header.Get("Authorization") != "Api-Key secret" {
+			t.Errorf("Authorization = %q", r.Header.Get("Authorization"))
+		}
+		w.Write([]byte(r.Form.Get("text") + "/" + r.Form.Get("voice") + "/" + r.Form.Get("lang")))
+	}))
+	defer srv.Close()
+
+	endpoint := strings.Replace(srv.URL, "http://", "http://Api-Key:secret@", 1)
+	p := &HTTPTTS{Endpoint: endpoint + "/tts?text=TEXT&voice={voice}&lang=ru-RU", Client: srv.Client()}
+
+	data, err := p.Synthesize(context.Background(), "привет", "oksana")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "привет/oksana/ru-RU" {
+		t.Errorf("got %q", data)
+	}
+
+	data, err = p.Synthesize(context.Background(), "hi", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "hi//ru-RU" {
+		t.Errorf("got %q", data)
+	}
+}
+
+func TestCommandTTS(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("no sh on windows")
+	}
+
+	p := &CommandTTS{Command: `sh -c 'echo "$0"; cat' {voice}`}
+	data, err := p.Synthesize(context.Background(), "hello", "en-us")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "en-us\nhello\n" {
+		t.Errorf("got %q", data)
+	}
+
+	// without a voice the option is dropped, not passed an empty value
+	p = &CommandTTS{Command: `sh -c 'echo "$@"; cat' sh -v {voice} --voice={voice} --stdout`}
+	for voice, want := range map[string]string{"": "--stdout\nhello\n", "en": "-v en --voice=en --stdout\nhello\n"} {
+		data, err := p.Synthesize(context.Background(), "hello", voice)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != want {
+			t.Errorf("voice %q: got %q, want %q", voice, data, want)
+		}
+	}
+
+	p = &CommandTTS{Command: `sh -c 'exit 1'`}
+	if _, err := p.Synthesize(context.Background(), "hello", ""); err == nil {
+		t.Errorf("a failing command has succeeded")
+	}
+}
+
+// vim: ai:ts=8:sw=8:noet:syntax=go
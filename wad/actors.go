@@ -0,0 +1,304 @@
+/**
+ * Copyright 2025 kmeaw
+ *
+ * Licensed under the GNU Affero General Public License (AGPL).
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU Affero General Public License as published by the
+ * Free Software Foundation, version 3 of the License.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or
+ * FITNESS FOR A PARTICULAR PURPOSE.  See the GNU Affero General Public License
+ * for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package wad
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Deeply nested #include chains are most likely loops.
+const MAX_INCLUDE_DEPTH = 16
+
+// ActorInfo is a class declaration found in DECORATE or ZScript.
+type ActorInfo struct {
+	Name      string `json:"name"`
+	Parent    string `json:"parent,omitempty"`
+	Replaces  string `json:"replaces,omitempty"`
+	DoomEdNum int    `json:"doomednum,omitempty"`
+	Abstract  bool   `json:"abstract,omitempty"`
+
+	// Source is the archive and the lump the class is declared in.
+	Source string `json:"source"`
+
+	zscript bool
+}
+
+type token struct {
+	text   string
+	quoted bool
+}
+
+// tokenize splits DECORATE/ZScript source into identifiers, numbers,
+// strings and single punctuation characters, skipping comments.
+func tokenize(src string) []token {
+	var tokens []token
+
+	for i := 0; i < len(src); {
+		c := src[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\r' || c == '\n':
+			i++
+		case strings.HasPrefix(src[i:], "//"):
+			for i < len(src) && src[i] != '\n' {
+				i++
+			}
+		case strings.HasPrefix(src[i:], "/*"):
+			end := strings.Index(src[i+2:], "*/")
+			if end == -1 {
+				return tokens
+			}
+			i += end + 4
+		case c == '"':
+			var sb strings.Builder
+			i++
+			for i < len(src) && src[i] != '"' {
+				if src[i] == '\\' && i+1 < len(src) {
+					i++
+				}
+				sb.WriteByte(src[i])
+				i++
+			}
+			i++
+			tokens = append(tokens, token{sb.String(), true})
+		case isWordChar(c):
+			start := i
+			for i < len(src) && isWordChar(src[i]) {
+				i++
+			}
+			tokens = append(tokens, token{src[start:i], false})
+		default:
+			tokens = append(tokens, token{string(c), false})
+			i++
+		}
+	}
+
+	return tokens
+}
+
+func isWordChar(c byte) bool {
+	return c == '_' || c == '.' || c >= '0' && c <= '9' || c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z' || c >= 0x80
+}
+
+func (t token) is(word string) bool {
+	return !t.quoted && strings.EqualFold(t.text, word)
+}
+
+// ParseDecorate returns the actors declared in a DECORATE lump and the
+// names of the included lumps.
+func ParseDecorate(src string) (actors []ActorInfo, includes []string) {
+	tokens := tokenize(src)
+	depth := 0
+
+	for i := 0; i < len(tokens); i++ {
+		t := tokens[i]
+		switch {
+		case t.is("{"):
+			depth++
+		case t.is("}"):
+			if depth > 0 {
+				depth--
+			}
+		case depth > 0:
+		case t.is("#") && i+2 < len(tokens) && tokens[i+1].is("include"):
+			includes = append(includes, tokens[i+2].text)
+			i += 2
+		case t.is("actor") && i+1 < len(tokens):
+			actor := ActorInfo{Name: tokens[i+1].text}
+			i += 2
+			for ; i < len(tokens) && !tokens[i].is("{"); i++ {
+				switch {
+				case tokens[i].is(":") && i+1 < len(tokens):
+					actor.Parent = tokens[i+1].text
+					i++
+				case tokens[i].is("replaces") && i+1 < len(tokens):
+					actor.Replaces = tokens[i+1].text
+					i++
+				default:
+					if n, err := strconv.Atoi(tokens[i].text); err == nil && !tokens[i].quoted {
+						actor.DoomEdNum = n
+					}
+				}
+			}
+			// let the loop see the opening brace
+			i--
+			actors = append(actors, actor)
+		}
+	}
+
+	return actors, includes
+}
+
+// ParseZScript returns the classes declared in a ZScript lump and the
+// names of the included lumps. Structs, enums and "extend class" blocks are
+// skipped.
+func ParseZScript(src string) (classes []ActorInfo, includes []string) {
+	tokens := tokenize(src)
+	depth := 0
+
+	for i := 0; i < len(tokens); i++ {
+		t := tokens[i]
+		switch {
+		case t.is("{"):
+			depth++
+		case t.is("}"):
+			if depth > 0 {
+				depth--
+			}
+		case depth > 0:
+		case t.is("#") && i+2 < len(tokens) && tokens[i+1].is("include"):
+			includes = append(includes, tokens[i+2].text)
+			i += 2
+		case (t.is("extend") || t.is("mixin")) && i+1 < len(tokens) && tokens[i+1].is("class"):
+			i++
+		case t.is("class") && i+1 < len(tokens):
+			class := ActorInfo{Name: tokens[i+1].text, zscript: true}
+			i += 2
+			for ; i < len(tokens) && !tokens[i].is("{") && !tokens[i].is(";"); i++ {
+				switch {
+				case tokens[i].is(":") && i+1 < len(tokens):
+					class.Parent = tokens[i+1].text
+					i++
+				case tokens[i].is("replaces") && i+1 < len(tokens):
+					class.Replaces = tokens[i+1].text
+					i++
+				case tokens[i].is("abstract"):
+					class.Abstract = true
+				}
+			}
+			i--
+			classes = append(classes, class)
+		}
+	}
+
+	return classes, includes
+}
+
+// Actors parses all DECORATE and ZSCRIPT lumps following their includes
+// and returns the classes derived from Actor sorted by name. When the same
+// class is declared twice, the last declaration wins. Abstract classes are
+// left out, as they cannot be summoned, and includes which cannot be found or
+// read are logged and skipped.
+func (r *Resources) Actors() ([]ActorInfo, error) {
+	classes := make(map[string]ActorInfo)
+	seen := make(map[*Lump]bool)
+
+	var load func(lump *Lump, zscript bool, depth int) error
+	load = func(lump *Lump, zscript bool, depth int) error {
+		if seen[lump] {
+			return nil
+		}
+		seen[lump] = true
+
+		if depth > MAX_INCLUDE_DEPTH {
+			return fmt.Errorf("%s: %s: includes are nested too deeply", lump.archive.Path, lump.Path)
+		}
+
+		data, err := lump.Read()
+		if err != nil {
+			return err
+		}
+
+		var found []ActorInfo
+		var includes []string
+		if zscript {
+			found, includes = ParseZScript(string(data))
+		} else {
+			found, includes = ParseDecorate(string(data))
+		}
+
+		source := lump.archive.Path + ":" + lump.Path
+		for _, class := range found {
+			class.Source = source
+			classes[strings.ToLower(class.Name)] = class
+		}
+
+		// a broken include only loses its own classes
+		for _, name := range includes {
+			inc := r.FindPath(lump.archive, name)
+			if inc == nil {
+				log.Printf("%s: cannot find the included lump %q", source, name)
+				continue
+			}
+			if err := load(inc, zscript, depth+1); err != nil {
+				log.Printf("%s: %s", source, err)
+			}
+		}
+
+		return nil
+	}
+
+	for _, lump := range r.Lumps() {
+		if lump.Namespace != NS_GLOBAL {
+			continue
+		}
+
+		switch lump.Name {
+		case "ZSCRIPT":
+			if err := load(lump, true, 0); err != nil {
+				return nil, err
+			}
+		case "DECORATE":
+			if err := load(lump, false, 0); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	var result []ActorInfo
+	for key, class := range classes {
+		if key != "actor" && !class.Abstract && isActor(classes, class) {
+			result = append(result, class)
+		}
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return strings.ToLower(result[i].Name) < strings.ToLower(result[j].Name)
+	})
+
+	return result, nil
+}
+
+// isActor follows the parents up to Actor. DECORATE actors without a
+// parent derive from Actor implicitly. Classes with parents that are not
+// loaded (e.g. the engine PK3 is missing) are assumed to be actors.
+func isActor(classes map[string]ActorInfo, class ActorInfo) bool {
+	for i := 0; i < len(classes); i++ {
+		if strings.EqualFold(class.Name, "actor") {
+			return true
+		}
+
+		if class.Parent == "" {
+			return !class.zscript
+		}
+
+		parent, ok := classes[strings.ToLower(class.Parent)]
+		if !ok {
+			return true
+		}
+		class = parent
+	}
+
+	// inheritance loop
+	return false
+}
+
+// vim: ai:ts=8:sw=8:noet:syntax=go
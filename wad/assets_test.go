@@ -0,0 +1,182 @@
+/**
+ * Copyright 2025 kmeaw
+ *
+ * Licensed under the GNU Affero General Public License (AGPL).
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU Affero General Public License as published by the
+ * Free Software Foundation, version 3 of the License.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or
+ * FITNESS FOR A PARTICULAR PURPOSE.  See the GNU Affero General Public License
+ * for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package wad
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image/color"
+	"image/png"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func testPalette() string {
+	var sb strings.Builder
+	for i := 0; i < 256; i++ {
+		sb.Write([]byte{byte(i), byte(255 - i), 0})
+	}
+	return sb.String()
+}
+
+// A 2x3 picture: the first column has a single pixel at y=1, the second
+// one is full.
+func testPicture() string {
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.LittleEndian, []uint16{2, 3, 0, 0})
+	binary.Write(&buf, binary.LittleEndian, []uint32{16, 22})
+	buf.Write([]byte{1, 1, 0, 10, 0, 0xff})
+	buf.Write([]byte{0, 3, 0, 20, 21, 22, 0, 0xff})
+	return buf.String()
+}
+
+func testDMX(samples []byte) string {
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.LittleEndian, []uint16{DMX_FORMAT, 11025})
+	binary.Write(&buf, binary.LittleEndian, uint32(len(samples)+2*DMX_PADDING))
+	buf.Write(bytes.Repeat([]byte{0x80}, DMX_PADDING))
+	buf.Write(samples)
+	buf.Write(bytes.Repeat([]byte{0x80}, DMX_PADDING))
+	return buf.String()
+}
+
+func TestDecodePicture(t *testing.T) {
+	palette, err := ReadPalette([]byte(testPalette()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	img, err := DecodePicture([]byte(testPicture()), palette)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if b := img.Bounds(); b.Dx() != 2 || b.Dy() != 3 {
+		t.Fatalf("size = %v", b)
+	}
+
+	tests := []struct {
+		x, y int
+		want color.NRGBA
+	}{
+		{0, 0, color.NRGBA{}},
+		{0, 1, color.NRGBA{10, 245, 0, 255}},
+		{0, 2, color.NRGBA{}},
+		{1, 0, color.NRGBA{20, 235, 0, 255}},
+		{1, 2, color.NRGBA{22, 233, 0, 255}},
+	}
+	for _, test := range tests {
+		if got := img.NRGBAAt(test.x, test.y); got != test.want {
+			t.Errorf("pixel %d,%d = %v, want %v", test.x, test.y, got, test.want)
+		}
+	}
+
+	pic := testPicture()
+	for _, bad := range []string{"", pic[0:10], pic[0 : len(pic)-1], "\x00\x00\x01\x00" + pic[4:]} {
+		if _, err := DecodePicture([]byte(bad), palette); err == nil {
+			t.Errorf("DecodePicture(%q) has succeeded", bad)
+		}
+	}
+}
+
+func TestDecodeDMX(t *testing.T) {
+	samples := []byte{0x00, 0x40, 0x80, 0xc0, 0xff}
+	sound, err := DecodeDMX([]byte(testDMX(samples)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if sound.Rate != 11025 || !bytes.Equal(sound.Samples, samples) {
+		t.Errorf("DecodeDMX() = %+v", sound)
+	}
+
+	wav := sound.WAV()
+	if !IsWAV(wav) || len(wav)%2 != 0 {
+		t.Errorf("WAV() = %q", wav)
+	}
+	if size := binary.LittleEndian.Uint32(wav[4:8]); int(size) != len(wav)-8 {
+		t.Errorf("RIFF size = %d, want %d", size, len(wav)-8)
+	}
+	if n := binary.LittleEndian.Uint32(wav[40:44]); n != uint32(len(samples)) {
+		t.Errorf("data size = %d", n)
+	}
+
+	if _, err := DecodeDMX([]byte(testDMX(samples)[0:20])); err == nil {
+		t.Errorf("truncated sound has been decoded")
+	}
+}
+
+func TestAssets(t *testing.T) {
+	dir := t.TempDir()
+
+	iwad := filepath.Join(dir, "heretic.wad")
+	writeWad(t, iwad, "IWAD", []testLump{
+		{"PLAYPAL", testPalette()},
+		{"MUMSIT", testDMX([]byte{1, 2, 3})},
+		{"ENDOOM", strings.Repeat("x", 4000)},
+		{"S_START", ""},
+		{"MUMMA1", testPicture()},
+		{"S_END", ""},
+	})
+
+	pk3 := filepath.Join(dir, "mod.pk3")
+	writePk3(t, pk3, []testLump{
+		{"sprites/NEWSA0.png", string(pngSignature) + "rest of the file"},
+		{"sounds/newsnd.wav", "RIFF\x00\x00\x00\x00WAVEfmt "},
+		{"sounds/music.ogg", "OggS and some more"},
+	})
+
+	r, err := OpenResources(iwad, pk3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	if sprites := r.Sprites(); !reflect.DeepEqual(sprites, []string{"MUMMA1", "NEWSA0"}) {
+		t.Errorf("Sprites() = %q", sprites)
+	}
+
+	sounds, err := r.Sounds()
+	if err != nil || !reflect.DeepEqual(sounds, []string{"MUMSIT", "NEWSND"}) {
+		t.Errorf("Sounds() = %q, %v", sounds, err)
+	}
+
+	data, err := r.PicturePNG(r.Find(NS_SPRITES, "MUMMA1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if img, err := png.Decode(bytes.NewReader(data)); err != nil || img.Bounds().Dx() != 2 {
+		t.Errorf("png.Decode() = %v, %v", img, err)
+	}
+
+	data, err = r.PicturePNG(r.Find(NS_SPRITES, "NEWSA0"))
+	if err != nil || !IsPNG(data) {
+		t.Errorf("PicturePNG(NEWSA0) = %q, %v", data, err)
+	}
+
+	if _, err := r.SoundWAV(r.Find(NS_SOUNDS, "MUSIC")); err == nil {
+		t.Errorf("SoundWAV(MUSIC) has succeeded")
+	}
+	if data, err := r.SoundWAV(r.Find(NS_GLOBAL, "MUMSIT")); err != nil || !IsWAV(data) {
+		t.Errorf("SoundWAV(MUMSIT) = %q, %v", data, err)
+	}
+}
+
+// vim: ai:ts=8:sw=8:noet:syntax=go
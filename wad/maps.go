@@ -0,0 +1,502 @@
+/**
+ * Copyright 2025 kmeaw
+ *
+ * Licensed under the GNU Affero General Public License (AGPL).
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU Affero General Public License as published by the
+ * Free Software Foundation, version 3 of the License.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or
+ * FITNESS FOR A PARTICULAR PURPOSE.  See the GNU Affero General Public License
+ * for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package wad
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	MAP_FORMAT_DOOM  = "doom"
+	MAP_FORMAT_HEXEN = "hexen"
+	MAP_FORMAT_UDMF  = "udmf"
+
+	NS_MAPS = "maps"
+)
+
+var episodeMap = regexp.MustCompile(`^E([0-9])M[0-9]+$`)
+
+// MapInfo is a map found in the archives with the title and the episode
+// from MAPINFO, if any.
+type MapInfo struct {
+	Name    string `json:"name"`
+	Title   string `json:"title,omitempty"`
+	Episode string `json:"episode,omitempty"`
+	Format  string `json:"format"`
+	Source  string `json:"source"`
+}
+
+// MapDef is a map definition from MAPINFO.
+type MapDef struct {
+	Name  string
+	Title string
+
+	// Lookup is the LANGUAGE key of the title.
+	Lookup string
+	Next   string
+}
+
+// EpisodeDef is an episode definition from MAPINFO.
+type EpisodeDef struct {
+	Map    string
+	Name   string
+	Lookup string
+}
+
+// MapInfoLump is the result of parsing a MAPINFO or ZMAPINFO lump.
+type MapInfoLump struct {
+	Maps          []MapDef
+	Episodes      []EpisodeDef
+	ClearEpisodes bool
+	Includes      []string
+}
+
+// Top-level MAPINFO keywords which end an old-style (Hexen) map definition.
+var mapinfoSections = map[string]bool{
+	"map": true, "defaultmap": true, "adddefaultmap": true,
+	"gamedefaults": true, "episode": true, "clearepisodes": true,
+	"cluster": true, "clusterdef": true, "skill": true,
+	"clearskills": true, "gameinfo": true, "intermission": true,
+	"automap": true, "automap_overlay": true, "include": true,
+	"doomednums": true, "spawnnums": true, "conversationids": true,
+	"damagetype": true,
+}
+
+func isMapinfoSection(t token) bool {
+	return !t.quoted && mapinfoSections[strings.ToLower(t.text)]
+}
+
+// mapName converts Hexen map numbers to lump names.
+func mapName(name string) string {
+	if n, err := strconv.Atoi(name); err == nil {
+		return fmt.Sprintf("MAP%02d", n)
+	}
+
+	return strings.ToUpper(name)
+}
+
+// titleOf handles both `"Title"`, `"$KEY"` and `lookup "KEY"`.
+func titleOf(tokens []token, i int) (title, lookup string, next int) {
+	if i < len(tokens) && tokens[i].is("lookup") && i+1 < len(tokens) {
+		return "", tokens[i+1].text, i + 2
+	}
+
+	if i < len(tokens) && tokens[i].quoted {
+		if strings.HasPrefix(tokens[i].text, "$") {
+			return "", tokens[i].text[1:], i + 1
+		}
+		return tokens[i].text, "", i + 1
+	}
+
+	return "", "", i
+}
+
+// skipBlock returns the index after the block that starts at i.
+func skipBlock(tokens []token, i int) int {
+	depth := 0
+	for ; i < len(tokens); i++ {
+		if tokens[i].is("{") {
+			depth++
+		} else if tokens[i].is("}") {
+			depth--
+			if depth <= 0 {
+				return i + 1
+			}
+		}
+	}
+
+	return i
+}
+
+// property reads `key = value` or `key value` at i.
+func property(tokens []token, i int) (string, int) {
+	if i < len(tokens) && tokens[i].is("=") {
+		i++
+	}
+	if i < len(tokens) {
+		return tokens[i].text, i + 1
+	}
+
+	return "", i
+}
+
+// ParseMapInfo parses both the old (Hexen) and the new (ZDoom) MAPINFO
+// syntax. Only the map titles, the next maps and the episodes are kept.
+func ParseMapInfo(src string) *MapInfoLump {
+	tokens := tokenize(src)
+	result := &MapInfoLump{}
+
+	for i := 0; i < len(tokens); {
+		t := tokens[i]
+		switch {
+		case t.is("include") && i+1 < len(tokens):
+			result.Includes = append(result.Includes, tokens[i+1].text)
+			i += 2
+
+		case t.is("clearepisodes"):
+			result.Episodes = nil
+			result.ClearEpisodes = true
+			i++
+
+		case t.is("map") && i+1 < len(tokens):
+			def := MapDef{Name: mapName(tokens[i+1].text)}
+			def.Title, def.Lookup, i = titleOf(tokens, i+2)
+
+			if i < len(tokens) && tokens[i].is("{") {
+				end := skipBlock(tokens, i)
+				for j := i + 1; j < end-1; j++ {
+					if tokens[j].is("next") {
+						def.Next, j = property(tokens, j+1)
+						def.Next = mapName(def.Next)
+						j--
+					}
+				}
+				i = end
+			} else {
+				for i < len(tokens) && !isMapinfoSection(tokens[i]) {
+					if tokens[i].is("next") {
+						def.Next, i = property(tokens, i+1)
+						def.Next = mapName(def.Next)
+						continue
+					}
+					i++
+				}
+			}
+
+			result.Maps = append(result.Maps, def)
+
+		case t.is("episode") && i+1 < len(tokens):
+			ep := EpisodeDef{Map: mapName(tokens[i+1].text)}
+			i += 2
+
+			if i < len(tokens) && tokens[i].is("{") {
+				end := skipBlock(tokens, i)
+				for j := i + 1; j < end-1; j++ {
+					switch {
+					case tokens[j].is("name"):
+						var name string
+						name, j = property(tokens, j+1)
+						ep.Name, ep.Lookup, _ = titleOf([]token{{name, true}}, 0)
+						j--
+					case tokens[j].is("lookup"):
+						ep.Lookup, j = property(tokens, j+1)
+						ep.Name = ""
+						j--
+					}
+				}
+				i = end
+			} else {
+				for i < len(tokens) && !isMapinfoSection(tokens[i]) {
+					if tokens[i].is("name") {
+						ep.Name, ep.Lookup, i = titleOf(tokens, i+1)
+						continue
+					}
+					i++
+				}
+			}
+
+			result.Episodes = append(result.Episodes, ep)
+
+		case t.is("{"):
+			i = skipBlock(tokens, i)
+
+		default:
+			i++
+		}
+	}
+
+	return result
+}
+
+// ParseLanguage returns the strings of a LANGUAGE lump. The first
+// definition of a key wins, so the default [enu] section should come first.
+func ParseLanguage(src string) map[string]string {
+	tokens := tokenize(src)
+	result := make(map[string]string)
+
+	for i := 0; i+2 < len(tokens); i++ {
+		if tokens[i].quoted || !tokens[i+1].is("=") {
+			continue
+		}
+
+		key := strings.ToUpper(tokens[i].text)
+		var sb strings.Builder
+		j := i + 2
+		for ; j < len(tokens) && tokens[j].quoted; j++ {
+			sb.WriteString(tokens[j].text)
+		}
+
+		if _, ok := result[key]; !ok && j > i+2 {
+			result[key] = sb.String()
+		}
+		i = j
+	}
+
+	return result
+}
+
+// mapMarkers finds the maps in the archive: a marker followed by THINGS or
+// TEXTMAP in WADs, and maps/*.wad in PK3s.
+func (a *Archive) mapMarkers() ([]MapInfo, error) {
+	var result []MapInfo
+
+	for i, lump := range a.Lumps {
+		switch {
+		case lump.file != nil:
+			if lump.Namespace != NS_MAPS || !strings.HasSuffix(strings.ToLower(lump.Path), ".wad") {
+				continue
+			}
+
+			data, err := lump.Read()
+			if err != nil {
+				return nil, err
+			}
+
+			names, err := wadLumpNames(data)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %s: %w", a.Path, lump.Path, err)
+			}
+
+			if format := mapFormat(names); format != "" {
+				result = append(result, MapInfo{
+					Name:   lump.Name,
+					Format: format,
+					Source: a.Path + ":" + lump.Path,
+				})
+			}
+
+		case lump.Namespace == NS_GLOBAL:
+			var names []string
+			for j := i; j < len(a.Lumps) && j < i+12; j++ {
+				names = append(names, a.Lumps[j].Name)
+			}
+
+			if format := mapFormat(names); format != "" {
+				result = append(result, MapInfo{
+					Name:   lump.Name,
+					Format: format,
+					Source: a.Path,
+				})
+			}
+		}
+	}
+
+	return result, nil
+}
+
+// mapFormat checks the lumps following a map marker.
+func mapFormat(names []string) string {
+	if len(names) < 2 {
+		return ""
+	}
+
+	switch names[1] {
+	case "TEXTMAP":
+		return MAP_FORMAT_UDMF
+	case "THINGS":
+		for _, name := range names[2:] {
+			if name == "BEHAVIOR" {
+				return MAP_FORMAT_HEXEN
+			}
+		}
+		return MAP_FORMAT_DOOM
+	}
+
+	return ""
+}
+
+// wadLumpNames reads the directory of a WAD in memory, e.g. a map from a
+// PK3.
+func wadLumpNames(data []byte) ([]string, error) {
+	if len(data) < 12 || (string(data[0:4]) != "PWAD" && string(data[0:4]) != "IWAD") {
+		return nil, fmt.Errorf("not a WAD file")
+	}
+
+	n := int64(binary.LittleEndian.Uint32(data[4:8]))
+	offset := int64(binary.LittleEndian.Uint32(data[8:12]))
+	if offset+16*n > int64(len(data)) {
+		return nil, fmt.Errorf("bad directory: %d lumps at %d", n, offset)
+	}
+
+	names := make([]string, 0, n)
+	for i := int64(0); i < n; i++ {
+		name := data[offset+16*i+8 : offset+16*i+16]
+		if idx := bytes.IndexByte(name, 0); idx != -1 {
+			name = name[0:idx]
+		}
+		names = append(names, strings.ToUpper(string(name)))
+	}
+
+	return names, nil
+}
+
+// Maps returns the maps of all archives. The maps of the episodes defined
+// in MAPINFO come first in the episode order, following the "next" links,
+// then the rest sorted by name. Later archives override the earlier ones.
+func (r *Resources) Maps() ([]MapInfo, error) {
+	maps := make(map[string]MapInfo)
+	for _, a := range r.Archives {
+		found, err := a.mapMarkers()
+		if err != nil {
+			return nil, err
+		}
+		for _, info := range found {
+			maps[info.Name] = info
+		}
+	}
+
+	defs := make(map[string]MapDef)
+	var episodes []EpisodeDef
+	language := make(map[string]string)
+	seen := make(map[*Lump]bool)
+
+	var load func(lump *Lump, depth int) error
+	load = func(lump *Lump, depth int) error {
+		if seen[lump] {
+			return nil
+		}
+		seen[lump] = true
+
+		if depth > MAX_INCLUDE_DEPTH {
+			return fmt.Errorf("%s: %s: includes are nested too deeply", lump.archive.Path, lump.Path)
+		}
+
+		data, err := lump.Read()
+		if err != nil {
+			return err
+		}
+
+		info := ParseMapInfo(string(data))
+		if info.ClearEpisodes {
+			episodes = nil
+		}
+		episodes = append(episodes, info.Episodes...)
+		for _, def := range info.Maps {
+			defs[def.Name] = def
+		}
+
+		for _, name := range info.Includes {
+			inc := r.FindPath(lump.archive, name)
+			if inc == nil {
+				return fmt.Errorf("%s: %s: cannot find the included lump %q", lump.archive.Path, lump.Path, name)
+			}
+			if err := load(inc, depth+1); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+
+	for _, a := range r.Archives {
+		// ZMAPINFO takes precedence over MAPINFO in the same archive
+		var mapinfo *Lump
+		for _, lump := range a.Lumps {
+			if lump.Namespace != NS_GLOBAL {
+				continue
+			}
+
+			switch lump.Name {
+			case "ZMAPINFO":
+				mapinfo = lump
+			case "MAPINFO":
+				if mapinfo == nil || mapinfo.Name != "ZMAPINFO" {
+					mapinfo = lump
+				}
+			case "LANGUAGE":
+				data, err := lump.Read()
+				if err != nil {
+					return nil, err
+				}
+				// later archives override the strings
+				for key, value := range ParseLanguage(string(data)) {
+					language[key] = value
+				}
+			}
+		}
+
+		if mapinfo != nil {
+			if err := load(mapinfo, 0); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	title := func(text, lookup string) string {
+		if lookup != "" {
+			if s, ok := language[strings.ToUpper(lookup)]; ok {
+				return s
+			}
+		}
+
+		return text
+	}
+
+	var result []MapInfo
+	done := make(map[string]bool)
+	add := func(info MapInfo) {
+		if def, ok := defs[info.Name]; ok {
+			info.Title = title(def.Title, def.Lookup)
+		}
+		result = append(result, info)
+		done[info.Name] = true
+	}
+
+	for _, ep := range episodes {
+		name := title(ep.Name, ep.Lookup)
+		if name == "" {
+			name = ep.Map
+		}
+
+		for next := ep.Map; next != "" && !done[next]; next = defs[next].Next {
+			info, ok := maps[next]
+			if !ok {
+				break
+			}
+
+			info.Episode = name
+			add(info)
+		}
+	}
+
+	var rest []string
+	for name := range maps {
+		if !done[name] {
+			rest = append(rest, name)
+		}
+	}
+	sort.Strings(rest)
+
+	for _, name := range rest {
+		info := maps[name]
+		if m := episodeMap.FindStringSubmatch(name); m != nil {
+			info.Episode = "Episode " + m[1]
+		}
+		add(info)
+	}
+
+	return result, nil
+}
+
+// vim: ai:ts=8:sw=8:noet:syntax=go
@@ -0,0 +1,153 @@
+/**
+ * Copyright 2025 kmeaw
+ *
+ * Licensed under the GNU Affero General Public License (AGPL).
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU Affero General Public License as published by the
+ * Free Software Foundation, version 3 of the License.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or
+ * FITNESS FOR A PARTICULAR PURPOSE.  See the GNU Affero General Public License
+ * for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package wad
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestParseMapInfo(t *testing.T) {
+	info := ParseMapInfo(`
+// old (Hexen) syntax
+map 1 "WINNOWING HALL"
+warptrans 1
+next 2
+sky1 SKY2 0
+
+map MAP02 lookup "HUSTR_2"
+next "MAP03"
+
+clearepisodes
+episode MAP01
+name "Hub one"
+
+/* new syntax */
+map E1M1 "$HUSTR_E1M1" { next = "E1M2" sky1 = "SKY1" music = "$MUSIC_E1M1" }
+map E1M2 "Nuclear Plant" { levelnum = 2 }
+episode E1M1 { name = "Knee-Deep in the Dead" key = "k" }
+episode E2M1 { lookup = "TXT_E2" }
+gameinfo { map = "nope" }
+include "mapinfo/extra.txt"
+`)
+
+	wantMaps := []MapDef{
+		{Name: "MAP01", Title: "WINNOWING HALL", Next: "MAP02"},
+		{Name: "MAP02", Lookup: "HUSTR_2", Next: "MAP03"},
+		{Name: "E1M1", Lookup: "HUSTR_E1M1", Next: "E1M2"},
+		{Name: "E1M2", Title: "Nuclear Plant"},
+	}
+	if !reflect.DeepEqual(info.Maps, wantMaps) {
+		t.Errorf("Maps = %+v, want %+v", info.Maps, wantMaps)
+	}
+
+	wantEpisodes := []EpisodeDef{
+		{Map: "MAP01", Name: "Hub one"},
+		{Map: "E1M1", Name: "Knee-Deep in the Dead"},
+		{Map: "E2M1", Lookup: "TXT_E2"},
+	}
+	if !reflect.DeepEqual(info.Episodes, wantEpisodes) {
+		t.Errorf("Episodes = %+v, want %+v", info.Episodes, wantEpisodes)
+	}
+
+	if !info.ClearEpisodes || !reflect.DeepEqual(info.Includes, []string{"mapinfo/extra.txt"}) {
+		t.Errorf("ClearEpisodes = %v, Includes = %q", info.ClearEpisodes, info.Includes)
+	}
+}
+
+func TestParseLanguage(t *testing.T) {
+	strings := ParseLanguage(`[enu default]
+HUSTR_1 = "level 1: entryway";
+HUSTR_2 = "level 2: "
+	"underhalls";
+[fr]
+HUSTR_1 = "niveau 1";
+`)
+
+	want := map[string]string{
+		"HUSTR_1": "level 1: entryway",
+		"HUSTR_2": "level 2: underhalls",
+	}
+	if !reflect.DeepEqual(strings, want) {
+		t.Errorf("ParseLanguage() = %q, want %q", strings, want)
+	}
+}
+
+func TestMaps(t *testing.T) {
+	dir := t.TempDir()
+
+	iwad := filepath.Join(dir, "doom.wad")
+	writeWad(t, iwad, "IWAD", []testLump{
+		{"E1M1", ""}, {"THINGS", "x"}, {"LINEDEFS", "x"},
+		{"E1M2", ""}, {"THINGS", "x"}, {"LINEDEFS", "x"},
+		{"E2M1", ""}, {"THINGS", "x"}, {"LINEDEFS", "x"},
+		{"LANGUAGE", `[enu default] HUSTR_E1M1 = "E1M1: Hangar";`},
+	})
+
+	nested := filepath.Join(dir, "nested.wad")
+	writeWad(t, nested, "PWAD", []testLump{{"MAP07", ""}, {"TEXTMAP", "x"}, {"ENDMAP", ""}})
+	nestedData, err := os.ReadFile(nested)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pk3 := filepath.Join(dir, "mod.pk3")
+	writePk3(t, pk3, []testLump{
+		{"maps/map07.wad", string(nestedData)},
+		{"zmapinfo.txt", `
+map E1M1 lookup "HUSTR_E1M1" { next = "E1M2" }
+map E1M2 "Nuclear Plant" { next = "E1M3" }
+map MAP07 "Dead Simple" {}
+clearepisodes
+episode E1M1 { name = "Knee-Deep in the Dead" }
+`},
+		{"mapinfo.txt", `map E1M1 "ignored, ZMAPINFO wins"`},
+	})
+
+	pwad := filepath.Join(dir, "hexen.wad")
+	writeWad(t, pwad, "PWAD", []testLump{
+		{"MAP01", ""}, {"THINGS", "x"}, {"LINEDEFS", "x"}, {"BEHAVIOR", "x"},
+		{"NOTAMAP", ""}, {"PLAYPAL", "x"},
+	})
+
+	r, err := OpenResources(iwad, pk3, pwad)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	maps, err := r.Maps()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []MapInfo{
+		{Name: "E1M1", Title: "E1M1: Hangar", Episode: "Knee-Deep in the Dead", Format: MAP_FORMAT_DOOM, Source: iwad},
+		{Name: "E1M2", Title: "Nuclear Plant", Episode: "Knee-Deep in the Dead", Format: MAP_FORMAT_DOOM, Source: iwad},
+		{Name: "E2M1", Episode: "Episode 2", Format: MAP_FORMAT_DOOM, Source: iwad},
+		{Name: "MAP01", Format: MAP_FORMAT_HEXEN, Source: pwad},
+		{Name: "MAP07", Title: "Dead Simple", Format: MAP_FORMAT_UDMF, Source: pk3 + ":maps/map07.wad"},
+	}
+	if !reflect.DeepEqual(maps, want) {
+		t.Errorf("Maps() = %+v\nwant %+v", maps, want)
+	}
+}
+
+// vim: ai:ts=8:sw=8:noet:syntax=go
@@ -0,0 +1,169 @@
+/**
+ * Copyright 2025 kmeaw
+ *
+ * Licensed under the GNU Affero General Public License (AGPL).
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU Affero General Public License as published by the
+ * Free Software Foundation, version 3 of the License.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or
+ * FITNESS FOR A PARTICULAR PURPOSE.  See the GNU Affero General Public License
+ * for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package wad
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+)
+
+// Doom pictures are small, anything bigger is not a picture.
+const MAX_PICTURE_SIZE = 4096
+
+var pngSignature = []byte("\x89PNG\r\n\x1a\n")
+
+func IsPNG(data []byte) bool {
+	return bytes.HasPrefix(data, pngSignature)
+}
+
+// ReadPalette parses the first palette of a PLAYPAL lump.
+func ReadPalette(data []byte) (color.Palette, error) {
+	if len(data) < 768 {
+		return nil, fmt.Errorf("PLAYPAL is too short: %d bytes", len(data))
+	}
+
+	palette := make(color.Palette, 256)
+	for i := range palette {
+		palette[i] = color.NRGBA{data[3*i], data[3*i+1], data[3*i+2], 0xff}
+	}
+
+	return palette, nil
+}
+
+// Palette returns the palette from the last PLAYPAL lump.
+func (r *Resources) Palette() (color.Palette, error) {
+	lump := r.Find("*", "PLAYPAL")
+	if lump == nil {
+		return nil, fmt.Errorf("there is no PLAYPAL lump")
+	}
+
+	data, err := lump.Read()
+	if err != nil {
+		return nil, err
+	}
+
+	return ReadPalette(data)
+}
+
+// DecodePicture decodes a lump in the Doom picture (patch) format. Pixels
+// not covered by any post are transparent.
+func DecodePicture(data []byte, palette color.Palette) (*image.NRGBA, error) {
+	if len(data) < 8 {
+		return nil, fmt.Errorf("picture is too short: %d bytes", len(data))
+	}
+
+	width := int(binary.LittleEndian.Uint16(data[0:2]))
+	height := int(binary.LittleEndian.Uint16(data[2:4]))
+	if width == 0 || height == 0 || width > MAX_PICTURE_SIZE || height > MAX_PICTURE_SIZE {
+		return nil, fmt.Errorf("bad picture size: %dx%d", width, height)
+	}
+	if len(data) < 8+4*width {
+		return nil, fmt.Errorf("picture column table is truncated")
+	}
+
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for x := 0; x < width; x++ {
+		offset := int(binary.LittleEndian.Uint32(data[8+4*x:]))
+
+		// DeePsea tall patches: a post may continue below the
+		// previous one when topdelta is not greater than it.
+		top := -1
+		for {
+			if offset >= len(data) {
+				return nil, fmt.Errorf("column %d is truncated", x)
+			}
+			if data[offset] == 0xff {
+				break
+			}
+			if offset+3 > len(data) {
+				return nil, fmt.Errorf("column %d is truncated", x)
+			}
+
+			delta, length := int(data[offset]), int(data[offset+1])
+			if delta <= top {
+				top += delta
+			} else {
+				top = delta
+			}
+
+			pixels := offset + 3
+			if pixels+length > len(data) {
+				return nil, fmt.Errorf("column %d is truncated", x)
+			}
+
+			for i := 0; i < length && top+i < height; i++ {
+				img.Set(x, top+i, palette[data[pixels+i]])
+			}
+
+			// the post is followed by an unused byte
+			offset = pixels + length + 1
+		}
+	}
+
+	return img, nil
+}
+
+// PicturePNG converts a picture lump to PNG. Lumps which are PNG files
+// already (common in PK3s) are returned as is.
+func (r *Resources) PicturePNG(lump *Lump) ([]byte, error) {
+	data, err := lump.Read()
+	if err != nil {
+		return nil, err
+	}
+
+	if IsPNG(data) {
+		return data, nil
+	}
+
+	palette, err := r.Palette()
+	if err != nil {
+		return nil, err
+	}
+
+	img, err := DecodePicture(data, palette)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", lump.Path, err)
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// Sprites returns the names of all sprite frames, each name once.
+func (r *Resources) Sprites() []string {
+	seen := make(map[string]bool)
+	var result []string
+	for _, lump := range r.Lumps() {
+		if lump.Namespace == NS_SPRITES && !seen[lump.Name] {
+			seen[lump.Name] = true
+			result = append(result, lump.Name)
+		}
+	}
+
+	return result
+}
+
+// vim: ai:ts=8:sw=8:noet:syntax=go
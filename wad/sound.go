@@ -0,0 +1,185 @@
+/**
+ * Copyright 2025 kmeaw
+ *
+ * Licensed under the GNU Affero General Public License (AGPL).
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU Affero General Public License as published by the
+ * Free Software Foundation, version 3 of the License.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or
+ * FITNESS FOR A PARTICULAR PURPOSE.  See the GNU Affero General Public License
+ * for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package wad
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+const (
+	DMX_FORMAT  = 3
+	DMX_HEADER  = 8
+	DMX_PADDING = 16
+
+	NS_SOUNDS = "sounds"
+)
+
+// Sound is unsigned 8-bit mono PCM.
+type Sound struct {
+	Rate    int
+	Samples []byte
+}
+
+// isDMXHeader checks the header of a DMX digital sound lump of the given
+// size.
+func isDMXHeader(header []byte, size int64) bool {
+	if len(header) < DMX_HEADER {
+		return false
+	}
+
+	format := binary.LittleEndian.Uint16(header[0:2])
+	rate := binary.LittleEndian.Uint16(header[2:4])
+	n := binary.LittleEndian.Uint32(header[4:8])
+
+	return format == DMX_FORMAT && rate >= 4000 && int64(n) <= size-DMX_HEADER
+}
+
+func IsDMX(data []byte) bool {
+	return isDMXHeader(data, int64(len(data)))
+}
+
+func IsWAV(data []byte) bool {
+	return len(data) >= 12 && string(data[0:4]) == "RIFF" && string(data[8:12]) == "WAVE"
+}
+
+// DecodeDMX decodes a DMX sound lump. The 16 padding bytes at both ends of
+// the samples are dropped.
+func DecodeDMX(data []byte) (*Sound, error) {
+	if !IsDMX(data) {
+		return nil, fmt.Errorf("not a DMX sound")
+	}
+
+	rate := int(binary.LittleEndian.Uint16(data[2:4]))
+	n := int(binary.LittleEndian.Uint32(data[4:8]))
+	samples := data[DMX_HEADER : DMX_HEADER+n]
+	if len(samples) > 2*DMX_PADDING {
+		samples = samples[DMX_PADDING : len(samples)-DMX_PADDING]
+	}
+
+	return &Sound{
+		Rate:    rate,
+		Samples: samples,
+	}, nil
+}
+
+// WAV encodes the sound as a RIFF WAVE file.
+func (s *Sound) WAV() []byte {
+	var buf bytes.Buffer
+
+	// RIFF chunks are word-aligned
+	pad := len(s.Samples) % 2
+
+	buf.WriteString("RIFF")
+	binary.Write(&buf, binary.LittleEndian, uint32(36+len(s.Samples)+pad))
+	buf.WriteString("WAVEfmt ")
+	binary.Write(&buf, binary.LittleEndian, struct {
+		Size          uint32
+		Format        uint16
+		Channels      uint16
+		Rate          uint32
+		ByteRate      uint32
+		BlockAlign    uint16
+		BitsPerSample uint16
+	}{16, 1, 1, uint32(s.Rate), uint32(s.Rate), 1, 8})
+	buf.WriteString("data")
+	binary.Write(&buf, binary.LittleEndian, uint32(len(s.Samples)))
+	buf.Write(s.Samples)
+	if pad == 1 {
+		buf.WriteByte(0x80)
+	}
+
+	return buf.Bytes()
+}
+
+// SoundWAV converts a sound lump to WAV. WAV lumps are returned as is.
+func (r *Resources) SoundWAV(lump *Lump) ([]byte, error) {
+	data, err := lump.Read()
+	if err != nil {
+		return nil, err
+	}
+
+	if IsWAV(data) {
+		return data, nil
+	}
+
+	sound, err := DecodeDMX(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: unsupported sound format", lump.Path)
+	}
+
+	return sound.WAV(), nil
+}
+
+// Header reads up to n first bytes of the lump.
+func (l *Lump) Header(n int) ([]byte, error) {
+	if int64(n) > l.Size {
+		n = int(l.Size)
+	}
+
+	if l.file != nil {
+		rc, err := l.file.Open()
+		if err != nil {
+			return nil, err
+		}
+		defer rc.Close()
+
+		data := make([]byte, n)
+		_, err = io.ReadFull(rc, data)
+		return data, err
+	}
+
+	data := make([]byte, n)
+	_, err := l.archive.f.ReadAt(data, l.offset)
+	return data, err
+}
+
+// Sounds returns the names of DMX and WAV sounds: lumps outside of the
+// namespaces in WADs and the lumps in sounds/ in PK3s.
+func (r *Resources) Sounds() ([]string, error) {
+	seen := make(map[string]bool)
+	var result []string
+	for _, lump := range r.Lumps() {
+		if seen[lump.Name] || lump.Size < DMX_HEADER {
+			continue
+		}
+
+		switch {
+		case lump.file == nil && lump.Namespace == NS_GLOBAL:
+		case lump.file != nil && lump.Namespace == NS_SOUNDS:
+		default:
+			continue
+		}
+
+		header, err := lump.Header(12)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s: %w", lump.archive.Path, lump.Path, err)
+		}
+
+		if IsWAV(header) || isDMXHeader(header, lump.Size) {
+			seen[lump.Name] = true
+			result = append(result, lump.Name)
+		}
+	}
+
+	return result, nil
+}
+
+// vim: ai:ts=8:sw=8:noet:syntax=go
@@ -0,0 +1,342 @@
+/**
+ * Copyright 2025 kmeaw
+ *
+ * Licensed under the GNU Affero General Public License (AGPL).
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU Affero General Public License as published by the
+ * Free Software Foundation, version 3 of the License.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or
+ * FITNESS FOR A PARTICULAR PURPOSE.  See the GNU Affero General Public License
+ * for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+// Package wad reads Doom game data: IWAD/PWAD files and PK3 (zip) archives.
+package wad
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+)
+
+const (
+	NS_GLOBAL   = ""
+	NS_SPRITES  = "sprites"
+	NS_FLATS    = "flats"
+	NS_PATCHES  = "patches"
+	NS_TEXTURES = "textures"
+)
+
+// Lump is a single entry of an archive.
+type Lump struct {
+	// Name is the upper-case lump name, at most 8 characters long. For
+	// PK3 files it is the file name without the extension.
+	Name string `json:"name"`
+
+	// Path is the full path inside a PK3, or the same as Name for WADs.
+	Path string `json:"path"`
+
+	// Namespace is set by S_START/S_END style markers in WADs and by the
+	// top-level directory in PK3s.
+	Namespace string `json:"namespace,omitempty"`
+
+	Size int64 `json:"size"`
+
+	// Index is the position of the lump in its archive.
+	Index int `json:"index"`
+
+	archive *Archive
+	offset  int64
+	file    *zip.File
+}
+
+func (l *Lump) Archive() *Archive {
+	return l.archive
+}
+
+func (l *Lump) Read() ([]byte, error) {
+	if l.file != nil {
+		rc, err := l.file.Open()
+		if err != nil {
+			return nil, err
+		}
+		defer rc.Close()
+
+		return io.ReadAll(rc)
+	}
+
+	data := make([]byte, l.Size)
+	if _, err := l.archive.f.ReadAt(data, l.offset); err != nil {
+		return nil, fmt.Errorf("%s: cannot read %s: %w", l.archive.Path, l.Name, err)
+	}
+
+	return data, nil
+}
+
+// Archive is an opened WAD or PK3 file.
+type Archive struct {
+	Path  string
+	Kind  string // IWAD, PWAD or PK3
+	Lumps []*Lump
+
+	f *os.File
+}
+
+// Open detects the kind of the file by its signature and reads its
+// directory.
+func Open(filename string) (*Archive, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	a := &Archive{
+		Path: filename,
+		f:    f,
+	}
+
+	sig := make([]byte, 4)
+	if _, err := f.ReadAt(sig, 0); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("%s: cannot read the header: %w", filename, err)
+	}
+
+	switch string(sig) {
+	case "IWAD", "PWAD":
+		a.Kind = string(sig)
+		err = a.readWad(fi.Size())
+	case "PK\x03\x04":
+		a.Kind = "PK3"
+		err = a.readZip(fi.Size())
+	default:
+		err = fmt.Errorf("%s: unknown file signature %q", filename, sig)
+	}
+
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	return a, nil
+}
+
+func (a *Archive) Close() error {
+	return a.f.Close()
+}
+
+var wadMarkers = map[string]string{
+	"S_START": NS_SPRITES, "SS_START": NS_SPRITES,
+	"F_START": NS_FLATS, "FF_START": NS_FLATS,
+	"P_START": NS_PATCHES, "PP_START": NS_PATCHES,
+	"TX_START": NS_TEXTURES,
+}
+
+// Only these markers end a namespace, P1_END and the like end a section
+// inside of it.
+var wadEndMarkers = map[string]string{
+	"S_END": NS_SPRITES, "SS_END": NS_SPRITES,
+	"F_END": NS_FLATS, "FF_END": NS_FLATS,
+	"P_END": NS_PATCHES, "PP_END": NS_PATCHES,
+	"TX_END": NS_TEXTURES,
+}
+
+func (a *Archive) readWad(size int64) error {
+	var header struct {
+		Magic     [4]byte
+		NumLumps  int32
+		InfoTable int32
+	}
+
+	if err := binary.Read(io.NewSectionReader(a.f, 0, 12), binary.LittleEndian, &header); err != nil {
+		return fmt.Errorf("%s: cannot read the header: %w", a.Path, err)
+	}
+
+	if header.NumLumps < 0 || header.InfoTable < 0 || int64(header.InfoTable)+16*int64(header.NumLumps) > size {
+		return fmt.Errorf("%s: bad directory: %d lumps at %d", a.Path, header.NumLumps, header.InfoTable)
+	}
+
+	dir := make([]byte, 16*int(header.NumLumps))
+	if _, err := a.f.ReadAt(dir, int64(header.InfoTable)); err != nil {
+		return fmt.Errorf("%s: cannot read the directory: %w", a.Path, err)
+	}
+
+	namespace := NS_GLOBAL
+	for i := 0; i < int(header.NumLumps); i++ {
+		entry := dir[16*i : 16*i+16]
+		offset := int64(binary.LittleEndian.Uint32(entry[0:4]))
+		lumpSize := int64(binary.LittleEndian.Uint32(entry[4:8]))
+		name := entry[8:16]
+		if idx := bytes.IndexByte(name, 0); idx != -1 {
+			name = name[0:idx]
+		}
+
+		if offset+lumpSize > size {
+			return fmt.Errorf("%s: lump %q is out of bounds", a.Path, name)
+		}
+
+		lump := &Lump{
+			Name:    strings.ToUpper(string(name)),
+			Size:    lumpSize,
+			Index:   i,
+			archive: a,
+			offset:  offset,
+		}
+		lump.Path = lump.Name
+
+		if ns, ok := wadMarkers[lump.Name]; ok {
+			namespace = ns
+		} else if ns, ok := wadEndMarkers[lump.Name]; ok {
+			if ns == namespace {
+				namespace = NS_GLOBAL
+			}
+		} else if namespace != NS_GLOBAL && lumpSize == 0 &&
+			(strings.HasSuffix(lump.Name, "_START") || strings.HasSuffix(lump.Name, "_END")) {
+			// P1_START, F1_END and the like are markers, not patches
+			// or flats
+		} else {
+			lump.Namespace = namespace
+		}
+
+		a.Lumps = append(a.Lumps, lump)
+	}
+
+	return nil
+}
+
+func (a *Archive) readZip(size int64) error {
+	z, err := zip.NewReader(a.f, size)
+	if err != nil {
+		return fmt.Errorf("%s: %w", a.Path, err)
+	}
+
+	for _, file := range z.File {
+		if strings.HasSuffix(file.Name, "/") {
+			continue
+		}
+
+		name := path.Base(file.Name)
+		if idx := strings.IndexByte(name, '.'); idx != -1 {
+			name = name[0:idx]
+		}
+		if len(name) > 8 {
+			name = name[0:8]
+		}
+
+		lump := &Lump{
+			Name:    strings.ToUpper(name),
+			Path:    file.Name,
+			Size:    int64(file.UncompressedSize64),
+			Index:   len(a.Lumps),
+			archive: a,
+			file:    file,
+		}
+		if idx := strings.IndexByte(file.Name, '/'); idx != -1 {
+			lump.Namespace = strings.ToLower(file.Name[0:idx])
+		}
+
+		a.Lumps = append(a.Lumps, lump)
+	}
+
+	return nil
+}
+
+// Resources is a stack of archives in the load order: the engine's own
+// PK3, the IWAD and then the PWADs. Lumps from later archives override the
+// earlier ones.
+type Resources struct {
+	Archives []*Archive
+}
+
+// OpenResources opens all files or none of them.
+func OpenResources(filenames ...string) (*Resources, error) {
+	r := &Resources{}
+	for _, filename := range filenames {
+		a, err := Open(filename)
+		if err != nil {
+			r.Close()
+			return nil, err
+		}
+		r.Archives = append(r.Archives, a)
+	}
+
+	return r, nil
+}
+
+func (r *Resources) Close() error {
+	var result error
+	for _, a := range r.Archives {
+		if err := a.Close(); err != nil && result == nil {
+			result = err
+		}
+	}
+
+	return result
+}
+
+// Lumps returns the lumps of all archives in the load order.
+func (r *Resources) Lumps() []*Lump {
+	var result []*Lump
+	for _, a := range r.Archives {
+		result = append(result, a.Lumps...)
+	}
+
+	return result
+}
+
+// Find returns the last lump with the specified name in the namespace, or
+// in any namespace if ns is "*".
+func (r *Resources) Find(ns, name string) *Lump {
+	name = strings.ToUpper(name)
+	for i := len(r.Archives) - 1; i >= 0; i-- {
+		lumps := r.Archives[i].Lumps
+		for j := len(lumps) - 1; j >= 0; j-- {
+			if lumps[j].Name == name && (ns == "*" || lumps[j].Namespace == ns) {
+				return lumps[j]
+			}
+		}
+	}
+
+	return nil
+}
+
+// FindPath looks up a PK3 path, falling back to the lump name for WADs.
+// The archive the lookup comes from is searched first, then the others from
+// the last one, like Find does.
+func (r *Resources) FindPath(from *Archive, p string) *Lump {
+	var archives []*Archive
+	if from != nil {
+		archives = append(archives, from)
+	}
+	for i := len(r.Archives) - 1; i >= 0; i-- {
+		archives = append(archives, r.Archives[i])
+	}
+
+	for _, a := range archives {
+		for j := len(a.Lumps) - 1; j >= 0; j-- {
+			if strings.EqualFold(a.Lumps[j].Path, p) {
+				return a.Lumps[j]
+			}
+		}
+	}
+
+	return r.Find("*", p)
+}
+
+// vim: ai:ts=8:sw=8:noet:syntax=go
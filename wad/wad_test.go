@@ -0,0 +1,342 @@
+/**
+ * Copyright 2025 kmeaw
+ *
+ * Licensed under the GNU Affero General Public License (AGPL).
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU Affero General Public License as published by the
+ * Free Software Foundation, version 3 of the License.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or
+ * FITNESS FOR A PARTICULAR PURPOSE.  See the GNU Affero General Public License
+ * for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package wad
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+type testLump struct {
+	name string
+	data string
+}
+
+func writeWad(t *testing.T, filename, kind string, lumps []testLump) {
+	t.Helper()
+
+	var data bytes.Buffer
+	var dir bytes.Buffer
+	for _, lump := range lumps {
+		var name [8]byte
+		copy(name[:], lump.name)
+		binary.Write(&dir, binary.LittleEndian, []int32{int32(12 + data.Len()), int32(len(lump.data))})
+		dir.Write(name[:])
+		data.WriteString(lump.data)
+	}
+
+	var out bytes.Buffer
+	out.WriteString(kind)
+	binary.Write(&out, binary.LittleEndian, []int32{int32(len(lumps)), int32(12 + data.Len())})
+	out.Write(data.Bytes())
+	out.Write(dir.Bytes())
+
+	if err := os.WriteFile(filename, out.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func writePk3(t *testing.T, filename string, files []testLump) {
+	t.Helper()
+
+	f, err := os.Create(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	z := zip.NewWriter(f)
+	for _, file := range files {
+		w, err := z.Create(file.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write([]byte(file.data))
+	}
+	if err := z.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestOpenWad(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "test.wad")
+	writeWad(t, filename, "PWAD", []testLump{
+		{"MAP01", ""},
+		{"THINGS", "things"},
+		{"S_START", ""},
+		{"TROOA1", "sprite"},
+		{"S_END", ""},
+		{"dsgetpow", "sound"},
+	})
+
+	a, err := Open(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a.Close()
+
+	if a.Kind != "PWAD" {
+		t.Errorf("Kind = %q", a.Kind)
+	}
+
+	var names, namespaces []string
+	for _, lump := range a.Lumps {
+		names = append(names, lump.Name)
+		namespaces = append(namespaces, lump.Namespace)
+	}
+	if want := []string{"MAP01", "THINGS", "S_START", "TROOA1", "S_END", "DSGETPOW"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("names = %q, want %q", names, want)
+	}
+	if want := []string{"", "", "", NS_SPRITES, "", ""}; !reflect.DeepEqual(namespaces, want) {
+		t.Errorf("namespaces = %q, want %q", namespaces, want)
+	}
+
+	data, err := a.Lumps[3].Read()
+	if err != nil || string(data) != "sprite" {
+		t.Errorf("Read() = %q, %v", data, err)
+	}
+}
+
+func TestNestedMarkers(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "doom2.wad")
+	writeWad(t, filename, "IWAD", []testLump{
+		{"P_START", ""},
+		{"P1_START", ""},
+		{"WALL00_1", "patch"},
+		{"P1_END", ""},
+		{"P2_START", ""},
+		{"WALL01_1", "patch"},
+		{"P2_END", ""},
+		{"DOOR2_1", "patch"},
+		{"P_END", ""},
+		{"F_START", ""},
+		{"F1_START", ""},
+		{"FLOOR0_1", "flat"},
+		{"F1_END", ""},
+		{"NUKAGE1", "flat"},
+		{"F_END", ""},
+		{"ENDOOM", "text"},
+	})
+
+	a, err := Open(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a.Close()
+
+	namespaces := map[string]string{}
+	for _, lump := range a.Lumps {
+		namespaces[lump.Name] = lump.Namespace
+	}
+
+	want := map[string]string{
+		"P_START": "", "P1_START": "", "WALL00_1": NS_PATCHES, "P1_END": "",
+		"P2_START": "", "WALL01_1": NS_PATCHES, "P2_END": "", "DOOR2_1": NS_PATCHES, "P_END": "",
+		"F_START": "", "F1_START": "", "FLOOR0_1": NS_FLATS, "F1_END": "", "NUKAGE1": NS_FLATS, "F_END": "",
+		"ENDOOM": "",
+	}
+	if !reflect.DeepEqual(namespaces, want) {
+		t.Errorf("namespaces = %q, want %q", namespaces, want)
+	}
+}
+
+func TestOpenBroken(t *testing.T) {
+	dir := t.TempDir()
+
+	garbage := filepath.Join(dir, "garbage.wad")
+	os.WriteFile(garbage, []byte("not a wad at all"), 0644)
+
+	truncated := filepath.Join(dir, "truncated.wad")
+	writeWad(t, truncated, "IWAD", []testLump{{"PLAYPAL", "palette"}})
+	data, _ := os.ReadFile(truncated)
+	os.WriteFile(truncated, data[0:len(data)-4], 0644)
+
+	for _, filename := range []string{garbage, truncated, filepath.Join(dir, "missing.wad")} {
+		if a, err := Open(filename); err == nil {
+			a.Close()
+			t.Errorf("Open(%q) has succeeded", filename)
+		}
+	}
+}
+
+func TestResources(t *testing.T) {
+	dir := t.TempDir()
+
+	engine := filepath.Join(dir, "engine.pk3")
+	writePk3(t, engine, []testLump{
+		{"zscript.txt", `version "4.0"
+#include "zscript/actor.zs"
+#include "zscript/doom.zs"`},
+		{"zscript/actor.zs", `
+class Object native {}
+class Thinker : Object native play {}
+class Actor : Thinker native
+{
+	// class NotAClass : Actor {}
+	Default { Health 1000; }
+}
+struct Vector { int x; }
+class EventHandler : Thinker abstract {}`},
+		{"zscript/doom.zs", `
+class DoomImp : Actor replaces Nothing
+{
+	States { Spawn: TROO A 10; Loop; }
+}
+class Inventory : Actor abstract {}
+class Clip : Inventory {}
+extend class Actor { void Foo() {} }`},
+		{"sprites/TROOA1.png", "png"},
+	})
+
+	iwad := filepath.Join(dir, "doom2.wad")
+	writeWad(t, iwad, "IWAD", []testLump{
+		{"PLAYPAL", "palette"},
+		{"DSPISTOL", "sound"},
+	})
+
+	pwad := filepath.Join(dir, "mod.wad")
+	writeWad(t, pwad, "PWAD", []testLump{
+		{"DSPISTOL", "replacement"},
+		{"DECORATE", `
+#include "MOREACTS"
+/* actor Commented {} */
+Actor FireImp : DoomImp replaces DoomImp 31000
+{
+	+NOGRAVITY
+	States { Spawn: TROO A 10 A_Look; Loop; }
+}`},
+		{"MOREACTS", `actor "Quoted Name" {}
+actor Orphan : UnknownParent {}`},
+	})
+
+	r, err := OpenResources(engine, iwad, pwad)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	if lump := r.Find("*", "dspistol"); lump == nil || lump.Archive().Path != pwad {
+		t.Errorf("Find(DSPISTOL) = %+v, want the PWAD one", lump)
+	}
+	if lump := r.Find(NS_SPRITES, "TROOA1"); lump == nil || lump.Path != "sprites/TROOA1.png" {
+		t.Errorf("Find(sprites, TROOA1) = %+v", lump)
+	}
+	if lump := r.Find(NS_GLOBAL, "TROOA1"); lump != nil {
+		t.Errorf("Find(global, TROOA1) = %+v, want nil", lump)
+	}
+
+	actors, err := r.Actors()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var names []string
+	for _, actor := range actors {
+		names = append(names, actor.Name)
+	}
+	// Inventory is abstract, but its subclasses are still actors
+	if want := []string{"Clip", "DoomImp", "FireImp", "Orphan", "Quoted Name"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("actors = %q, want %q", names, want)
+	}
+
+	fireImp := actors[2]
+	want := ActorInfo{
+		Name:      "FireImp",
+		Parent:    "DoomImp",
+		Replaces:  "DoomImp",
+		DoomEdNum: 31000,
+		Source:    pwad + ":DECORATE",
+	}
+	if !reflect.DeepEqual(fireImp, want) {
+		t.Errorf("FireImp = %+v, want %+v", fireImp, want)
+	}
+}
+
+func TestFindPath(t *testing.T) {
+	dir := t.TempDir()
+
+	engine := filepath.Join(dir, "engine.pk3")
+	writePk3(t, engine, []testLump{{"actors/imp.txt", "engine"}})
+	mod := filepath.Join(dir, "mod.pk3")
+	writePk3(t, mod, []testLump{{"actors/imp.txt", "mod"}})
+	pwad := filepath.Join(dir, "mod.wad")
+	writeWad(t, pwad, "PWAD", []testLump{{"DECORATE", `#include "actors/imp.txt"`}})
+
+	r, err := OpenResources(engine, mod, pwad)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	// later archives override the earlier ones
+	for _, from := range []*Archive{nil, r.Archives[2]} {
+		if lump := r.FindPath(from, "Actors/Imp.txt"); lump == nil || lump.Archive().Path != mod {
+			t.Errorf("FindPath(%v) = %+v, want the mod's one", from, lump)
+		}
+	}
+	if lump := r.FindPath(r.Archives[0], "actors/imp.txt"); lump == nil || lump.Archive().Path != engine {
+		t.Errorf("FindPath(engine) = %+v, want the engine's one", lump)
+	}
+}
+
+func TestIncludeLoop(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "loop.wad")
+	writeWad(t, filename, "PWAD", []testLump{
+		{"DECORATE", `#include "DECORATE" actor Foo {}`},
+	})
+
+	r, err := OpenResources(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	actors, err := r.Actors()
+	if err != nil || len(actors) != 1 {
+		t.Errorf("Actors() = %+v, %v", actors, err)
+	}
+}
+
+func TestMissingInclude(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "missing.wad")
+	writeWad(t, filename, "PWAD", []testLump{
+		{"DECORATE", `#include "NOWHERE"
+#include "BROKEN"
+actor Foo {}`},
+		{"BROKEN", `#include "BROKEN2"`},
+		{"BROKEN2", `#include "NOWHERE"`},
+	})
+
+	r, err := OpenResources(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	actors, err := r.Actors()
+	if err != nil || len(actors) != 1 || actors[0].Name != "Foo" {
+		t.Errorf("Actors() = %+v, %v, want only Foo", actors, err)
+	}
+}
+
+// vim: ai:ts=8:sw=8:noet:syntax=go
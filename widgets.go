@@ -0,0 +1,198 @@
+/**
+ * Copyright 2025 kmeaw
+ *
+ * Licensed under the GNU Affero General Public License (AGPL).
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU Affero General Public License as published by the
+ * Free Software Foundation, version 3 of the License.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or
+ * FITNESS FOR A PARTICULAR PURPOSE.  See the GNU Affero General Public License
+ * for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"net/url"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/contrib/renders/multitemplate"
+)
+
+const (
+	// How often the overlay widgets are refreshed, a widget is sent to the
+	// overlay only when it has changed.
+	WIDGET_INTERVAL = time.Second
+
+	WIDGET_LIMIT       = 10
+	WIDGET_REDEMPTIONS = 50
+
+	// The page which has all the _widget_NAME.html partials.
+	WIDGET_TEMPLATE = "widget.html"
+)
+
+type LeaderboardEntry struct {
+	Name    string `json:"name"`
+	Balance int    `json:"balance"`
+}
+
+type GameStatus struct {
+	Target  string   `json:"target"`
+	State   string   `json:"state"`
+	Online  bool     `json:"online"`
+	Map     string   `json:"map,omitempty"`
+	Players []string `json:"players"`
+}
+
+// WidgetUpdate is sent to the overlay page of a widget.
+type WidgetUpdate struct {
+	Data interface{}   `json:"data"`
+	HTML template.HTML `json:"html"`
+}
+
+// Widgets serves the persistent overlay pages: the credits leaderboard, the
+// recent redemptions and the game status.
+type Widgets struct {
+	IRCBot    *IRCBot
+	RconPool  *RconPool
+	Templates multitemplate.Render
+
+	redemptions []TimelineEntry
+	mu          sync.Mutex
+}
+
+// Record keeps the redemptions from the timeline, see Timeline.OnAdd.
+func (w *Widgets) Record(entry TimelineEntry) {
+	if entry.Kind != "redemption" {
+		return
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.redemptions = append(w.redemptions, entry)
+	if len(w.redemptions) > WIDGET_REDEMPTIONS {
+		w.redemptions = append([]TimelineEntry(nil), w.redemptions[len(w.redemptions)-WIDGET_REDEMPTIONS:]...)
+	}
+}
+
+// Leaderboard returns up to n viewers with the most credits.
+func (w *Widgets) Leaderboard(n int) []LeaderboardEntry {
+	b := w.IRCBot
+	b.mu.Lock()
+	result := make([]LeaderboardEntry, 0, len(b.Balances))
+	for name, balance := range b.Balances {
+		if name != "" {
+			result = append(result, LeaderboardEntry{Name: name, Balance: balance})
+		}
+	}
+	b.mu.Unlock()
+
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Balance != result[j].Balance {
+			return result[i].Balance > result[j].Balance
+		}
+		return result[i].Name < result[j].Name
+	})
+
+	if len(result) > n {
+		result = result[:n]
+	}
+	return result
+}
+
+// Redemptions returns up to n most recent redemptions, the newest one first.
+func (w *Widgets) Redemptions(n int) []TimelineEntry {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if n > len(w.redemptions) {
+		n = len(w.redemptions)
+	}
+
+	result := make([]TimelineEntry, n)
+	for i := range result {
+		result[i] = w.redemptions[len(w.redemptions)-1-i]
+	}
+	return result
+}
+
+func (w *Widgets) Status(target string) (GameStatus, error) {
+	r := w.RconPool.Get(target)
+	if r == nil {
+		return GameStatus{}, fmt.Errorf("no such RCON target: %q", target)
+	}
+
+	state := r.State()
+	status := GameStatus{
+		Target: target,
+		State:  state.String(),
+		Online: state == RCON_ONLINE,
+	}
+	if status.Online {
+		status.Map, status.Players = r.GameInfo()
+	}
+
+	return status, nil
+}
+
+// Data returns the state of the widget, the query holds its parameters
+// (limit for the lists and target for the game status).
+func (w *Widgets) Data(name string, query url.Values) (interface{}, error) {
+	limit := WIDGET_LIMIT
+	if s := query.Get("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n <= 0 {
+			return nil, fmt.Errorf("invalid limit: %q", s)
+		}
+		limit = n
+	}
+
+	switch name {
+	case "leaderboard":
+		return w.Leaderboard(limit), nil
+	case "redemptions":
+		return w.Redemptions(limit), nil
+	case "status":
+		target := query.Get("target")
+		if target == "" {
+			target = DEFAULT_RCON_TARGET
+		}
+		return w.Status(target)
+	}
+
+	return nil, fmt.Errorf("no such widget: %q", name)
+}
+
+// Update renders the widget with its _widget_NAME.html template.
+func (w *Widgets) Update(name string, query url.Values) (*WidgetUpdate, error) {
+	data, err := w.Data(name, query)
+	if err != nil {
+		return nil, err
+	}
+
+	tmpl, ok := w.Templates[WIDGET_TEMPLATE]
+	if !ok {
+		return nil, fmt.Errorf("no template: %s", WIDGET_TEMPLATE)
+	}
+
+	buf := &bytes.Buffer{}
+	if err := tmpl.ExecuteTemplate(buf, "_widget_"+name, data); err != nil {
+		return nil, err
+	}
+
+	return &WidgetUpdate{Data: data, HTML: template.HTML(buf.String())}, nil
+}
+
+// vim: ai:ts=8:sw=8:noet:syntax=go
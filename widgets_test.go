@@ -0,0 +1,88 @@
+/**
+ * Copyright 2025 kmeaw
+ *
+ * Licensed under the GNU Affero General Public License (AGPL).
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU Affero General Public License as published by the
+ * Free Software Foundation, version 3 of the License.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or
+ * FITNESS FOR A PARTICULAR PURPOSE.  See the GNU Affero General Public License
+ * for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+package main
+
+import (
+	"html/template"
+	"net/url"
+	"testing"
+
+	"github.com/gin-gonic/contrib/renders/multitemplate"
+)
+
+func TestWidgets(t *testing.T) {
+	b := NewIRCBot(nil, nil)
+	b.Balances = map[string]int{"": 100, "alice": 7, "bob": 12, "carol": 7}
+
+	timeline := NewTimeline()
+	w := &Widgets{
+		IRCBot:   b,
+		RconPool: NewRconPool(),
+		Templates: multitemplate.Render{
+			WIDGET_TEMPLATE: template.Must(template.New(WIDGET_TEMPLATE).Parse(`
+{{ define "_widget_leaderboard" }}{{ range . }}{{ .Name }}={{ .Balance }};{{ end }}{{ end }}
+{{ define "_widget_redemptions" }}{{ range . }}{{ .From }}:{{ .Text }};{{ end }}{{ end }}
+{{ define "_widget_status" }}{{ .Target }} {{ .State }}{{ end }}
+`)),
+		},
+	}
+	timeline.OnAdd(w.Record)
+
+	for _, entry := range []TimelineEntry{
+		{Kind: "redemption", From: "alice", Text: "Summon an imp"},
+		{Kind: "rcon", Text: "summon DoomImp"},
+		{Kind: "redemption", From: "<bob>", Text: "Heal"},
+	} {
+		timeline.Add(entry)
+	}
+
+	for _, tc := range []struct {
+		widget, query, html string
+	}{
+		{"leaderboard", "", "bob=12;alice=7;carol=7;"},
+		{"leaderboard", "limit=2", "bob=12;alice=7;"},
+		{"redemptions", "", "&lt;bob&gt;:Heal;alice:Summon an imp;"},
+		{"redemptions", "limit=1", "&lt;bob&gt;:Heal;"},
+		{"status", "", "default disconnected"},
+	} {
+		query, _ := url.ParseQuery(tc.query)
+		update, err := w.Update(tc.widget, query)
+		if err != nil {
+			t.Errorf("Update(%q, %q): %s", tc.widget, tc.query, err)
+			continue
+		}
+		if string(update.HTML) != tc.html {
+			t.Errorf("Update(%q, %q) = %q, want %q", tc.widget, tc.query, update.HTML, tc.html)
+		}
+	}
+
+	for _, tc := range []struct {
+		widget, query string
+	}{
+		{"nope", ""},
+		{"leaderboard", "limit=-1"},
+		{"status", "target=nope"},
+	} {
+		query, _ := url.ParseQuery(tc.query)
+		if _, err := w.Update(tc.widget, query); err == nil {
+			t.Errorf("Update(%q, %q) has succeeded", tc.widget, tc.query)
+		}
+	}
+}
+
+// vim: ai:ts=8:sw=8:noet:syntax=go